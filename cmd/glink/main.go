@@ -0,0 +1,288 @@
+// glink runs, validates and inspects declarative jobs
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/discretemind/glink"
+	"github.com/discretemind/glink/rdp"
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/discretemind/glink/utils/log"
+	"go.uber.org/zap"
+)
+
+const usage = `Usage: glink <command> [arguments]
+
+Commands:
+  run [-restore <id|latest>] <job.yaml>   run the job until interrupted
+  validate <job.yaml>                     check the job definition
+  plan <job.yaml>                         print the job graph
+  checkpoints list <job.yaml>             list checkpoints of the job
+  checkpoints restore <job.yaml> <id>     run the job restored from the checkpoint
+  keygen                                  generate a cluster identity key
+  master [-addr <host:port>] -key <key> [-tokens <t1,t2>] [-allow <id1,id2>] [-job <job.yaml>]
+         [-quanta <n>] [-rebalance=false] [-cpu-overload <%>] [-mem-overload <%>]
+                                          run the cluster master until interrupted
+  worker [-token <token>] <host:port> <cluster id>
+                                          run jobs of the master until interrupted
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "run":
+		err = runCmd(args)
+	case "validate":
+		err = validateCmd(args)
+	case "plan":
+		err = planCmd(args)
+	case "checkpoints":
+		err = checkpointsCmd(args)
+	case "keygen":
+		err = keygenCmd(args)
+	case "master":
+		err = masterCmd(args)
+	case "worker":
+		err = workerCmd(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func load(args []string) (*glink.Config, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("job definition path is expected")
+	}
+	return glink.LoadConfig(args[0])
+}
+
+type manager struct {
+}
+
+func (m *manager) Error(err error) {
+	log.Error("job error", zap.Error(err))
+}
+
+func runCmd(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	restore := flags.String("restore", "", "checkpoint id or latest")
+	_ = flags.Parse(args)
+
+	cfg, err := load(flags.Args())
+	if err != nil {
+		return err
+	}
+	var id uint64
+	switch *restore {
+	case "":
+		return run(cfg, nil)
+	case "latest":
+	default:
+		if id, err = strconv.ParseUint(*restore, 10, 64); err != nil || id == 0 {
+			return fmt.Errorf("invalid checkpoint id %q", *restore)
+		}
+	}
+	return run(cfg, &id)
+}
+
+func run(cfg *glink.Config, restore *uint64) error {
+	job, err := glink.Build(cfg, &manager{})
+	if err != nil {
+		return err
+	}
+	if restore != nil {
+		if err = job.Restore(*restore); err != nil {
+			_ = job.Stop()
+			return err
+		}
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go job.Run()
+	log.Info("job started", zap.String("name", cfg.Name))
+	<-stop
+	log.Info("stopping job", zap.String("name", cfg.Name))
+	return job.Stop()
+}
+
+func validateCmd(args []string) error {
+	cfg, err := load(args)
+	if err != nil {
+		return err
+	}
+	if err = cfg.Validate(); err != nil {
+		return err
+	}
+	fmt.Printf("job %s is valid\n", cfg.Name)
+	return nil
+}
+
+func planCmd(args []string) error {
+	cfg, err := load(args)
+	if err != nil {
+		return err
+	}
+	fmt.Print(cfg.Plan())
+	return cfg.Validate()
+}
+
+func checkpointsCmd(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("checkpoints list or checkpoints restore is expected")
+	}
+	switch args[0] {
+	case "list":
+		cfg, err := load(args[1:])
+		if err != nil {
+			return err
+		}
+		storage, err := cfg.Storage()
+		if err != nil {
+			return err
+		}
+		list, err := storage.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tOWNER\tTIME\tSIZE")
+		for _, info := range list {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\n", info.ID, info.Owner, info.Time.Format(time.RFC3339), info.Size)
+		}
+		return w.Flush()
+	case "restore":
+		if len(args) != 3 {
+			return fmt.Errorf("job definition path and checkpoint id are expected")
+		}
+		cfg, err := load(args[1:2])
+		if err != nil {
+			return err
+		}
+		id, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil || id == 0 {
+			return fmt.Errorf("invalid checkpoint id %q", args[2])
+		}
+		return run(cfg, &id)
+	}
+	return fmt.Errorf("unknown checkpoints command %q", args[0])
+}
+
+func keygenCmd(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("keygen takes no arguments")
+	}
+	key := crypto.GeneratePrivateKey()
+	fmt.Printf("private key: %s\n", key.Base64())
+	fmt.Printf("cluster id:  %s\n", key.Certificate().String())
+	return nil
+}
+
+func masterCmd(args []string) error {
+	flags := flag.NewFlagSet("master", flag.ExitOnError)
+	addr := flags.String("addr", ":7000", "UDP address to listen")
+	keyValue := flags.String("key", os.Getenv("GLINK_CLUSTER_KEY"), "cluster private key generated by keygen")
+	tokens := flags.String("tokens", os.Getenv("GLINK_CLUSTER_TOKENS"), "comma separated tokens authorizing workers")
+	allow := flags.String("allow", "", "comma separated certificates of workers allowed without a token")
+	jobPath := flags.String("job", "", "job definition started on joined workers")
+	quanta := flags.Uint("quanta", 64, "number of quanta the key space is split into. 0 - keys aren't partitioned")
+	rebalance := flags.Bool("rebalance", true, "move quanta, when workers join, leave or get overloaded")
+	cpuOverload := flags.Uint("cpu-overload", 0, "CPU usage in percent, which overloads the worker. 0 - disabled")
+	memOverload := flags.Uint("mem-overload", 0, "used memory in percent, which overloads the worker. 0 - disabled")
+	_ = flags.Parse(args)
+
+	key, err := crypto.PrivateKeyFromBase64(*keyValue)
+	if err != nil {
+		return fmt.Errorf("invalid cluster key: %v", err)
+	}
+	m := rdp.Master(key, rdp.FromString(glink.Version), log.Get())
+	for _, token := range split(*tokens) {
+		m.AllowToken(token)
+	}
+	for _, id := range split(*allow) {
+		cert := crypto.CertificateFromString(id)
+		if cert == (crypto.Certificate{}) {
+			return fmt.Errorf("invalid worker certificate %q", id)
+		}
+		m.AllowWorker(cert)
+	}
+	if *quanta > 0 {
+		if err = m.SetQuantumSpace(uint32(*quanta)); err != nil {
+			return err
+		}
+		m.SetRebalance(rdp.RebalanceConfig{
+			Auto:        *rebalance,
+			CpuOverload: uint32(*cpuOverload) * 100,
+			MemOverload: uint32(*memOverload),
+		})
+	}
+	if *jobPath != "" {
+		cfg, err := glink.LoadConfig(*jobPath)
+		if err != nil {
+			return err
+		}
+		config, err := cfg.Marshal()
+		if err != nil {
+			return err
+		}
+		m.OnWorkerEvent(func(e rdp.WorkerEvent) {
+			if e.Type != rdp.WorkerJoined {
+				return
+			}
+			if err := m.Start(e.Worker.ClusterIndex, config); err != nil {
+				log.Error("can't start job", zap.Uint16("index", e.Worker.ClusterIndex), zap.Error(err))
+			}
+		})
+	}
+	if err = m.Listen(*addr); err != nil {
+		return err
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	log.Info("master started", zap.String("addr", m.Addr().String()), zap.String("cluster", m.ClusterID()))
+	return m.Serve(ctx)
+}
+
+func workerCmd(args []string) error {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	token := flags.String("token", os.Getenv("GLINK_CLUSTER_TOKEN"), "token authorizing the worker")
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		return fmt.Errorf("master address and cluster id are expected")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return glink.ClusterManager(flags.Arg(0), *token).Connect(ctx, flags.Arg(1))
+}
+
+// split returns the non-empty values of the comma separated list
+func split(list string) (res []string) {
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			res = append(res, value)
+		}
+	}
+	return
+}
@@ -2,9 +2,10 @@ package main
 
 import (
 	"fmt"
-	"github.com/discretemind/glink"
-	"github.com/discretemind/glink/stream"
 	"time"
+
+	"github.com/discretemind/glink"
+	"github.com/discretemind/glink/stream/typed"
 )
 
 type packet struct {
@@ -12,8 +13,8 @@ type packet struct {
 	Num  int
 }
 
-func input(done chan bool) func(input stream.IInputStream) {
-	return func(input stream.IInputStream) {
+func input(done chan bool) func(input typed.IInputStream[packet]) {
+	return func(input typed.IInputStream[packet]) {
 		go func() {
 			i := 0
 			for i < 10 {
@@ -42,32 +43,29 @@ func main() {
 	fmt.Println("Start")
 	done := make(chan bool, 1)
 	job := glink.Standalone()
-	inputSet := job.Task("simple", input(done), func(i interface{}) time.Time {
+	inputSet := typed.From[packet](job.Task("simple", typed.Input(input(done)), func(i interface{}) time.Time {
 		return time.Now()
-	})
-	set2 := inputSet.Map(func(value interface{}) (interface{}, error) {
-		p := value.(packet)
+	}))
+	set2 := typed.Map(inputSet, func(p packet) (packet, error) {
 		p.Num *= 2
 		return p, nil
 	}).Name("All items")
 
 	set2.Print()
-	set3 := set2.FilterByField("Type", "type X").Map(func(value interface{}) (interface{}, error) {
-		p := value.(packet)
+	set3 := typed.Map(typed.From[packet](set2.Raw().FilterByField("Type", "type X")), func(p packet) (packet, error) {
 		p.Num *= 2
 		return p, nil
 	}).Name("Filtered X")
 
 	set3.Print()
-	set3.Filter(func(value interface{}) bool {
-		return value.(packet).Type == "type Y"
-	}).Map(func(value interface{}) (interface{}, error) {
-		p := value.(packet)
+	filteredY := typed.Filter(set3, func(p packet) bool {
+		return p.Type == "type Y"
+	})
+	typed.Map(filteredY, func(p packet) (packet, error) {
 		p.Num *= 10
 		return p, nil
 	}).Name("Filtered Y").Print()
 
-
 	go job.Run()
 
 	fmt.Println("Wait")
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/discretemind/glink"
+	"github.com/discretemind/glink/plugin/socket"
+)
+
+// Counts words of lines received on localhost:9999. Feed it with `nc localhost 9999`
+func main() {
+	src := socket.Source(socket.SourceConfig{
+		Network: "tcp",
+		Addr:    "localhost:9999",
+		Listen:  true,
+	})
+	defer src.Close()
+
+	counts := make(map[string]int)
+	job := glink.Standalone()
+	job.Source("lines", src).Map(func(value interface{}) (interface{}, error) {
+		line := value.(string)
+		words := make(map[string]int)
+		for _, w := range strings.Fields(strings.ToLower(line)) {
+			counts[w]++
+			words[w] = counts[w]
+		}
+		return words, nil
+	}).Name("Words Count").Print()
+
+	go job.Run()
+
+	fmt.Println("Listening on localhost:9999")
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
@@ -0,0 +1,345 @@
+package glink
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/stream/checkpoint"
+	"github.com/discretemind/glink/stream/expr"
+	"github.com/discretemind/glink/stream/field"
+	"github.com/discretemind/glink/utils/log"
+)
+
+// ISource pushes values into the task input. Sources implementing io.Closer are closed when the job stops
+type ISource interface {
+	// Run blocks until the source is finished or closed. Errors and panics of the source goroutines are returned,
+	// so the task is restarted by the restart strategy
+	Run(input stream.IInputStream) error
+}
+
+type SourceFactory func(options Options) (ISource, error)
+
+// SinkFactory creates sinks. Sinks implementing io.Closer are closed when the job stops
+type SinkFactory func(options Options) (stream.ISink, error)
+
+// OptionsValidator checks plugin options without creating the plugin, so Validate reports them before the job
+// is built. Options of plugins without a validator are checked by their factories only
+type OptionsValidator func(options Options) error
+
+var (
+	pluginsLock      sync.RWMutex
+	sources          = make(map[string]SourceFactory)
+	sinks            = make(map[string]SinkFactory)
+	sourceValidators = make(map[string]OptionsValidator)
+	sinkValidators   = make(map[string]OptionsValidator)
+)
+
+func RegisterSource(kind string, f SourceFactory) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	sources[kind] = f
+}
+
+func RegisterSink(kind string, f SinkFactory) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	sinks[kind] = f
+}
+
+func RegisterSourceValidator(kind string, v OptionsValidator) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	sourceValidators[kind] = v
+}
+
+func RegisterSinkValidator(kind string, v OptionsValidator) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	sinkValidators[kind] = v
+}
+
+// validateOptions runs the validator of the plugin, when it's registered
+func validateOptions(validators map[string]OptionsValidator, kind string, options Options) error {
+	pluginsLock.RLock()
+	v, ok := validators[kind]
+	pluginsLock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return v(options)
+}
+
+func sourceFactory(kind string) (f SourceFactory, ok bool) {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	f, ok = sources[kind]
+	return
+}
+
+func sinkFactory(kind string) (f SinkFactory, ok bool) {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	f, ok = sinks[kind]
+	return
+}
+
+// Validate checks the job graph and returns ValidationErrors with all problems found
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+	add := func(key, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.Name == "" {
+		add("name", "is required")
+	}
+	if c.CheckpointInterval < 0 {
+		add("checkpointInterval", "should not be negative")
+	}
+	if c.CheckpointRetain < 0 {
+		add("checkpointRetain", "should not be negative")
+	}
+	switch c.Restart.Strategy {
+	case "", RestartNone, RestartFixedDelay:
+	default:
+		add("restart.strategy", "unknown strategy %q, expected %s or %s", c.Restart.Strategy, RestartNone, RestartFixedDelay)
+	}
+	if c.Restart.Attempts < 0 {
+		add("restart.attempts", "should not be negative")
+	}
+	switch c.Logging.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		add("logging.level", "unknown level %q", c.Logging.Level)
+	}
+
+	names := make(map[string]string)
+	named := func(key, name string) {
+		if name == "" {
+			add(key+".name", "is required")
+			return
+		}
+		if other, ok := names[name]; ok {
+			add(key+".name", "%q is already used by %s", name, other)
+			return
+		}
+		names[name] = key
+	}
+	input := func(key, name string) {
+		if name == "" {
+			add(key+".input", "is required")
+		} else if _, ok := names[name]; !ok {
+			add(key+".input", "unknown source or operator %q. Inputs should be declared before use", name)
+		}
+	}
+
+	if len(c.Sources) == 0 {
+		add("sources", "at least one source is required")
+	}
+	for i, s := range c.Sources {
+		key := fmt.Sprintf("sources[%d]", i)
+		named(key, s.Name)
+		if _, ok := sourceFactory(s.Type); !ok {
+			add(key+".type", "unknown source type %q", s.Type)
+		} else if err := validateOptions(sourceValidators, s.Type, s.Options); err != nil {
+			errs = append(errs, prefixed(key+".options", err))
+		}
+		if s.Timestamp != "" {
+			if _, err := field.Compile(s.Timestamp); err != nil {
+				add(key+".timestamp", err.Error())
+			}
+		}
+	}
+
+	for i, o := range c.Operators {
+		key := fmt.Sprintf("operators[%d]", i)
+		input(key, o.Input)
+		switch o.Type {
+		case OperatorFilter:
+			if _, err := expr.Compile(o.Expr); err != nil {
+				add(key+".expr", err.Error())
+			}
+		case OperatorFilterField:
+			op := field.Operator(o.Op)
+			if op == "" {
+				op = field.Eq
+			}
+			if _, err := field.NewCondition(o.Field, op, o.Value); err != nil {
+				add(key, err.Error())
+			}
+		case OperatorMap:
+			if len(o.Fields) == 0 {
+				add(key+".fields", "at least one field is required")
+			}
+			for name, src := range o.Fields {
+				if _, err := expr.Compile(src); err != nil {
+					add(key+".fields."+name, err.Error())
+				}
+			}
+		default:
+			add(key+".type", "unknown operator type %q", o.Type)
+		}
+		named(key, o.Name)
+	}
+
+	if len(c.Sinks) == 0 {
+		add("sinks", "at least one sink is required")
+	}
+	for i, s := range c.Sinks {
+		key := fmt.Sprintf("sinks[%d]", i)
+		input(key, s.Input)
+		if _, ok := sinkFactory(s.Type); !ok {
+			add(key+".type", "unknown sink type %q", s.Type)
+		} else if err := validateOptions(sinkValidators, s.Type, s.Options); err != nil {
+			errs = append(errs, prefixed(key+".options", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Key < errs[j].Key
+		})
+		return errs
+	}
+	return nil
+}
+
+// prefixed makes plugin option errors point to the full config key
+func prefixed(key string, err error) *ValidationError {
+	var vErr *ValidationError
+	if errors.As(err, &vErr) {
+		return &ValidationError{Key: key + "." + vErr.Key, Message: vErr.Message}
+	}
+	return &ValidationError{Key: key, Message: err.Error()}
+}
+
+// Storage opens the checkpoint storage of the job
+func (c *Config) Storage() (checkpoint.Storage, error) {
+	if c.CheckpointDir == "" {
+		return nil, fmt.Errorf("checkpoint directory is not configured")
+	}
+	return checkpoint.Dir(c.CheckpointDir, c.CheckpointRetain)
+}
+
+// Build validates the config and creates the job graph
+func Build(cfg *Config, manager IManager) (ITaskSetup, error) {
+	j, err := build(cfg, manager)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func build(cfg *Config, manager IManager) (*job, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Logging.Level != "" || cfg.Logging.Production {
+		if _, err := log.InitLevel(cfg.Logging.Production, cfg.Logging.Level); err != nil {
+			return nil, prefixed("logging.level", err)
+		}
+	}
+
+	j := newJob(manager)
+	j.name = cfg.Name
+	j.checkpointInterval = cfg.CheckpointInterval.Duration()
+	j.restart = cfg.Restart
+	j.metricsAddr = cfg.Metrics.Addr
+
+	fail := func(err error) (*job, error) {
+		_ = j.Stop()
+		return nil, err
+	}
+
+	if cfg.CheckpointDir != "" {
+		storage, err := cfg.Storage()
+		if err != nil {
+			return nil, prefixed("checkpointDir", err)
+		}
+		list, err := storage.List()
+		if err != nil {
+			return nil, prefixed("checkpointDir", err)
+		}
+		if len(list) > 0 {
+			j.checkpointId = list[len(list)-1].ID
+		}
+		j.storage = storage
+	}
+
+	streams := make(map[string]*stream.DataStream)
+	for i, s := range cfg.Sources {
+		key := fmt.Sprintf("sources[%d]", i)
+		factory, _ := sourceFactory(s.Type)
+		src, err := factory(s.Options)
+		if err != nil {
+			return fail(prefixed(key+".options", err))
+		}
+		if c, ok := src.(io.Closer); ok {
+			j.sources = append(j.sources, c)
+		}
+		if s.Timestamp != "" {
+			streams[s.Name] = j.Source(s.Name, src, eventTime(field.MustCompile(s.Timestamp)))
+		} else {
+			streams[s.Name] = j.Source(s.Name, src)
+		}
+	}
+
+	for _, o := range cfg.Operators {
+		in := streams[o.Input]
+		var out *stream.DataStream
+		switch o.Type {
+		case OperatorFilter:
+			out, _ = in.FilterExpr(o.Expr)
+		case OperatorFilterField:
+			op := field.Operator(o.Op)
+			if op == "" {
+				op = field.Eq
+			}
+			out = in.FilterByField(o.Field, o.Value, op)
+		case OperatorMap:
+			out, _ = in.MapExpr(o.Fields)
+		}
+		streams[o.Name] = out.Name(o.Name)
+	}
+
+	for i, s := range cfg.Sinks {
+		key := fmt.Sprintf("sinks[%d]", i)
+		factory, _ := sinkFactory(s.Type)
+		sink, err := factory(s.Options)
+		if err != nil {
+			return fail(prefixed(key+".options", err))
+		}
+		if c, ok := sink.(io.Closer); ok {
+			j.sinks = append(j.sinks, c)
+		}
+		streams[s.Input].To(sink)
+	}
+	return j, nil
+}
+
+// eventTime reads the event time from time values, RFC3339 strings or unix milliseconds
+func eventTime(path *field.Path) func(value interface{}) time.Time {
+	return func(value interface{}) time.Time {
+		v, _ := path.Get(value)
+		switch t := v.(type) {
+		case time.Time:
+			return t
+		case string:
+			if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+				return parsed
+			}
+		case float64:
+			return time.Unix(0, int64(t*float64(time.Millisecond)))
+		case int64:
+			return time.Unix(0, t*int64(time.Millisecond))
+		case int:
+			return time.Unix(0, int64(t)*int64(time.Millisecond))
+		}
+		return time.Now()
+	}
+}
@@ -1,31 +1,150 @@
 package glink
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+
 	"github.com/discretemind/glink/rdp"
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/discretemind/glink/utils/log"
 	"go.uber.org/zap"
 )
 
+// Version of the cluster protocol implemented by workers and the master
+const Version = "1.1.0"
+
 type clusterManager struct {
+	sync.Mutex
 	url   string
 	token string
+	// job is started by the master with the config
+	job    *job
+	config []byte
 }
 
+// ClusterManager connects the job to the master. The token authorizes the worker to join the cluster,
+// unless the master allows it by the certificate of its GLINK_WORKER_KEY
 func ClusterManager(url string, token string) (res *clusterManager) {
 	res = &clusterManager{
-		url: url,
+		url:   url,
+		token: token,
 	}
 	return
 }
 
-func (m *clusterManager) Connect(ctx context.Context, modelId string) {
+// Connect joins the cluster and runs jobs started by the master on quanta assigned to the worker,
+// until the context is done. The running job is stopped then
+func (m *clusterManager) Connect(ctx context.Context, clusterId string) error {
 	l, _ := zap.NewProduction()
-	client := rdp.Client(rdp.FromString("1.0.0"), l)
-	if err := client.Connect(context.Background(), m.url, modelId); err != nil {
-		l.Error("connection error", zap.Error(err))
+	client := rdp.Client(rdp.FromString(Version), l)
+	if value := os.Getenv("GLINK_WORKER_KEY"); value != "" {
+		key, err := crypto.PrivateKeyFromBase64(value)
+		if err != nil {
+			return fmt.Errorf("invalid worker key: %v", err)
+		}
+		client.SetKey(key, l)
+	}
+	if m.token != "" {
+		client.SetToken(m.token)
 	}
+	client.OnStart(func(config []byte) error {
+		return m.start(config, checkpointOwner(client.ID()), client.Quanta())
+	})
+	client.OnStop(m.stop)
+	// jobs started before the first assignment aren't partitioned, since the space of quanta isn't known yet
+	client.Quanta().OnAssign(func(quanta []quantum.Quantum) {
+		if j := m.current(); j != nil {
+			m.partition(j, client.Quanta())
+		}
+	})
+	client.OnAssignQuanta(func(quanta []quantum.Quantum, checkpoint uint64) error {
+		if j := m.current(); j != nil {
+			return j.restoreQuanta(quanta, checkpoint)
+		}
+		return nil
+	})
+	client.OnReleaseQuanta(func(quanta []quantum.Quantum) (uint64, error) {
+		if j := m.current(); j != nil {
+			return j.releaseQuanta(quanta)
+		}
+		return 0, nil
+	})
+
+	err := client.Connect(ctx, m.url, clusterId)
+	if sErr := m.stop(); sErr != nil && err == nil {
+		err = sErr
+	}
+	return err
 }
 
-func (m *clusterManager) Error(err error) {
+// checkpointOwner names checkpoints of the worker in the storage shared by the cluster
+func checkpointOwner(id crypto.Certificate) string {
+	return hex.EncodeToString(id[:])
+}
+
+func (m *clusterManager) current() *job {
+	m.Lock()
+	defer m.Unlock()
+	return m.job
+}
+
+// start builds the job of the config and runs it on the quanta of the worker. The running job is kept,
+// when the config is repeated, and is replaced by a new one
+func (m *clusterManager) start(config []byte, owner string, quanta *quantum.Set) error {
+	m.Lock()
+	running := m.job != nil && bytes.Equal(m.config, config)
+	m.Unlock()
+	if running {
+		return nil
+	}
+	if err := m.stop(); err != nil {
+		log.Warn("can't stop replaced job", zap.Error(err))
+	}
 
+	cfg, err := ParseConfig(config)
+	if err != nil {
+		return err
+	}
+	j, err := build(cfg, m)
+	if err != nil {
+		return err
+	}
+	j.owner = owner
+	m.Lock()
+	m.job, m.config = j, config
+	m.Unlock()
+	m.partition(j, quanta)
+	go j.Run()
+	log.Info("job started", zap.String("name", cfg.Name))
+	return nil
+}
+
+// partition limits keyed streams of the job to the quanta, once the master has assigned them.
+// An empty space would drop all keys
+func (m *clusterManager) partition(j *job, quanta *quantum.Set) {
+	if quanta.Space() != 0 {
+		j.setQuanta(quanta)
+	}
+}
+
+// stop closes sources of the running job and completes its final checkpoint
+func (m *clusterManager) stop() error {
+	m.Lock()
+	j := m.job
+	m.job, m.config = nil, nil
+	m.Unlock()
+	if j == nil {
+		return nil
+	}
+	log.Info("stopping job", zap.String("name", j.name))
+	return j.Stop()
+}
+
+func (m *clusterManager) Error(err error) {
+	log.Error("job error", zap.Error(err))
 }
@@ -0,0 +1,102 @@
+package glink
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/rdp"
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// clusterSink collects events of jobs started by the master
+type clusterSink struct {
+	sync.Mutex
+	events []*stream.Event
+}
+
+func (s *clusterSink) Push(event *stream.Event) {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *clusterSink) len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.events)
+}
+
+func TestClusterRunsJobs(t *testing.T) {
+	out := &clusterSink{}
+	RegisterSource("cluster-test", func(options Options) (ISource, error) {
+		return sliceSource{map[string]interface{}{"n": float64(1)}, map[string]interface{}{"n": float64(2)}}, nil
+	})
+	RegisterSink("cluster-test", func(options Options) (stream.ISink, error) {
+		return out, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := rdp.Master(crypto.GeneratePrivateKey(), rdp.FromString(Version), zap.NewNop())
+	assert.NoError(t, m.SetQuantumSpace(4))
+	m.SetRebalance(rdp.RebalanceConfig{Auto: true})
+	assert.NoError(t, m.Listen("127.0.0.1:0"))
+	go func() {
+		assert.NoError(t, m.Serve(ctx))
+	}()
+	cm := ClusterManager(m.Addr().String(), "")
+	go func() {
+		assert.NoError(t, cm.Connect(ctx, m.ClusterID()))
+	}()
+	assert.Eventually(t, func() bool {
+		return len(m.Workers()) == 1
+	}, time.Second, 5*time.Millisecond)
+	index := m.Workers()[0].ClusterIndex
+	job := func(status rdp.JobStatus) func() bool {
+		return func() bool {
+			w, _ := m.Worker(index)
+			return w.Job == status
+		}
+	}
+
+	config := []byte(`{"name":"cluster","sources":[{"name":"in","type":"cluster-test"}],` +
+		`"sinks":[{"name":"out","type":"cluster-test","input":"in"}]}`)
+	assert.NoError(t, m.Start(index, config))
+	assert.Eventually(t, job(rdp.JobRunning), 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 2, out.len())
+	// keyed streams of the job are limited to the assigned quanta
+	assert.Eventually(t, func() bool {
+		j := cm.current()
+		j.Lock()
+		defer j.Unlock()
+		return j.quanta != nil && j.quanta.Space() == 4 && len(j.quanta.Quanta()) == 4
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, m.Stop(index))
+	assert.Eventually(t, job(rdp.JobStopped), time.Second, 5*time.Millisecond)
+
+	// invalid jobs are reported by the worker
+	assert.NoError(t, m.Start(index, []byte(`{"name":"broken"}`)))
+	assert.Eventually(t, job(rdp.JobFailed), time.Second, 5*time.Millisecond)
+	w, _ := m.Worker(index)
+	assert.Contains(t, w.JobError, "sources")
+}
+
+func TestClusterPartitionsAssignedQuanta(t *testing.T) {
+	m := ClusterManager("", "")
+	j := newJob(&manager{})
+	set := quantum.NewSet()
+	m.partition(j, set)
+	assert.Nil(t, j.quanta, "keys aren't dropped, until quanta are assigned")
+
+	q, _ := quantum.Of("a", 2)
+	assert.NoError(t, set.Assign(q))
+	m.partition(j, set)
+	assert.Equal(t, set, j.quanta)
+}
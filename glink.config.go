@@ -1,5 +1,270 @@
 package glink
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a declarative job definition. It's loaded from YAML or JSON files, overridden by environment
+// variables and carried by the cluster StartCmd in the same JSON format
 type Config struct {
-	Name string
+	Name               string   `json:"name"`
+	CheckpointInterval Duration `json:"checkpointInterval,omitempty"`
+	// Directory of the checkpoint storage. Checkpoints only notify sinks when empty
+	CheckpointDir string `json:"checkpointDir,omitempty"`
+	// Number of checkpoints kept in the storage. 0 - all
+	CheckpointRetain int              `json:"checkpointRetain,omitempty"`
+	Restart          RestartConfig    `json:"restart,omitempty"`
+	Logging          LoggingConfig    `json:"logging,omitempty"`
+	Metrics          MetricsConfig    `json:"metrics,omitempty"`
+	Sources          []SourceConfig   `json:"sources"`
+	Operators        []OperatorConfig `json:"operators,omitempty"`
+	Sinks            []SinkConfig     `json:"sinks"`
+}
+
+const (
+	RestartNone       = "none"
+	RestartFixedDelay = "fixed-delay"
+)
+
+type RestartConfig struct {
+	// "none" or "fixed-delay"
+	Strategy string `json:"strategy,omitempty"`
+	// Restarts of a failed source. 0 - unlimited
+	Attempts int      `json:"attempts,omitempty"`
+	Delay    Duration `json:"delay,omitempty"`
+}
+
+type LoggingConfig struct {
+	// debug, info, warn or error
+	Level      string `json:"level,omitempty"`
+	Production bool   `json:"production,omitempty"`
+}
+
+type MetricsConfig struct {
+	// Prometheus endpoint address. Disabled when empty
+	Addr string `json:"addr,omitempty"`
+}
+
+type SourceConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Field path of the event time. Processing time is used when empty
+	Timestamp string  `json:"timestamp,omitempty"`
+	Options   Options `json:"options,omitempty"`
+}
+
+const (
+	OperatorFilter      = "filter"
+	OperatorFilterField = "filterField"
+	OperatorMap         = "map"
+)
+
+type OperatorConfig struct {
+	Name string `json:"name"`
+	// filter, filterField or map
+	Type string `json:"type"`
+	// Name of the source or the operator
+	Input string `json:"input"`
+	// Boolean expression of the filter
+	Expr string `json:"expr,omitempty"`
+	// Field path, operator and value of the filterField
+	Field string      `json:"field,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	// Expressions by the output field names of the map
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+type SinkConfig struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	Input   string  `json:"input"`
+	Options Options `json:"options,omitempty"`
+}
+
+// Duration is a time.Duration written as "10s" or "1m30s"
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(time.Duration(v) * time.Millisecond)
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+	return nil
+}
+
+// LoadConfig reads the job definition from a YAML or JSON file and applies GLINK_ environment variables
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err = cfg.ApplyEnv(EnvPrefix, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ParseConfig decodes a JSON or YAML job definition. Unknown keys are rejected
+func ParseConfig(data []byte) (res *Config, err error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		var value interface{}
+		if err = yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		if value, err = normalizeYAML(value, ""); err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	res = &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(res); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, &ValidationError{Key: typeErr.Field, Message: fmt.Sprintf("%s value is expected", typeErr.Type.String())}
+		}
+		return nil, err
+	}
+	return
+}
+
+// normalizeYAML converts YAML maps to JSON objects
+func normalizeYAML(value interface{}, key string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			name, ok := k.(string)
+			if !ok {
+				return nil, &ValidationError{Key: key, Message: fmt.Sprintf("key %v should be a string", k)}
+			}
+			normalized, err := normalizeYAML(item, joinKey(key, name))
+			if err != nil {
+				return nil, err
+			}
+			res[name] = normalized
+		}
+		return res, nil
+	case []interface{}:
+		for i, item := range v {
+			normalized, err := normalizeYAML(item, fmt.Sprintf("%s[%d]", key, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalized
+		}
+	}
+	return value, nil
+}
+
+func joinKey(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// Marshal encodes the config as JSON, the format of the cluster StartCmd
+func (c *Config) Marshal() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+const EnvPrefix = "GLINK"
+
+// ApplyEnv overrides top level settings by environment variables like GLINK_CHECKPOINT_INTERVAL
+func (c *Config) ApplyEnv(prefix string, lookup func(key string) (string, bool)) error {
+	settings := []struct {
+		name  string
+		apply func(value string) error
+	}{
+		{"NAME", func(v string) error { c.Name = v; return nil }},
+		{"CHECKPOINT_INTERVAL", func(v string) error { return parseDuration(v, &c.CheckpointInterval) }},
+		{"CHECKPOINT_DIR", func(v string) error { c.CheckpointDir = v; return nil }},
+		{"RESTART_STRATEGY", func(v string) error { c.Restart.Strategy = v; return nil }},
+		{"RESTART_ATTEMPTS", func(v string) (err error) { c.Restart.Attempts, err = strconv.Atoi(v); return }},
+		{"RESTART_DELAY", func(v string) error { return parseDuration(v, &c.Restart.Delay) }},
+		{"LOG_LEVEL", func(v string) error { c.Logging.Level = v; return nil }},
+		{"LOG_PRODUCTION", func(v string) (err error) { c.Logging.Production, err = strconv.ParseBool(v); return }},
+		{"METRICS_ADDR", func(v string) error { c.Metrics.Addr = v; return nil }},
+	}
+	for _, s := range settings {
+		key := prefix + "_" + s.name
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := s.apply(value); err != nil {
+			return &ValidationError{Key: key, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+func parseDuration(value string, d *Duration) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ValidationError points to the offending config key like `operators[1].input`
+type ValidationError struct {
+	Key     string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Key + ": " + e.Message
+}
+
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
 }
@@ -0,0 +1,157 @@
+package glink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+const jobYAML = `
+name: payments
+checkpointInterval: 10s
+restart:
+  strategy: fixed-delay
+  attempts: 3
+  delay: 1s
+sources:
+  - name: in
+    type: test
+    timestamp: time
+operators:
+  - name: large
+    type: filter
+    input: in
+    expr: payload.amount > 100 && payload.currency == "EUR"
+  - name: projected
+    type: map
+    input: large
+    fields:
+      cents: payload.amount * 100
+sinks:
+  - name: out
+    type: test
+    input: projected
+`
+
+type sliceSource []interface{}
+
+func (s sliceSource) Run(input stream.IInputStream) error {
+	for _, v := range s {
+		input.Push(v)
+	}
+	return nil
+}
+
+type collector struct {
+	events []*stream.Event
+}
+
+func (c *collector) Push(event *stream.Event) {
+	c.events = append(c.events, event)
+}
+
+func TestBuildFromYAML(t *testing.T) {
+	tm := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	out := &collector{}
+	RegisterSource("test", func(options Options) (ISource, error) {
+		return sliceSource{
+			map[string]interface{}{"amount": float64(150), "currency": "EUR", "time": tm.Format(time.RFC3339)},
+			map[string]interface{}{"amount": float64(50), "currency": "EUR"},
+		}, nil
+	})
+	RegisterSink("test", func(options Options) (stream.ISink, error) {
+		return out, nil
+	})
+
+	cfg, err := ParseConfig([]byte(jobYAML))
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, cfg.CheckpointInterval.Duration())
+
+	env := map[string]string{"GLINK_NAME": "payments-prod", "GLINK_RESTART_ATTEMPTS": "5"}
+	assert.NoError(t, cfg.ApplyEnv(EnvPrefix, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}))
+	assert.Equal(t, "payments-prod", cfg.Name)
+	assert.Equal(t, 5, cfg.Restart.Attempts)
+
+	data, err := cfg.Marshal()
+	assert.NoError(t, err)
+	decoded, err := ParseConfig(data)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, decoded)
+
+	job, err := Build(cfg, StandaloneManager())
+	assert.NoError(t, err)
+	job.Run()
+	assert.NoError(t, job.Stop())
+
+	assert.Len(t, out.events, 1)
+	assert.Equal(t, map[string]interface{}{"cents": float64(15000)}, out.events[0].Payload)
+	assert.True(t, tm.Equal(out.events[0].Timestamp))
+}
+
+func TestValidation(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+name: broken
+restart:
+  strategy: sometimes
+sources:
+  - name: in
+    type: nope
+operators:
+  - name: f
+    type: filter
+    input: missing
+    expr: payload.a >
+sinks:
+  - name: out
+    type: print
+    input: f
+`))
+	assert.NoError(t, err)
+	err = cfg.Validate()
+	assert.Error(t, err)
+
+	var keys []string
+	for _, e := range err.(ValidationErrors) {
+		keys = append(keys, e.Key)
+	}
+	assert.Equal(t, []string{"operators[0].expr", "operators[0].input", "restart.strategy", "sources[0].type"}, keys)
+
+	_, err = ParseConfig([]byte(`{"name": "x", "checkpointRetain": "many"}`))
+	assert.EqualError(t, err, "checkpointRetain: int value is expected")
+
+	_, err = ParseConfig([]byte("name: x\nunknown: 1\n"))
+	assert.Error(t, err)
+
+	cfg, err = ParseConfig([]byte(`
+name: options
+sources:
+  - name: in
+    type: socket
+sinks:
+  - name: out
+    type: file
+    input: in
+    options:
+      format: csv
+  - name: csv
+    type: file
+    input: in
+    options:
+      dir: /tmp/out
+      format: csv
+`))
+	assert.NoError(t, err)
+	// plugin options are validated without creating plugins
+	keys = nil
+	for _, e := range cfg.Validate().(ValidationErrors) {
+		keys = append(keys, e.Key)
+	}
+	assert.Equal(t, []string{"sinks[0].options.dir", "sinks[1].options.columns", "sources[0].options.addr"}, keys)
+	_, err = Build(cfg, StandaloneManager())
+	assert.Error(t, err)
+}
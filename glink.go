@@ -2,33 +2,65 @@ package glink
 
 import (
 	"fmt"
-	"github.com/discretemind/glink/stream"
+	"io"
 	"sync"
 	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/stream/checkpoint"
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/discretemind/glink/utils/metrics"
 )
 
 type IManager interface {
-
 	Error(err error)
 }
 
 type ITaskSetup interface {
 	Task(name string, input func(input stream.IInputStream), watermark ...func(interface{}) time.Time) *stream.DataStream
+	// Source adds the task reading the source. Errors of the source restart it by the restart strategy
+	Source(name string, source ISource, watermark ...func(interface{}) time.Time) *stream.DataStream
+	// Run runs inputs of the tasks and returns, once all of them are finished
 	Run()
+	// Restore loads task states from the checkpoint before Run. The latest checkpoint is used when id is 0
+	Restore(id uint64) error
+	// Stop closes sources, completes the final checkpoint and closes sinks
+	Stop() error
 }
 
 type job struct {
 	sync.Mutex
-	tasks   map[string]func()
-	manager IManager
+	name               string
+	tasks              map[string]func() error
+	contexts           map[string]*stream.Context
+	manager            IManager
+	storage            checkpoint.Storage
+	checkpointId       uint64
+	checkpointInterval time.Duration
+	restart            RestartConfig
+	metricsAddr        string
+	sources            []io.Closer
+	sinks              []io.Closer
+	stop               chan struct{}
+	stopOnce           sync.Once
+	// checkpointLock serializes checkpoints, so a completed checkpoint follows its own snapshot
+	checkpointLock sync.Mutex
+	// owner tells checkpoints of the worker from ones of other workers sharing the storage
+	owner  string
+	quanta *quantum.Set
 }
 
 func New(manager IManager) ITaskSetup {
-	res := &job{
-		manager: manager,
-		tasks:   make(map[string]func()),
+	return newJob(manager)
+}
+
+func newJob(manager IManager) *job {
+	return &job{
+		manager:  manager,
+		tasks:    make(map[string]func() error),
+		contexts: make(map[string]*stream.Context),
+		stop:     make(chan struct{}),
 	}
-	return res
 }
 
 func Cluster(url string, token string) ITaskSetup {
@@ -40,24 +72,38 @@ func Standalone() ITaskSetup {
 }
 
 func (j *job) Task(name string, input func(input stream.IInputStream), watermark ...func(interface{}) time.Time) *stream.DataStream {
+	return j.task(name, func(in stream.IInputStream) error {
+		input(in)
+		return nil
+	}, watermark...)
+}
+
+func (j *job) Source(name string, source ISource, watermark ...func(interface{}) time.Time) *stream.DataStream {
+	return j.task(name, source.Run, watermark...)
+}
+
+// task adds the input, which errors restart it by the restart strategy
+func (j *job) task(name string, input func(input stream.IInputStream) error, watermark ...func(interface{}) time.Time) *stream.DataStream {
 	j.Lock()
 	defer j.Unlock()
 
 	_, ok := j.tasks[name]
 	if !ok {
-		fmt.Println("Task name ", name)
 		inStream := stream.InputStream()
+		inStream.Name(name)
+		j.contexts[name] = inStream.Context()
 
-		j.tasks[name] = func() {
-			input(inStream)
+		j.tasks[name] = func() error {
+			return input(inStream)
 		}
 
 		var out *stream.DataStream
 		if len(watermark) != 0 {
 			out = inStream.Watermark(watermark[0])
 		} else {
+			ctx := inStream.Context()
 			out = inStream.Watermark(func(meg interface{}) time.Time {
-				return time.Now()
+				return ctx.Now()
 			})
 		}
 
@@ -67,8 +113,242 @@ func (j *job) Task(name string, input func(input stream.IInputStream), watermark
 }
 
 func (j *job) Run() {
-	for _, t := range j.tasks {
-		fmt.Println("RUn  task ")
-		t()
+	if j.metricsAddr != "" {
+		server := metrics.Serve(j.metricsAddr)
+		j.sinks = append(j.sinks, server)
+	}
+	if j.checkpointInterval > 0 {
+		go j.runCheckpoints()
+	}
+	j.Lock()
+	tasks := make(map[string]func() error, len(j.tasks))
+	for name, t := range j.tasks {
+		tasks[name] = t
+	}
+	j.Unlock()
+
+	var wg sync.WaitGroup
+	for name, t := range tasks {
+		wg.Add(1)
+		go func(name string, t func() error) {
+			defer wg.Done()
+			j.runTask(name, t)
+		}(name, t)
+	}
+	wg.Wait()
+}
+
+// runTask runs the task input and restarts it according to the restart strategy, when it fails or panics
+func (j *job) runTask(name string, t func() error) {
+	for attempt := 1; ; attempt++ {
+		err := safeRun(t)
+		if err == nil {
+			return
+		}
+		j.manager.Error(fmt.Errorf("task %s failed: %v", name, err))
+		if j.restart.Strategy != RestartFixedDelay || j.restart.Attempts > 0 && attempt > j.restart.Attempts {
+			return
+		}
+		select {
+		case <-j.stop:
+			return
+		case <-time.After(j.restart.Delay.Duration()):
+		}
+	}
+}
+
+func safeRun(t func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return t()
+}
+
+func (j *job) runCheckpoints() {
+	ticker := time.NewTicker(j.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			if err := j.checkpoint(); err != nil {
+				j.manager.Error(err)
+			}
+		}
+	}
+}
+
+// checkpoint snapshots task states, saves them into the storage and then notifies tasks,
+// so sinks commit only data covered by a saved checkpoint
+func (j *job) checkpoint() error {
+	_, err := j.saveCheckpoint(func(ctx *stream.Context) (map[string][]byte, func(saved bool), error) {
+		states, err := ctx.Snapshot()
+		return states, nil, err
+	})
+	return err
+}
+
+// saveCheckpoint saves snapshots of the tasks. Snapshots may return the function completing them,
+// which learns whether the checkpoint is saved
+func (j *job) saveCheckpoint(snapshot func(ctx *stream.Context) (map[string][]byte, func(saved bool), error)) (id uint64, err error) {
+	j.checkpointLock.Lock()
+	defer j.checkpointLock.Unlock()
+	j.Lock()
+	cp := &checkpoint.Checkpoint{
+		Job:   j.name,
+		Owner: j.owner,
+		Time:  time.Now(),
+		Tasks: make(map[string]map[string][]byte),
+	}
+	contexts := make(map[string]*stream.Context, len(j.contexts))
+	for name, ctx := range j.contexts {
+		contexts[name] = ctx
+	}
+	j.Unlock()
+
+	var completions []func(saved bool)
+	complete := func(saved bool) {
+		for _, f := range completions {
+			f(saved)
+		}
+	}
+	for name, ctx := range contexts {
+		states, completion, sErr := snapshot(ctx)
+		if sErr != nil {
+			complete(false)
+			return 0, fmt.Errorf("checkpoint failed: task %s: %v", name, sErr)
+		}
+		if completion != nil {
+			completions = append(completions, completion)
+		}
+		if len(states) > 0 {
+			cp.Tasks[name] = states
+		}
+	}
+	// the storage allocates ids, since it's shared by workers
+	if j.storage != nil {
+		if sErr := j.storage.Save(cp); sErr != nil {
+			complete(false)
+			return 0, fmt.Errorf("checkpoint failed: %v", sErr)
+		}
 	}
+	complete(true)
+	j.Lock()
+	if j.storage == nil {
+		cp.ID = j.checkpointId + 1
+	}
+	if cp.ID > j.checkpointId {
+		j.checkpointId = cp.ID
+	}
+	j.Unlock()
+
+	for _, ctx := range contexts {
+		if cErr := ctx.CheckpointComplete(cp.ID); cErr != nil && err == nil {
+			err = fmt.Errorf("checkpoint %d failed: %v", cp.ID, cErr)
+		}
+	}
+	return cp.ID, err
+}
+
+func (j *job) Restore(id uint64) (err error) {
+	if j.storage == nil {
+		return fmt.Errorf("checkpoint storage is not configured")
+	}
+	var cp *checkpoint.Checkpoint
+	if id == 0 {
+		cp, err = checkpoint.Latest(j.storage, j.owner)
+	} else {
+		cp, err = j.storage.Load(id)
+	}
+	if err != nil {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	for name, states := range cp.Tasks {
+		ctx, ok := j.contexts[name]
+		if !ok {
+			return fmt.Errorf("checkpoint %d: task %s not found", cp.ID, name)
+		}
+		if err = ctx.Restore(states); err != nil {
+			return fmt.Errorf("checkpoint %d: task %s: %v", cp.ID, name, err)
+		}
+	}
+	return nil
+}
+
+// setQuanta limits keyed streams of the tasks to the quanta assigned to the worker
+func (j *job) setQuanta(set *quantum.Set) {
+	j.Lock()
+	defer j.Unlock()
+	if j.quanta == set {
+		return
+	}
+	j.quanta = set
+	for _, ctx := range j.contexts {
+		ctx.SetQuanta(set)
+	}
+}
+
+// releaseQuanta saves a checkpoint and drops keyed state of the quanta, which are moving to another worker.
+// The new owner restores the state from the returned checkpoint. The quanta are kept, when the checkpoint isn't saved
+func (j *job) releaseQuanta(quanta []quantum.Quantum) (uint64, error) {
+	if j.storage == nil {
+		return 0, fmt.Errorf("checkpoint storage is not configured")
+	}
+	return j.saveCheckpoint(func(ctx *stream.Context) (map[string][]byte, func(saved bool), error) {
+		return ctx.ReleaseQuanta(quanta)
+	})
+}
+
+// restoreQuanta loads keyed state of the quanta moved to the worker from the checkpoint of their previous owner.
+// Quanta without a checkpoint start with empty state
+func (j *job) restoreQuanta(quanta []quantum.Quantum, id uint64) error {
+	if id == 0 {
+		return nil
+	}
+	if j.storage == nil {
+		return fmt.Errorf("checkpoint storage is not configured")
+	}
+	cp, err := j.storage.Load(id)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	for name, states := range cp.Tasks {
+		ctx, ok := j.contexts[name]
+		if !ok {
+			return fmt.Errorf("checkpoint %d: task %s not found", cp.ID, name)
+		}
+		if err = ctx.RestoreQuanta(states, quanta); err != nil {
+			return fmt.Errorf("checkpoint %d: task %s: %v", cp.ID, name, err)
+		}
+	}
+	return nil
+}
+
+func (j *job) Stop() (err error) {
+	j.stopOnce.Do(func() {
+		close(j.stop)
+		for _, c := range j.sources {
+			if cErr := c.Close(); cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+		if cErr := j.checkpoint(); cErr != nil && err == nil {
+			err = cErr
+		}
+		for _, c := range j.sinks {
+			if cErr := c.Close(); cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+	})
+	return
 }
@@ -0,0 +1,120 @@
+package glink
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Options are plugin settings of sources and sinks
+type Options map[string]interface{}
+
+func optionError(key, expected string) error {
+	return &ValidationError{Key: key, Message: expected + " value is expected"}
+}
+
+func (o Options) String(key string, def string) (string, error) {
+	value, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", optionError(key, "string")
+	}
+	return s, nil
+}
+
+// RequiredString fails when the option is missing or empty
+func (o Options) RequiredString(key string) (string, error) {
+	s, err := o.String(key, "")
+	if err == nil && s == "" {
+		return "", &ValidationError{Key: key, Message: "is required"}
+	}
+	return s, err
+}
+
+func (o Options) Int(key string, def int) (int, error) {
+	value, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), nil
+		}
+	}
+	return 0, optionError(key, "integer")
+}
+
+func (o Options) Bool(key string, def bool) (bool, error) {
+	value, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, optionError(key, "boolean")
+	}
+	return b, nil
+}
+
+// Duration accepts "10s" like strings or milliseconds
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	value, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	switch v := value.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, &ValidationError{Key: key, Message: err.Error()}
+		}
+		return d, nil
+	case float64:
+		return time.Duration(v) * time.Millisecond, nil
+	case int:
+		return time.Duration(v) * time.Millisecond, nil
+	}
+	return 0, optionError(key, "duration")
+}
+
+func (o Options) Strings(key string) ([]string, error) {
+	value, ok := o[key]
+	if !ok {
+		return nil, nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, optionError(key, "list")
+	}
+	res := make([]string, len(items))
+	for i, item := range items {
+		if res[i], ok = item.(string); !ok {
+			return nil, optionError(fmt.Sprintf("%s[%d]", key, i), "string")
+		}
+	}
+	return res, nil
+}
+
+func (o Options) StringMap(key string) (map[string]string, error) {
+	value, ok := o[key]
+	if !ok {
+		return nil, nil
+	}
+	items, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, optionError(key, "map")
+	}
+	res := make(map[string]string, len(items))
+	for k, item := range items {
+		if res[k], ok = item.(string); !ok {
+			return nil, optionError(key+"."+k, "string")
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package glink
+
+import (
+	"fmt"
+	"strings"
+)
+
+type planNode struct {
+	title    string
+	children []*planNode
+}
+
+// Plan renders the job graph as a tree from sources to sinks
+func (c *Config) Plan() string {
+	nodes := make(map[string]*planNode)
+	var roots []*planNode
+	for _, s := range c.Sources {
+		n := &planNode{title: fmt.Sprintf("source %s [%s]", s.Name, s.Type)}
+		if s.Timestamp != "" {
+			n.title += " event time: " + s.Timestamp
+		}
+		nodes[s.Name] = n
+		roots = append(roots, n)
+	}
+
+	link := func(input string, n *planNode) {
+		if parent, ok := nodes[input]; ok {
+			parent.children = append(parent.children, n)
+		} else {
+			n.title += fmt.Sprintf(" (unknown input %q)", input)
+			roots = append(roots, n)
+		}
+	}
+	for _, o := range c.Operators {
+		n := &planNode{title: fmt.Sprintf("%s %s", o.Type, o.Name)}
+		switch o.Type {
+		case OperatorFilter:
+			n.title += ": " + o.Expr
+		case OperatorFilterField:
+			op := o.Op
+			if op == "" {
+				op = "=="
+			}
+			n.title += fmt.Sprintf(": %s %s %v", o.Field, op, o.Value)
+		case OperatorMap:
+			n.title += fmt.Sprintf(": %d fields", len(o.Fields))
+		}
+		link(o.Input, n)
+		nodes[o.Name] = n
+	}
+	for _, s := range c.Sinks {
+		link(s.Input, &planNode{title: fmt.Sprintf("sink %s [%s]", s.Name, s.Type)})
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "job %s", c.Name)
+	if c.CheckpointInterval > 0 {
+		fmt.Fprintf(b, ", checkpoint every %s", c.CheckpointInterval.Duration())
+	}
+	b.WriteString("\n")
+	for _, n := range roots {
+		n.write(b, "", "")
+	}
+	return b.String()
+}
+
+// write renders the node after the first line prefix and its children with the rest prefix
+func (n *planNode) write(b *strings.Builder, first, rest string) {
+	b.WriteString(first)
+	b.WriteString(n.title)
+	b.WriteString("\n")
+	for i, child := range n.children {
+		if i == len(n.children)-1 {
+			child.write(b, rest+"└─ ", rest+"   ")
+		} else {
+			child.write(b, rest+"├─ ", rest+"│  ")
+		}
+	}
+}
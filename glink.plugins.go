@@ -0,0 +1,196 @@
+package glink
+
+import (
+	"fmt"
+
+	"github.com/discretemind/glink/plugin/file"
+	"github.com/discretemind/glink/plugin/http"
+	"github.com/discretemind/glink/plugin/socket"
+	"github.com/discretemind/glink/stream"
+)
+
+// Built-in plugins available to job configs
+func init() {
+	RegisterSource("socket", socketSource)
+	RegisterSourceValidator("socket", func(o Options) error {
+		_, err := socketSourceConfig(o)
+		return err
+	})
+	RegisterSource("http", httpSource)
+	RegisterSourceValidator("http", func(o Options) error {
+		_, err := httpSourceConfig(o)
+		return err
+	})
+	RegisterSink("print", printSink)
+	RegisterSinkValidator("print", func(o Options) error {
+		_, err := o.String("prefix", "")
+		return err
+	})
+	RegisterSink("file", fileSink)
+	RegisterSinkValidator("file", func(o Options) error {
+		_, err := fileSinkConfig(o)
+		return err
+	})
+	RegisterSink("http", httpSink)
+	RegisterSinkValidator("http", func(o Options) error {
+		_, err := httpSinkConfig(o)
+		return err
+	})
+}
+
+func socketSource(o Options) (ISource, error) {
+	cfg, err := socketSourceConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	return socket.Source(cfg), nil
+}
+
+func socketSourceConfig(o Options) (cfg socket.SourceConfig, err error) {
+	if cfg.Network, err = o.String("network", "tcp"); err != nil {
+		return
+	}
+	if cfg.Addr, err = o.RequiredString("addr"); err != nil {
+		return
+	}
+	if cfg.Listen, err = o.Bool("listen", false); err != nil {
+		return
+	}
+	framing, err := o.String("framing", "lines")
+	if err != nil {
+		return
+	}
+	switch framing {
+	case "lines":
+		cfg.Framing = socket.Lines
+	case "length-prefixed":
+		cfg.Framing = socket.LengthPrefixed
+	default:
+		return cfg, &ValidationError{Key: "framing", Message: "lines or length-prefixed is expected"}
+	}
+	if cfg.MaxRecordSize, err = o.Int("maxRecordSize", 0); err != nil {
+		return
+	}
+	cfg.ReconnectDelay, err = o.Duration("reconnectDelay", 0)
+	return
+}
+
+func httpSource(o Options) (ISource, error) {
+	cfg, err := httpSourceConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	return http.Source(cfg), nil
+}
+
+func httpSourceConfig(o Options) (cfg http.SourceConfig, err error) {
+	if cfg.Addr, err = o.RequiredString("addr"); err != nil {
+		return
+	}
+	if cfg.QueueSize, err = o.Int("queueSize", 0); err != nil {
+		return
+	}
+	maxBodySize, err := o.Int("maxBodySize", 0)
+	if err != nil {
+		return
+	}
+	cfg.MaxBodySize = int64(maxBodySize)
+	return
+}
+
+type printer struct {
+	prefix string
+}
+
+func (p *printer) Push(event *stream.Event) {
+	fmt.Printf("%s%+v %v\n", p.prefix, event.Payload, event.Timestamp)
+}
+
+func printSink(o Options) (stream.ISink, error) {
+	prefix, err := o.String("prefix", "")
+	return &printer{prefix: prefix}, err
+}
+
+func fileSink(o Options) (stream.ISink, error) {
+	cfg, err := fileSinkConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	return file.Sink(cfg)
+}
+
+func fileSinkConfig(o Options) (cfg file.SinkConfig, err error) {
+	if cfg.Dir, err = o.RequiredString("dir"); err != nil {
+		return
+	}
+	if cfg.Prefix, err = o.String("prefix", ""); err != nil {
+		return
+	}
+	if cfg.BucketLayout, err = o.String("bucketLayout", ""); err != nil {
+		return
+	}
+	maxPartSize, err := o.Int("maxPartSize", 0)
+	if err != nil {
+		return
+	}
+	cfg.MaxPartSize = int64(maxPartSize)
+	if cfg.RolloverInterval, err = o.Duration("rolloverInterval", 0); err != nil {
+		return
+	}
+
+	format, err := o.String("format", "jsonl")
+	if err != nil {
+		return
+	}
+	switch format {
+	case "jsonl":
+		cfg.Encoder = file.JSONLines()
+	case "csv":
+		columns, err := o.Strings("columns")
+		if err != nil {
+			return cfg, err
+		}
+		if len(columns) == 0 {
+			return cfg, &ValidationError{Key: "columns", Message: "is required for csv format"}
+		}
+		header, err := o.Bool("header", true)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Encoder = file.CSV(header, columns...)
+	default:
+		return cfg, &ValidationError{Key: "format", Message: "jsonl or csv is expected"}
+	}
+	return
+}
+
+func httpSink(o Options) (stream.ISink, error) {
+	cfg, err := httpSinkConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	return http.Sink(cfg), nil
+}
+
+func httpSinkConfig(o Options) (cfg http.SinkConfig, err error) {
+	if cfg.URL, err = o.RequiredString("url"); err != nil {
+		return
+	}
+	if cfg.Headers, err = o.StringMap("headers"); err != nil {
+		return
+	}
+	if cfg.BatchSize, err = o.Int("batchSize", 0); err != nil {
+		return
+	}
+	if cfg.FlushInterval, err = o.Duration("flushInterval", 0); err != nil {
+		return
+	}
+	if cfg.MaxRetries, err = o.Int("maxRetries", 0); err != nil {
+		return
+	}
+	if cfg.RetryBackoff, err = o.Duration("retryBackoff", 0); err != nil {
+		return
+	}
+	cfg.MaxBuffered, err = o.Int("maxBuffered", 0)
+	return
+}
@@ -0,0 +1,134 @@
+package glink
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/glinktest"
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/stream/aggregate"
+	"github.com/discretemind/glink/stream/checkpoint"
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/stretchr/testify/assert"
+)
+
+type click struct {
+	User string
+	At   time.Time
+}
+
+// clickJob counts clicks of users by minutes. Tasks run synchronously, so the input is returned to push clicks
+func clickJob(storage checkpoint.Storage, owner string, set *quantum.Set) (*job, stream.IInputStream, *glinktest.Sink) {
+	j := newJob(&manager{})
+	j.storage = storage
+	j.owner = owner
+	var input stream.IInputStream
+	s := j.Task("clicks", func(in stream.IInputStream) {
+		input = in
+	}, func(value interface{}) time.Time {
+		return value.(click).At
+	})
+	counts := s.KeyBy(func(value interface{}) interface{} {
+		return value.(click).User
+	}).Window(stream.Tumbling(time.Minute)).Aggregate(aggregate.Count())
+	j.setQuanta(set)
+	j.Run()
+	return j, input, glinktest.Collect(counts)
+}
+
+type manager struct {
+}
+
+// failingStorage can't save checkpoints
+type failingStorage struct {
+	checkpoint.Storage
+}
+
+func (s failingStorage) Save(cp *checkpoint.Checkpoint) error {
+	return errors.New("disk is full")
+}
+
+func (m *manager) Error(err error) {
+}
+
+func TestJobMigratesQuanta(t *testing.T) {
+	storage, err := checkpoint.Dir(t.TempDir(), 1)
+	assert.NoError(t, err)
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	qa, _ := quantum.Of("a", 2)
+	user := "b"
+	for q, _ := quantum.Of(user, 2); q == qa; q, _ = quantum.Of(user, 2) {
+		user += "b"
+	}
+	qb, _ := quantum.Of(user, 2)
+
+	set1 := quantum.NewSet()
+	assert.NoError(t, set1.Assign(qa, qb))
+	j1, in1, out1 := clickJob(storage, "w1", set1)
+	in1.Push(click{User: "a", At: start})
+	in1.Push(click{User: user, At: start.Add(time.Second)})
+
+	j1.storage = failingStorage{storage}
+	_, err = j1.releaseQuanta([]quantum.Quantum{qb})
+	assert.Error(t, err)
+	assert.Len(t, set1.Quanta(), 2, "quanta are kept, when the checkpoint isn't saved")
+	j1.storage = storage
+	id, err := j1.releaseQuanta([]quantum.Quantum{qb})
+	assert.NoError(t, err)
+	assert.Equal(t, []quantum.Quantum{qa}, set1.Quanta())
+
+	set2 := quantum.NewSet()
+	j2, in2, out2 := clickJob(storage, "w2", set2)
+	// checkpoints of the new owner neither replace nor clean up the release checkpoint
+	assert.NoError(t, j2.checkpoint())
+	assert.NoError(t, j2.checkpoint())
+	assert.NoError(t, set2.Prepare(qb))
+	in2.Push(click{User: user, At: start.Add(2 * time.Second)})
+	assert.NoError(t, j2.restoreQuanta([]quantum.Quantum{qb}, id))
+	assert.NoError(t, set2.Assign(qb))
+
+	in1.Push(click{User: "a", At: start.Add(time.Minute)})
+	in2.Push(click{User: user, At: start.Add(time.Minute)})
+	assert.Len(t, out1.Values(), 1)
+	assert.Equal(t, int64(1), out1.Values()[0].(stream.WindowResult).Value)
+	assert.Len(t, out2.Values(), 1)
+	assert.Equal(t, int64(2), out2.Values()[0].(stream.WindowResult).Value)
+	assert.NoError(t, j1.Stop())
+	assert.NoError(t, j2.Stop())
+}
+
+// flakySource fails its first runs and pushes its values then
+type flakySource struct {
+	failures int
+	runs     int
+	values   []interface{}
+}
+
+func (s *flakySource) Run(input stream.IInputStream) error {
+	if s.runs++; s.runs <= s.failures {
+		return errors.New("connection refused")
+	}
+	for _, v := range s.values {
+		input.Push(v)
+	}
+	return nil
+}
+
+func TestJobRestartsFailedSource(t *testing.T) {
+	j := newJob(&manager{})
+	j.restart = RestartConfig{Strategy: RestartFixedDelay, Attempts: 2}
+	src := &flakySource{failures: 2, values: []interface{}{1, 2}}
+	out := glinktest.Collect(j.Source("numbers", src))
+	j.Run()
+	assert.Equal(t, 3, src.runs)
+	assert.Equal(t, []interface{}{1, 2}, out.Values())
+
+	j = newJob(&manager{})
+	j.restart = RestartConfig{Strategy: RestartFixedDelay, Attempts: 1}
+	src = &flakySource{failures: 2, values: []interface{}{1}}
+	out = glinktest.Collect(j.Source("numbers", src))
+	j.Run()
+	assert.Equal(t, 2, src.runs, "attempts are exhausted")
+	assert.Empty(t, out.Values())
+}
@@ -0,0 +1,91 @@
+package glinktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManualClock is a processing time clock, which moves only when advanced.
+// Due timers fire synchronously inside Advance and Set in time order
+type ManualClock struct {
+	sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers []*clockTimer
+}
+
+type clockTimer struct {
+	at        time.Time
+	seq       uint64
+	f         func()
+	cancelled bool
+}
+
+func NewClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) (cancel func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.seq++
+	t := &clockTimer{at: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	return func() {
+		c.Lock()
+		defer c.Unlock()
+		t.cancelled = true
+	}
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to the time and fires timers due by it. The clock never goes back
+func (c *ManualClock) Set(t time.Time) {
+	for {
+		c.Lock()
+		if t.Before(c.now) {
+			c.Unlock()
+			return
+		}
+		next := c.nextDue(t)
+		if next == nil {
+			c.now = t
+			c.Unlock()
+			return
+		}
+		// timers see the clock at their own time
+		c.now = next.at
+		c.Unlock()
+		next.f()
+	}
+}
+
+func (c *ManualClock) nextDue(t time.Time) *clockTimer {
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		if c.timers[i].at.Equal(c.timers[j].at) {
+			return c.timers[i].seq < c.timers[j].seq
+		}
+		return c.timers[i].at.Before(c.timers[j].at)
+	})
+	for len(c.timers) > 0 {
+		next := c.timers[0]
+		if next.at.After(t) {
+			return nil
+		}
+		c.timers = c.timers[1:]
+		if !next.cancelled {
+			return next
+		}
+	}
+	return nil
+}
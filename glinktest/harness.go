@@ -0,0 +1,93 @@
+// Package glinktest runs pipelines deterministically in tests: sources are slices, sinks collect events,
+// processing time is a manual clock and watermarks are advanced explicitly
+package glinktest
+
+import (
+	"time"
+
+	"github.com/discretemind/glink/stream"
+)
+
+// FromSlice is a job task input pushing the values synchronously
+func FromSlice(values ...interface{}) func(input stream.IInputStream) {
+	return func(input stream.IInputStream) {
+		for _, v := range values {
+			input.Push(v)
+		}
+	}
+}
+
+type Harness struct {
+	ctx     *stream.Context
+	Clock   *ManualClock
+	sources []func()
+}
+
+// New creates a harness with the clock at the start time and manual watermarks
+func New(start time.Time) *Harness {
+	h := &Harness{
+		ctx:   stream.NewContext(),
+		Clock: NewClock(start),
+	}
+	h.ctx.SetClock(h.Clock)
+	h.ctx.SetManualWatermark(true)
+	return h
+}
+
+func (h *Harness) Context() *stream.Context {
+	return h.ctx
+}
+
+// FromSlice creates a source emitting the values with the clock time on Run
+func (h *Harness) FromSlice(values ...interface{}) *stream.DataStream {
+	input := stream.ContextInputStream(h.ctx)
+	h.sources = append(h.sources, func() {
+		FromSlice(values...)(input)
+	})
+	return input.DataStream
+}
+
+// FromEvents creates a source emitting the events with their own timestamps on Run
+func (h *Harness) FromEvents(events ...stream.Event) *stream.DataStream {
+	input := stream.ContextInputStream(h.ctx)
+	h.sources = append(h.sources, func() {
+		for i := range events {
+			e := events[i]
+			input.PushEvent(&e)
+		}
+	})
+	return input.DataStream
+}
+
+// Input creates a source, which the test pushes values into
+func (h *Harness) Input() (stream.IInputStream, *stream.DataStream) {
+	input := stream.ContextInputStream(h.ctx)
+	return input, input.DataStream
+}
+
+// Run pushes all slice sources in their creation order
+func (h *Harness) Run() {
+	for _, s := range h.sources {
+		s()
+	}
+	h.sources = nil
+}
+
+// AdvanceWatermark fires event time timers due by the time
+func (h *Harness) AdvanceWatermark(t time.Time) {
+	h.ctx.AdvanceWatermark(t)
+}
+
+// AdvanceTime moves the processing time and fires processing time timers
+func (h *Harness) AdvanceTime(d time.Duration) {
+	h.Clock.Advance(d)
+}
+
+// Checkpoint snapshots the states and completes a checkpoint
+func (h *Harness) Checkpoint() (snapshot map[string][]byte, err error) {
+	if snapshot, err = h.ctx.Snapshot(); err != nil {
+		return
+	}
+	_, err = h.ctx.Checkpoint()
+	return
+}
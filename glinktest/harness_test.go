@@ -0,0 +1,73 @@
+package glinktest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+
+func TestPipeline(t *testing.T) {
+	h := New(start)
+	out := Collect(h.FromSlice(1, 2, 3, 4).Filter(func(value interface{}) bool {
+		return value.(int)%2 == 0
+	}))
+	h.Run()
+	out.AssertValues(t, 2, 4)
+	assert.True(t, start.Equal(out.Events()[0].Timestamp))
+
+	_, err := h.Checkpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1}, out.Checkpoints())
+}
+
+func TestTimers(t *testing.T) {
+	h := New(start)
+	ctx := h.Context()
+	input, s := h.Input()
+
+	var fired []string
+	s.BindOut(func(event *stream.Event) {
+		name := event.Payload.(string)
+		ctx.OnEventTime(event.Timestamp.Add(time.Minute), func(at time.Time) {
+			fired = append(fired, fmt.Sprintf("event %s %s", name, at.Sub(start)))
+		})
+		ctx.OnProcessingTime(ctx.Now().Add(time.Second), func(at time.Time) {
+			fired = append(fired, fmt.Sprintf("processing %s %s", name, at.Sub(start)))
+		})
+	})
+
+	input.Push("a")
+	h.AdvanceTime(500 * time.Millisecond)
+	input.Push("b")
+	assert.Empty(t, fired)
+
+	h.AdvanceTime(time.Second)
+	assert.Equal(t, []string{"processing a 1s", "processing b 1.5s"}, fired)
+
+	fired = nil
+	h.AdvanceWatermark(start.Add(time.Minute))
+	assert.Equal(t, []string{"event a 1m0s"}, fired)
+	h.AdvanceWatermark(start.Add(time.Hour))
+	assert.Equal(t, []string{"event a 1m0s", "event b 1m0.5s"}, fired)
+}
+
+func TestAutomaticWatermark(t *testing.T) {
+	ctx := stream.NewContext()
+	ctx.SetWatermarkDelay(time.Second)
+	input := stream.ContextInputStream(ctx)
+
+	fired := 0
+	ctx.OnEventTime(start, func(time.Time) {
+		fired++
+	})
+	input.PushEvent(&stream.Event{Timestamp: start})
+	assert.Equal(t, 0, fired)
+	input.PushEvent(&stream.Event{Timestamp: start.Add(time.Second)})
+	assert.Equal(t, 1, fired)
+	assert.Equal(t, start, ctx.Watermark())
+}
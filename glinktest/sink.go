@@ -0,0 +1,80 @@
+package glinktest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sink collects events of the stream
+type Sink struct {
+	sync.Mutex
+	events      []*stream.Event
+	checkpoints []uint64
+}
+
+func Collect(s *stream.DataStream) *Sink {
+	sink := &Sink{}
+	s.To(sink)
+	return sink
+}
+
+// CollectFaults collects events sent to the stream faults
+func CollectFaults(s *stream.DataStream) *Sink {
+	sink := &Sink{}
+	s.BindFault(sink.Push)
+	return sink
+}
+
+func (s *Sink) Push(event *stream.Event) {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *Sink) CheckpointComplete(id uint64) error {
+	s.Lock()
+	defer s.Unlock()
+	s.checkpoints = append(s.checkpoints, id)
+	return nil
+}
+
+func (s *Sink) Events() []*stream.Event {
+	s.Lock()
+	defer s.Unlock()
+	return append([]*stream.Event{}, s.events...)
+}
+
+func (s *Sink) Values() []interface{} {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]interface{}, len(s.events))
+	for i, e := range s.events {
+		res[i] = e.Payload
+	}
+	return res
+}
+
+// Checkpoints returns ids of completed checkpoints
+func (s *Sink) Checkpoints() []uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return append([]uint64{}, s.checkpoints...)
+}
+
+func (s *Sink) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.events = nil
+}
+
+// AssertValues checks collected payloads in order
+func (s *Sink) AssertValues(t *testing.T, expected ...interface{}) bool {
+	t.Helper()
+	if len(expected) == 0 {
+		return assert.Empty(t, s.Values())
+	}
+	return assert.Equal(t, expected, s.Values())
+}
@@ -0,0 +1,99 @@
+package file
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Encoder writes stream payloads into part files
+type Encoder interface {
+	Extension() string
+	//Begin is called once for every new part file
+	Begin(w io.Writer) error
+	Encode(w io.Writer, payload interface{}) error
+}
+
+type jsonLines struct {
+}
+
+func JSONLines() Encoder {
+	return &jsonLines{}
+}
+
+func (e *jsonLines) Extension() string {
+	return "jsonl"
+}
+
+func (e *jsonLines) Begin(w io.Writer) error {
+	return nil
+}
+
+func (e *jsonLines) Encode(w io.Writer, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+type csvEncoder struct {
+	columns []string
+	header  bool
+}
+
+// CSV encodes struct fields or map keys listed in columns. The header line is written at the start of every part file
+func CSV(header bool, columns ...string) Encoder {
+	return &csvEncoder{
+		columns: columns,
+		header:  header,
+	}
+}
+
+func (e *csvEncoder) Extension() string {
+	return "csv"
+}
+
+func (e *csvEncoder) Begin(w io.Writer) error {
+	if !e.header {
+		return nil
+	}
+	return e.write(w, e.columns)
+}
+
+func (e *csvEncoder) Encode(w io.Writer, payload interface{}) error {
+	v := reflect.ValueOf(payload)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	record := make([]string, len(e.columns))
+	for i, c := range e.columns {
+		var field reflect.Value
+		switch v.Kind() {
+		case reflect.Struct:
+			field = v.FieldByName(c)
+		case reflect.Map:
+			field = v.MapIndex(reflect.ValueOf(c))
+		default:
+			return fmt.Errorf("csv: unsupported payload type %s", v.Kind())
+		}
+		if field.IsValid() && field.CanInterface() {
+			record[i] = fmt.Sprint(field.Interface())
+		}
+	}
+	return e.write(w, record)
+}
+
+func (e *csvEncoder) write(w io.Writer, record []string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,319 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/utils/log"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultBucketLayout = "2006/01/02/15"
+	DefaultPrefix       = "part"
+
+	inProgressSuffix = ".inprogress"
+)
+
+type SinkConfig struct {
+	Dir string
+	//Part file name prefix. "part" by default
+	Prefix string
+	//Time layout of the event time bucket directory. "2006/01/02/15" by default
+	BucketLayout string
+	//Part file is rolled when it reaches the size. 0 - unlimited
+	MaxPartSize int64
+	//Part file is rolled when it has been open longer than the interval. 0 - unlimited
+	RolloverInterval time.Duration
+	//JSON Lines by default
+	Encoder Encoder
+}
+
+// sink writes events into hidden in-progress part files inside event time buckets. Snapshots of checkpoints close
+// open parts as pending, and they are renamed to their final names once the checkpoint is completed, so readers
+// never see uncommitted data and data written after the snapshot isn't committed by it
+type sink struct {
+	sync.Mutex
+	cfg SinkConfig
+	// root is the first run of restored runs. Part names start with it, so a restore finds in-progress parts of
+	// crashed runs
+	root    int64
+	runId   int64
+	counter uint64
+	open    map[string]*partFile
+	// rolled parts are closed after the last snapshot
+	rolled []*partFile
+	// pending parts are closed by snapshots and wait for the completed checkpoint
+	pending []*partFile
+	// err is a lost part. Snapshots fail until the sink is restored, since the data isn't replayed otherwise
+	err error
+}
+
+type partFile struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+// sinkState is the snapshot of the sink. Pending parts are relative to the directory
+type sinkState struct {
+	Root    int64    `json:"root"`
+	Pending []string `json:"pending,omitempty"`
+}
+
+func Sink(cfg SinkConfig) (res *sink, err error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("file sink: directory is not set")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	if cfg.BucketLayout == "" {
+		cfg.BucketLayout = DefaultBucketLayout
+	}
+	if cfg.Encoder == nil {
+		cfg.Encoder = JSONLines()
+	}
+	if err = os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	runId := time.Now().UnixNano()
+	res = &sink{
+		cfg:   cfg,
+		root:  runId,
+		runId: runId,
+		open:  make(map[string]*partFile),
+	}
+	return
+}
+
+func (s *sink) Push(event *stream.Event) {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.write(event); err != nil {
+		log.Error("file sink: can't write event", zap.Error(err))
+	}
+}
+
+func (s *sink) write(event *stream.Event) (err error) {
+	bucket := event.Timestamp.UTC().Format(s.cfg.BucketLayout)
+	part, ok := s.open[bucket]
+	if ok && s.cfg.RolloverInterval > 0 && time.Since(part.opened) >= s.cfg.RolloverInterval {
+		if err = s.roll(bucket); err != nil {
+			return
+		}
+		ok = false
+	}
+	if !ok {
+		if part, err = s.openPart(bucket); err != nil {
+			return
+		}
+	}
+
+	if err = s.cfg.Encoder.Encode(part, event.Payload); err != nil {
+		return
+	}
+
+	if s.cfg.MaxPartSize > 0 && part.size >= s.cfg.MaxPartSize {
+		return s.roll(bucket)
+	}
+	return
+}
+
+// partPrefix starts names of parts of the restored runs
+func (s *sink) partPrefix() string {
+	return fmt.Sprintf("%s-%d-", s.cfg.Prefix, s.root)
+}
+
+func (s *sink) openPart(bucket string) (res *partFile, err error) {
+	dir := filepath.Join(s.cfg.Dir, filepath.FromSlash(bucket))
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	s.counter++
+	name := fmt.Sprintf("%s%d-%d.%s", s.partPrefix(), s.runId, s.counter, s.cfg.Encoder.Extension())
+	path := filepath.Join(dir, name)
+
+	f, err := os.OpenFile(inProgressPath(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	res = &partFile{
+		path:   path,
+		file:   f,
+		writer: bufio.NewWriter(f),
+		opened: time.Now(),
+	}
+	if err = s.cfg.Encoder.Begin(res); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.open[bucket] = res
+	return
+}
+
+// roll closes the open part file of the bucket. The file stays in-progress until a snapshot. A part, which
+// isn't written completely, is removed and fails snapshots
+func (s *sink) roll(bucket string) error {
+	part, ok := s.open[bucket]
+	if !ok {
+		return nil
+	}
+	delete(s.open, bucket)
+	if err := part.close(); err != nil {
+		os.Remove(inProgressPath(part.path))
+		if s.err == nil {
+			s.err = fmt.Errorf("file sink: part %s is lost: %v", part.path, err)
+		}
+		return err
+	}
+	s.rolled = append(s.rolled, part)
+	return nil
+}
+
+func (s *sink) rollAll() (err error) {
+	for bucket := range s.open {
+		if rErr := s.roll(bucket); rErr != nil && err == nil {
+			err = rErr
+		}
+	}
+	return
+}
+
+// Snapshot closes open parts as pending for the checkpoint. They are included into the snapshot, so a restore
+// commits them, when the checkpoint is saved, but not completed
+func (s *sink) Snapshot() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	_ = s.rollAll()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.pending = append(s.pending, s.rolled...)
+	s.rolled = nil
+
+	state := sinkState{Root: s.root}
+	for _, part := range s.pending {
+		rel, err := filepath.Rel(s.cfg.Dir, part.path)
+		if err != nil {
+			return nil, err
+		}
+		state.Pending = append(state.Pending, filepath.ToSlash(rel))
+	}
+	return json.Marshal(state)
+}
+
+// Restore continues parts of the snapshot. Its pending parts are committed, and other in-progress parts
+// of the restored runs are removed, since their data is written again
+func (s *sink) Restore(data []byte) error {
+	state := sinkState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.root = state.Root
+	s.err = nil
+	pending := make(map[string]bool, len(state.Pending))
+	for _, rel := range state.Pending {
+		pending[filepath.Join(s.cfg.Dir, filepath.FromSlash(rel))] = true
+	}
+
+	prefix := "." + s.partPrefix()
+	return filepath.Walk(s.cfg.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, inProgressSuffix) {
+			return nil
+		}
+		final := filepath.Join(filepath.Dir(path), strings.TrimSuffix(name[1:], inProgressSuffix))
+		if pending[final] {
+			return os.Rename(path, final)
+		}
+		return os.Remove(path)
+	})
+}
+
+// CheckpointComplete renames parts closed by snapshots. Checkpoints complete in the order of their snapshots,
+// so parts of earlier failed checkpoints are covered by the completed one too. Parts, which aren't renamed,
+// stay pending for the next checkpoint
+func (s *sink) CheckpointComplete(id uint64) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	if err = s.rename(); err != nil {
+		return fmt.Errorf("file sink: checkpoint %d: %v", id, err)
+	}
+	return
+}
+
+// Close commits all written data. It should be called when the input is finished
+func (s *sink) Close() (err error) {
+	s.Lock()
+	defer s.Unlock()
+	err = s.rollAll()
+	s.pending = append(s.pending, s.rolled...)
+	s.rolled = nil
+	if rErr := s.rename(); rErr != nil && err == nil {
+		err = rErr
+	}
+	if err == nil {
+		err = s.err
+	}
+	return
+}
+
+func (s *sink) rename() (err error) {
+	var failed []*partFile
+	for _, part := range s.pending {
+		rErr := os.Rename(inProgressPath(part.path), part.path)
+		if os.IsNotExist(rErr) {
+			// renamed by the restore of the checkpoint
+			if _, sErr := os.Stat(part.path); sErr == nil {
+				rErr = nil
+			}
+		}
+		if rErr != nil {
+			failed = append(failed, part)
+			if err == nil {
+				err = rErr
+			}
+		}
+	}
+	s.pending = failed
+	return
+}
+
+func (p *partFile) Write(data []byte) (n int, err error) {
+	n, err = p.writer.Write(data)
+	p.size += int64(n)
+	return
+}
+
+func (p *partFile) close() error {
+	if err := p.writer.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+func inProgressPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+inProgressSuffix)
+}
@@ -0,0 +1,112 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+type record struct {
+	Name  string
+	Value int
+}
+
+func finished(t *testing.T, dir string) (res []string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			rel, _ := filepath.Rel(dir, path)
+			res = append(res, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	return
+}
+
+func TestSinkCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glink-file")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Sink(SinkConfig{Dir: dir})
+	assert.NoError(t, err)
+
+	tm := time.Date(2026, 10, 18, 14, 30, 0, 0, time.UTC)
+	s.Push(&stream.Event{Timestamp: tm, Payload: record{"a", 1}})
+	s.Push(&stream.Event{Timestamp: tm.Add(time.Hour), Payload: record{"b", 2}})
+	assert.Empty(t, finished(t, dir))
+
+	_, err = s.Snapshot()
+	assert.NoError(t, err)
+	// data after the snapshot isn't committed by its checkpoint
+	s.Push(&stream.Event{Timestamp: tm, Payload: record{"c", 3}})
+	assert.NoError(t, s.CheckpointComplete(1))
+	files := finished(t, dir)
+	assert.Len(t, files, 2)
+	assert.True(t, strings.HasPrefix(files[0], "2026/10/18/14/part-"))
+	assert.True(t, strings.HasPrefix(files[1], "2026/10/18/15/part-"))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, files[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"Name\":\"a\",\"Value\":1}\n", string(data))
+}
+
+func TestSinkRollBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glink-file")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Sink(SinkConfig{Dir: dir, MaxPartSize: 1, Encoder: CSV(true, "Name", "Value")})
+	assert.NoError(t, err)
+
+	tm := time.Date(2026, 10, 18, 14, 30, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		s.Push(&stream.Event{Timestamp: tm, Payload: &record{"a", i}})
+	}
+	assert.NoError(t, s.Close())
+
+	files := finished(t, dir)
+	assert.Len(t, files, 3)
+	data, err := ioutil.ReadFile(filepath.Join(dir, files[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, "Name,Value\na,0\n", string(data))
+}
+
+func TestSinkRestore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Sink(SinkConfig{Dir: dir})
+	assert.NoError(t, err)
+
+	tm := time.Date(2026, 10, 18, 14, 30, 0, 0, time.UTC)
+	s.Push(&stream.Event{Timestamp: tm, Payload: record{"a", 1}})
+	snapshot, err := s.Snapshot()
+	assert.NoError(t, err)
+	s.Push(&stream.Event{Timestamp: tm, Payload: record{"b", 2}})
+	_, err = s.Snapshot()
+	assert.NoError(t, err)
+
+	// the job crashes before the first checkpoint is completed, and the second one isn't saved
+	restored, err := Sink(SinkConfig{Dir: dir})
+	assert.NoError(t, err)
+	assert.NoError(t, restored.Restore(snapshot))
+	files := finished(t, dir)
+	assert.Len(t, files, 1)
+	data, err := ioutil.ReadFile(filepath.Join(dir, files[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"Name\":\"a\",\"Value\":1}\n", string(data))
+
+	var inProgress []string
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, inProgressSuffix) {
+			inProgress = append(inProgress, path)
+		}
+		return err
+	})
+	assert.Empty(t, inProgress, "parts after the snapshot are removed")
+}
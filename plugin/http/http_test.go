@@ -0,0 +1,183 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource(t *testing.T) {
+	src := Source(SourceConfig{QueueSize: 1})
+	server := httptest.NewServer(src)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", bytes.NewBufferString(`{"id":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp, err = http.Post(server.URL, "application/json", bytes.NewBufferString(`{"id":2}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	resp, err = http.Post(server.URL, "application/json", bytes.NewBufferString(`not json`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	received := make(chan interface{}, 1)
+	input := stream.InputStream()
+	input.BindOut(func(event *stream.Event) {
+		received <- event.Payload
+	})
+	go func() {
+		assert.NoError(t, src.Run(input))
+	}()
+	defer src.Close()
+
+	select {
+	case value := <-received:
+		assert.Equal(t, map[string]interface{}{"id": float64(1)}, value)
+	case <-time.After(time.Second):
+		t.Fatal("value is not pushed")
+	}
+}
+
+func TestSinkRetry(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]int
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []int
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	s := Sink(SinkConfig{URL: server.URL, BatchSize: 2, FlushInterval: time.Hour, RetryBackoff: time.Millisecond})
+	for i := 1; i <= 3; i++ {
+		s.Push(&stream.Event{Payload: i})
+	}
+	assert.NoError(t, s.Close())
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, [][]int{{1, 2}, {3}}, batches)
+}
+
+func TestSinkKeepsFailedBatch(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]int
+	down := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []int
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	s := Sink(SinkConfig{URL: server.URL, BatchSize: 2, FlushInterval: time.Hour, MaxRetries: -1})
+	for i := 1; i <= 3; i++ {
+		s.Push(&stream.Event{Payload: i})
+	}
+	assert.Error(t, s.CheckpointComplete(1))
+
+	lock.Lock()
+	down = false
+	lock.Unlock()
+	s.Push(&stream.Event{Payload: 4})
+	assert.NoError(t, s.CheckpointComplete(2))
+	assert.NoError(t, s.Close())
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, [][]int{{1, 2}, {3, 4}}, batches)
+}
+
+func TestSinkDeadLetter(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		var batch []int
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		if batch[0] == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	var rejected []interface{}
+	s := Sink(SinkConfig{URL: server.URL, BatchSize: 2, FlushInterval: time.Hour, DeadLetter: func(payloads []interface{}, err error) {
+		rejected = append(rejected, payloads...)
+	}})
+	defer s.Close()
+	for i := 1; i <= 3; i++ {
+		s.Push(&stream.Event{Payload: i})
+	}
+	s.Push(&stream.Event{Payload: func() {}})
+	assert.NoError(t, s.CheckpointComplete(1))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, [][]int{{3}}, batches, "the rejected batch doesn't block later ones")
+	assert.Len(t, rejected, 3)
+	assert.Equal(t, []interface{}{1, 2}, rejected[:2])
+}
+
+func TestSinkDropsWhenBufferIsFull(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]int
+	down := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []int
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	s := Sink(SinkConfig{Name: "http-full", URL: server.URL, BatchSize: 2, MaxBuffered: 3, FlushInterval: time.Hour, MaxRetries: -1})
+	defer s.Close()
+	dropped := s.metrics.Dropped()
+	for i := 1; i <= 5; i++ {
+		s.Push(&stream.Event{Payload: i})
+	}
+	assert.Error(t, s.CheckpointComplete(1))
+	assert.Equal(t, uint64(2), s.metrics.Dropped()-dropped)
+
+	lock.Lock()
+	down = false
+	lock.Unlock()
+	assert.NoError(t, s.CheckpointComplete(2))
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, [][]int{{1, 2}, {3}}, batches)
+}
@@ -0,0 +1,244 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/utils/log"
+	"github.com/discretemind/glink/utils/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+	DefaultMaxRetries    = 3
+	DefaultRetryBackoff  = 100 * time.Millisecond
+	// DefaultMaxBuffered is the number of batches kept while posts fail
+	DefaultMaxBuffered = 100
+)
+
+type SinkConfig struct {
+	// Operator name of the sink metrics. "http" by default
+	Name    string
+	URL     string
+	Headers map[string]string
+	// Batch is posted as a JSON array once it reaches the size or the flush interval expires
+	BatchSize     int
+	FlushInterval time.Duration
+	// Failed requests are retried with doubled backoff. 5xx and 429 responses are retried, other errors are not.
+	// Negative value disables retries
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Payloads kept while posts fail. Later payloads are dropped. DefaultMaxBuffered batches by default
+	MaxBuffered int
+	// DeadLetter receives payloads, which are rejected by the server or can't be encoded. They are dropped by default
+	DeadLetter func(payloads []interface{}, err error)
+	Client     *http.Client
+}
+
+// sink keeps payloads until they are posted, so batches failed by retryable errors are posted again by the next
+// flush and the checkpoint fails meanwhile. Rejected batches go to the dead letter
+type sink struct {
+	sync.Mutex
+	cfg     SinkConfig
+	metrics *metrics.OperatorMetrics
+	batch   []json.RawMessage
+	// payloads of the batch for the dead letter
+	payloads []interface{}
+	// failed stops posting of full batches by Push until the next flush succeeds
+	failed bool
+	// full tells the buffer overflow is logged
+	full bool
+	// sending serializes posts, which run without the lock
+	sending sync.Mutex
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func Sink(cfg SinkConfig) (res *sink) {
+	if cfg.Name == "" {
+		cfg.Name = "http"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	if cfg.MaxBuffered <= 0 {
+		cfg.MaxBuffered = DefaultMaxBuffered * cfg.BatchSize
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	res = &sink{
+		cfg:     cfg,
+		metrics: metrics.Operator(cfg.Name, cfg.URL),
+		stop:    make(chan struct{}),
+	}
+	go res.runFlush()
+	return
+}
+
+func (s *sink) runFlush() {
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Error("http sink: can't flush batch", zap.String("url", s.cfg.URL), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Push adds the event payload to the batch. A full batch is posted synchronously, which backpressures the stream.
+// Payloads are dropped, while the buffer is full of batches, which can't be posted
+func (s *sink) Push(event *stream.Event) {
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		s.deadLetter([]interface{}{event.Payload}, err)
+		return
+	}
+
+	s.Lock()
+	if len(s.batch) >= s.cfg.MaxBuffered {
+		if !s.full {
+			log.Warn("http sink: buffer is full, payloads are dropped", zap.String("url", s.cfg.URL), zap.Int("size", len(s.batch)))
+			s.full = true
+		}
+		s.Unlock()
+		s.metrics.Drop()
+		return
+	}
+	s.batch = append(s.batch, data)
+	s.payloads = append(s.payloads, event.Payload)
+	post := len(s.batch) >= s.cfg.BatchSize && !s.failed
+	s.Unlock()
+
+	if post {
+		if err := s.Flush(); err != nil {
+			log.Error("http sink: can't post batch", zap.String("url", s.cfg.URL), zap.Error(err))
+		}
+	}
+}
+
+// CheckpointComplete posts the buffered payloads. The checkpoint fails, while they can't be posted
+func (s *sink) CheckpointComplete(id uint64) error {
+	return s.Flush()
+}
+
+func (s *sink) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	return s.Flush()
+}
+
+// Flush posts buffered payloads by batches. Payloads are removed only once their batch is posted or rejected
+func (s *sink) Flush() error {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	for {
+		s.Lock()
+		n := s.cfg.BatchSize
+		if n > len(s.batch) {
+			n = len(s.batch)
+		}
+		if n == 0 {
+			s.batch, s.payloads = nil, nil
+			s.failed, s.full = false, false
+			s.Unlock()
+			return nil
+		}
+		// Push only appends, so the head of the buffer is kept meanwhile
+		batch := s.batch[:n]
+		payloads := s.payloads[:n]
+		s.Unlock()
+
+		retry, err := s.send(batch)
+		if err != nil && retry {
+			s.Lock()
+			s.failed = true
+			s.Unlock()
+			return err
+		}
+		if err != nil {
+			s.deadLetter(payloads, err)
+		}
+		s.Lock()
+		s.batch, s.payloads = s.batch[n:], s.payloads[n:]
+		s.Unlock()
+	}
+}
+
+// deadLetter passes rejected payloads to the dead letter or drops them
+func (s *sink) deadLetter(payloads []interface{}, err error) {
+	for range payloads {
+		s.metrics.Error()
+	}
+	if s.cfg.DeadLetter != nil {
+		s.cfg.DeadLetter(payloads, err)
+		return
+	}
+	log.Error("http sink: payloads are rejected and dropped", zap.String("url", s.cfg.URL), zap.Int("count", len(payloads)), zap.Error(err))
+}
+
+// send posts the batch with retries. Retry tells whether the failure is temporary
+func (s *sink) send(batch []json.RawMessage) (retry bool, err error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return false, err
+	}
+	backoff := s.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if retry, err = s.post(body); err == nil || !retry || attempt >= s.cfg.MaxRetries {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *sink) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("http sink: unexpected status %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/utils/log"
+	"github.com/discretemind/glink/utils/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultQueueSize   = 1000
+	DefaultMaxBodySize = 1 << 20
+)
+
+type SourceConfig struct {
+	// Operator name of the source metrics. "http" by default
+	Name string
+	// Address to listen on. The server is not started when empty, so the source can be mounted as http.Handler
+	Addr string
+	// Requests waiting to be pushed into the stream. The source responds 429 when the queue is full
+	QueueSize   int
+	MaxBodySize int64
+	// Decodes request body. JSON into map[string]interface{} by default
+	Decode func(body []byte) (interface{}, error)
+}
+
+type source struct {
+	sync.Mutex
+	cfg    SourceConfig
+	queue  chan interface{}
+	server *http.Server
+	once   sync.Once
+	done   chan struct{}
+}
+
+func Source(cfg SourceConfig) (res *source) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = DefaultMaxBodySize
+	}
+	if cfg.Decode == nil {
+		cfg.Decode = decodeJSON
+	}
+	if cfg.Name == "" {
+		cfg.Name = "http"
+	}
+	res = &source{
+		cfg:   cfg,
+		queue: make(chan interface{}, cfg.QueueSize),
+		done:  make(chan struct{}),
+	}
+	return
+}
+
+func decodeJSON(body []byte) (res interface{}, err error) {
+	value := make(map[string]interface{})
+	if err = json.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *source) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	value, err := s.cfg.Decode(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case s.queue <- value:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		metrics.Operator(s.cfg.Name, s.cfg.Addr).Backpressure()
+		w.Header().Set("Retry-After", strconv.Itoa(1))
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	}
+}
+
+// Run starts the server and pushes received values into the input stream until the source is closed.
+// Server errors are returned, and the server is stopped, when pushing panics
+func (s *source) Run(input stream.IInputStream) error {
+	failed := make(chan error, 1)
+	if s.cfg.Addr != "" {
+		server := &http.Server{
+			Addr:    s.cfg.Addr,
+			Handler: s,
+		}
+		s.Lock()
+		s.server = server
+		s.Unlock()
+		defer server.Close()
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				failed <- err
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case err := <-failed:
+			log.Error("http source: server error", zap.String("addr", s.cfg.Addr), zap.Error(err))
+			return err
+		case value := <-s.queue:
+			input.Push(value)
+		}
+	}
+}
+
+func (s *source) Close() (err error) {
+	s.Lock()
+	server := s.server
+	s.Unlock()
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = server.Shutdown(ctx)
+	}
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return
+}
@@ -0,0 +1,304 @@
+package socket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/utils/log"
+	"go.uber.org/zap"
+)
+
+type Framing uint8
+
+const (
+	// Records are separated by '\n'. Trailing '\r' is removed
+	Lines Framing = iota
+	// Every record is prefixed with its 4 byte big endian length
+	LengthPrefixed
+)
+
+const (
+	DefaultMaxRecordSize  = 64 * 1024
+	DefaultReconnectDelay = time.Second
+	maxReconnectDelay     = 30 * time.Second
+)
+
+type SourceConfig struct {
+	// "tcp", "tcp4", "tcp6" or "unix"
+	Network string
+	Addr    string
+	// Accept connections on the address instead of connecting to it
+	Listen  bool
+	Framing Framing
+	// Longer records close the connection
+	MaxRecordSize int
+	// Initial delay before reconnecting. It's doubled on every failed attempt up to 30 seconds
+	ReconnectDelay time.Duration
+	// Decodes a record. Records are pushed as strings by default
+	Decode func(record []byte) (interface{}, error)
+}
+
+type source struct {
+	sync.Mutex
+	cfg      SourceConfig
+	input    stream.IInputStream
+	pushLock sync.Mutex
+	done     chan struct{}
+	once     sync.Once
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	// failure is the panic of a connection goroutine, which stops the run
+	failure error
+}
+
+func Source(cfg SourceConfig) (res *source) {
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	if cfg.MaxRecordSize <= 0 {
+		cfg.MaxRecordSize = DefaultMaxRecordSize
+	}
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = DefaultReconnectDelay
+	}
+	if cfg.Decode == nil {
+		cfg.Decode = func(record []byte) (interface{}, error) {
+			return string(record), nil
+		}
+	}
+	res = &source{
+		cfg:   cfg,
+		done:  make(chan struct{}),
+		conns: make(map[net.Conn]struct{}),
+	}
+	return
+}
+
+// Run listens or connects and pushes records into the input stream until the source is closed. Connections are
+// reestablished by the source, but a panic of a connection goroutine stops the run and is returned
+func (s *source) Run(input stream.IInputStream) error {
+	s.input = input
+	if s.cfg.Listen {
+		return s.runListener()
+	}
+	s.runDialer()
+	return nil
+}
+
+func (s *source) Close() (err error) {
+	s.once.Do(func() {
+		close(s.done)
+		s.Lock()
+		defer s.Unlock()
+		if s.listener != nil {
+			err = s.listener.Close()
+		}
+		for c := range s.conns {
+			c.Close()
+		}
+	})
+	return
+}
+
+func (s *source) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *source) wait(delay time.Duration) bool {
+	select {
+	case <-s.done:
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+func (s *source) runListener() error {
+	delay := s.cfg.ReconnectDelay
+	for !s.closed() {
+		l, err := net.Listen(s.cfg.Network, s.cfg.Addr)
+		if err != nil {
+			log.Error("socket source: can't listen", zap.String("addr", s.cfg.Addr), zap.Error(err))
+			if !s.wait(delay) {
+				return nil
+			}
+			delay = nextDelay(delay)
+			continue
+		}
+		delay = s.cfg.ReconnectDelay
+
+		s.Lock()
+		if s.closed() {
+			s.Unlock()
+			l.Close()
+			return nil
+		}
+		s.listener = l
+		s.Unlock()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if failure := s.takeFailure(); failure != nil {
+					return failure
+				}
+				if !s.closed() {
+					log.Error("socket source: accept failed", zap.String("addr", s.cfg.Addr), zap.Error(err))
+				}
+				l.Close()
+				break
+			}
+			go s.serveRecovered(conn)
+		}
+	}
+	return nil
+}
+
+// serveRecovered serves the accepted connection. A panic stops the listener and connections of the run
+func (s *source) serveRecovered(conn net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.Lock()
+			defer s.Unlock()
+			if s.failure == nil {
+				s.failure = fmt.Errorf("connection %s: %v", conn.RemoteAddr(), r)
+			}
+			if s.listener != nil {
+				s.listener.Close()
+			}
+		}
+	}()
+	s.serve(conn)
+}
+
+// takeFailure returns the failure of the run and closes its connections
+func (s *source) takeFailure() (err error) {
+	s.Lock()
+	defer s.Unlock()
+	if err, s.failure = s.failure, nil; err == nil {
+		return
+	}
+	s.listener = nil
+	for c := range s.conns {
+		c.Close()
+	}
+	return
+}
+
+func (s *source) runDialer() {
+	delay := s.cfg.ReconnectDelay
+	for !s.closed() {
+		conn, err := net.Dial(s.cfg.Network, s.cfg.Addr)
+		if err != nil {
+			log.Warn("socket source: can't connect", zap.String("addr", s.cfg.Addr), zap.Error(err))
+			if !s.wait(delay) {
+				return
+			}
+			delay = nextDelay(delay)
+			continue
+		}
+		delay = s.cfg.ReconnectDelay
+		s.serve(conn)
+		if !s.closed() {
+			log.Warn("socket source: connection lost, reconnecting", zap.String("addr", s.cfg.Addr))
+			if !s.wait(delay) {
+				return
+			}
+		}
+	}
+}
+
+func (s *source) serve(conn net.Conn) {
+	s.Lock()
+	if s.closed() {
+		s.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+		conn.Close()
+	}()
+
+	if err := s.read(conn); err != nil && err != io.EOF && !s.closed() {
+		log.Error("socket source: read failed", zap.String("remote", conn.RemoteAddr().String()), zap.Error(err))
+	}
+}
+
+func (s *source) read(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for {
+		record, err := s.readRecord(reader)
+		if err != nil {
+			return err
+		}
+		value, err := s.cfg.Decode(record)
+		if err != nil {
+			log.Warn("socket source: can't decode record", zap.Error(err))
+			continue
+		}
+		s.push(value)
+	}
+}
+
+func (s *source) push(value interface{}) {
+	// records from concurrent connections are pushed one at a time
+	s.pushLock.Lock()
+	defer s.pushLock.Unlock()
+	s.input.Push(value)
+}
+
+func (s *source) readRecord(r *bufio.Reader) (record []byte, err error) {
+	switch s.cfg.Framing {
+	case LengthPrefixed:
+		var size [4]byte
+		if _, err = io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(size[:])
+		if int64(length) > int64(s.cfg.MaxRecordSize) {
+			return nil, fmt.Errorf("record size %d exceeds %d", length, s.cfg.MaxRecordSize)
+		}
+		record = make([]byte, length)
+		_, err = io.ReadFull(r, record)
+		return
+	default:
+		for {
+			line, isPrefix, rErr := r.ReadLine()
+			if rErr != nil {
+				return nil, rErr
+			}
+			record = append(record, line...)
+			if len(record) > s.cfg.MaxRecordSize {
+				return nil, fmt.Errorf("record size exceeds %d", s.cfg.MaxRecordSize)
+			}
+			if !isPrefix {
+				return record, nil
+			}
+		}
+	}
+}
+
+func nextDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxReconnectDelay {
+		delay = maxReconnectDelay
+	}
+	return delay
+}
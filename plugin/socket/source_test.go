@@ -0,0 +1,125 @@
+package socket
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(src *source) chan interface{} {
+	received := make(chan interface{}, 10)
+	input := stream.InputStream()
+	input.BindOut(func(event *stream.Event) {
+		received <- event.Payload
+	})
+	go func() {
+		_ = src.Run(input)
+	}()
+	return received
+}
+
+func next(t *testing.T, received chan interface{}) interface{} {
+	select {
+	case value := <-received:
+		return value
+	case <-time.After(2 * time.Second):
+		t.Fatal("record is not received")
+	}
+	return nil
+}
+
+func TestDialLinesWithReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	src := Source(SourceConfig{Addr: l.Addr().String(), ReconnectDelay: 10 * time.Millisecond})
+	received := collect(src)
+	defer src.Close()
+
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("hello world\r\nsecond"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", next(t, received))
+	conn.Close()
+	// unterminated record is emitted when the connection is closed
+	assert.Equal(t, "second", next(t, received))
+
+	conn, err = l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("after reconnect\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "after reconnect", next(t, received))
+}
+
+func TestListenLengthPrefixed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	src := Source(SourceConfig{Addr: addr, Listen: true, Framing: LengthPrefixed, ReconnectDelay: 10 * time.Millisecond})
+	received := collect(src)
+	defer src.Close()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	record := []byte("multi\nline")
+	frame := make([]byte, 4+len(record))
+	binary.BigEndian.PutUint32(frame, uint32(len(record)))
+	copy(frame[4:], record)
+	_, err = conn.Write(frame)
+	assert.NoError(t, err)
+	assert.Equal(t, "multi\nline", next(t, received))
+}
+
+func TestListenerPanicStopsRun(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	src := Source(SourceConfig{Addr: addr, Listen: true, ReconnectDelay: 10 * time.Millisecond})
+	defer src.Close()
+	input := stream.InputStream()
+	input.BindOut(func(event *stream.Event) {
+		panic("operator failed")
+	})
+	failed := make(chan error, 1)
+	go func() {
+		failed <- src.Run(input)
+	}()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("record\n"))
+	assert.NoError(t, err)
+
+	select {
+	case err = <-failed:
+		assert.Contains(t, err.Error(), "operator failed")
+	case <-time.After(2 * time.Second):
+		t.Fatal("the panic is not returned")
+	}
+}
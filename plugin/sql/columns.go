@@ -0,0 +1,55 @@
+package sql
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type column struct {
+	name  string
+	index []int
+}
+
+var columnsCache sync.Map
+
+// columnsOf maps exported struct fields to columns. Column name is taken from the `db` tag or the field name.
+// Fields tagged `db:"-"` are skipped
+func columnsOf(t reflect.Type) (res []column, err error) {
+	if cached, ok := columnsCache.Load(t); ok {
+		return cached.([]column), nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("sql sink: payload should be a struct, got %s", t.String())
+	}
+	res = appendColumns(nil, t, nil)
+	if len(res) == 0 {
+		return nil, fmt.Errorf("sql sink: %s has no exported fields", t.String())
+	}
+	columnsCache.Store(t, res)
+	return
+}
+
+func appendColumns(res []column, t reflect.Type, parent []int) []column {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("db")
+		if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			res = appendColumns(res, f.Type, index)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = f.Name
+		}
+		res = append(res, column{name: name, index: index})
+	}
+	return res
+}
@@ -0,0 +1,366 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/utils/log"
+	"github.com/discretemind/glink/utils/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultBatchSize = 100
+	// DefaultMaxBuffered is the number of batches kept until the checkpoint
+	DefaultMaxBuffered = 100
+)
+
+type SinkConfig struct {
+	// Operator name of the sink metrics. "sql" by default
+	Name  string
+	DB    *sql.DB
+	Table string
+	// Upsert key columns. Rows with the same key are updated instead of inserted, so keyed aggregates can be
+	// materialized. Plain inserts are used when empty
+	Keys []string
+	// Rows are written by statements of the batch size
+	BatchSize int
+	// Rows kept until the checkpoint. Later rows are dropped. DefaultMaxBuffered batches by default
+	MaxBuffered int
+	// Placeholder of the i-th (starting from 1) argument. "?" by default, use Dollar for PostgreSQL
+	Placeholder func(i int) string
+	// Quote of table and column identifiers. DoubleQuote by default, use Backtick for MySQL
+	Quote func(name string) string
+	// DeadLetter receives payloads of rows, which are rejected by the database. They are dropped by default
+	DeadLetter func(payload interface{}, err error)
+}
+
+func Dollar(i int) string {
+	return fmt.Sprintf("$%d", i)
+}
+
+func question(int) string {
+	return "?"
+}
+
+// DoubleQuote quotes every part of the dotted name by the ANSI quotes
+func DoubleQuote(name string) string {
+	return quote(name, `"`)
+}
+
+// Backtick quotes every part of the dotted name by backticks
+func Backtick(name string) string {
+	return quote(name, "`")
+}
+
+func quote(name string, q string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = q + strings.ReplaceAll(p, q, q+q) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+type row struct {
+	payload interface{}
+	values  []interface{}
+}
+
+// sink buffers rows until a checkpoint is completed and writes them in a single transaction then.
+// Rows are kept until the commit, so rows of a failed commit are written by the next checkpoint, which fails
+// meanwhile. Rows rejected by the database are isolated by splitting their batch and go to the dead letter
+type sink struct {
+	sync.Mutex
+	cfg     SinkConfig
+	metrics *metrics.OperatorMetrics
+	columns []column
+	rowType reflect.Type
+	rows    []row
+	// full tells the buffer overflow is logged
+	full bool
+}
+
+func Sink(cfg SinkConfig) (res *sink, err error) {
+	if cfg.DB == nil {
+		return nil, fmt.Errorf("sql sink: database is not set")
+	}
+	if cfg.Table == "" {
+		return nil, fmt.Errorf("sql sink: table is not set")
+	}
+	if cfg.Name == "" {
+		cfg.Name = "sql"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.MaxBuffered <= 0 {
+		cfg.MaxBuffered = DefaultMaxBuffered * cfg.BatchSize
+	}
+	if cfg.Placeholder == nil {
+		cfg.Placeholder = question
+	}
+	if cfg.Quote == nil {
+		cfg.Quote = DoubleQuote
+	}
+	res = &sink{
+		cfg:     cfg,
+		metrics: metrics.Operator(cfg.Name, cfg.Table),
+	}
+	return
+}
+
+func (s *sink) Push(event *stream.Event) {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.add(event.Payload); err != nil {
+		s.deadLetter(event.Payload, err)
+	}
+}
+
+func (s *sink) add(payload interface{}) (err error) {
+	v := reflect.ValueOf(payload)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return fmt.Errorf("sql sink: nil payload")
+		}
+		v = v.Elem()
+	}
+	if s.rowType == nil {
+		if s.columns, err = columnsOf(v.Type()); err != nil {
+			return
+		}
+		if err = s.checkKeys(); err != nil {
+			return
+		}
+		s.rowType = v.Type()
+	} else if v.Type() != s.rowType {
+		return fmt.Errorf("sql sink: expected %s payload, got %s", s.rowType.String(), v.Type().String())
+	}
+
+	if len(s.rows) >= s.cfg.MaxBuffered && len(s.cfg.Keys) > 0 {
+		s.rows = s.lastByKey(s.rows)
+	}
+	if len(s.rows) >= s.cfg.MaxBuffered {
+		if !s.full {
+			log.Warn("sql sink: buffer is full, rows are dropped", zap.String("table", s.cfg.Table), zap.Int("size", len(s.rows)))
+			s.full = true
+		}
+		s.metrics.Drop()
+		return
+	}
+
+	values := make([]interface{}, len(s.columns))
+	for i, c := range s.columns {
+		values[i] = v.FieldByIndex(c.index).Interface()
+	}
+	s.rows = append(s.rows, row{payload: payload, values: values})
+	return
+}
+
+func (s *sink) checkKeys() error {
+	for _, k := range s.cfg.Keys {
+		found := false
+		for _, c := range s.columns {
+			if c.name == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("sql sink: key column %s is not mapped", k)
+		}
+	}
+	return nil
+}
+
+// deadLetter passes the rejected payload to the dead letter or drops it
+func (s *sink) deadLetter(payload interface{}, err error) {
+	s.metrics.Error()
+	if s.cfg.DeadLetter != nil {
+		s.cfg.DeadLetter(payload, err)
+		return
+	}
+	log.Error("sql sink: row is rejected and dropped", zap.String("table", s.cfg.Table), zap.Error(err))
+}
+
+// rejected is a row isolated by writeBatch with its error
+type rejected struct {
+	row
+	err error
+}
+
+// write executes the rows by batches inside the transaction
+func (s *sink) write(tx *sql.Tx, rows []row) (res []rejected, err error) {
+	for len(rows) > 0 {
+		n := s.cfg.BatchSize
+		if n > len(rows) {
+			n = len(rows)
+		}
+		if res, err = s.writeBatch(tx, rows[:n], res); err != nil {
+			return
+		}
+		rows = rows[n:]
+	}
+	return
+}
+
+// writeBatch executes the batch under a savepoint. A failed batch is rolled back to the savepoint and split
+// in halves, until the rejected rows are isolated. The error is returned only when the transaction is broken
+func (s *sink) writeBatch(tx *sql.Tx, rows []row, res []rejected) (_ []rejected, err error) {
+	if _, err = tx.Exec("SAVEPOINT glink_batch"); err != nil {
+		return res, err
+	}
+	query, args := s.statement(rows)
+	_, execErr := tx.Exec(query, args...)
+	if execErr == nil {
+		_, err = tx.Exec("RELEASE SAVEPOINT glink_batch")
+		return res, err
+	}
+	if _, err = tx.Exec("ROLLBACK TO SAVEPOINT glink_batch"); err != nil {
+		return res, err
+	}
+	if len(rows) == 1 {
+		return append(res, rejected{rows[0], execErr}), nil
+	}
+	half := len(rows) / 2
+	if res, err = s.writeBatch(tx, rows[:half], res); err != nil {
+		return res, err
+	}
+	return s.writeBatch(tx, rows[half:], res)
+}
+
+// lastByKey keeps the last row of every key, since a single upsert statement can't update the same row twice
+func (s *sink) lastByKey(rows []row) (res []row) {
+	var keyIndex []int
+	for _, k := range s.cfg.Keys {
+		for i, c := range s.columns {
+			if c.name == k {
+				keyIndex = append(keyIndex, i)
+			}
+		}
+	}
+
+	positions := make(map[string]int, len(rows))
+	for _, r := range rows {
+		key := make([]interface{}, len(keyIndex))
+		for i, index := range keyIndex {
+			key[i] = r.values[index]
+		}
+		k := fmt.Sprintf("%#v", key)
+		if pos, ok := positions[k]; ok {
+			res[pos] = r
+			continue
+		}
+		positions[k] = len(res)
+		res = append(res, r)
+	}
+	return
+}
+
+func (s *sink) statement(rows []row) (query string, args []interface{}) {
+	names := make([]string, len(s.columns))
+	for i, c := range s.columns {
+		names[i] = s.cfg.Quote(c.name)
+	}
+
+	b := strings.Builder{}
+	b.WriteString("INSERT INTO ")
+	b.WriteString(s.cfg.Quote(s.cfg.Table))
+	b.WriteString(" (")
+	b.WriteString(strings.Join(names, ", "))
+	b.WriteString(") VALUES ")
+
+	args = make([]interface{}, 0, len(rows)*len(s.columns))
+	for r, row := range rows {
+		if r > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for i, value := range row.values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			args = append(args, value)
+			b.WriteString(s.cfg.Placeholder(len(args)))
+		}
+		b.WriteString(")")
+	}
+
+	if len(s.cfg.Keys) > 0 {
+		keys := make([]string, len(s.cfg.Keys))
+		for i, k := range s.cfg.Keys {
+			keys[i] = s.cfg.Quote(k)
+		}
+		b.WriteString(" ON CONFLICT (")
+		b.WriteString(strings.Join(keys, ", "))
+		b.WriteString(")")
+		var updates []string
+		for i, c := range s.columns {
+			if !s.isKey(c.name) {
+				updates = append(updates, fmt.Sprintf("%s = excluded.%s", names[i], names[i]))
+			}
+		}
+		if len(updates) == 0 {
+			b.WriteString(" DO NOTHING")
+		} else {
+			b.WriteString(" DO UPDATE SET ")
+			b.WriteString(strings.Join(updates, ", "))
+		}
+	}
+	return b.String(), args
+}
+
+func (s *sink) isKey(name string) bool {
+	for _, k := range s.cfg.Keys {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckpointComplete writes buffered rows in a transaction and commits it. Rows of a failed transaction are kept
+// for the next checkpoint, rejected rows go to the dead letter
+func (s *sink) CheckpointComplete(id uint64) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.commit()
+}
+
+func (s *sink) Close() error {
+	return s.CheckpointComplete(0)
+}
+
+func (s *sink) commit() (err error) {
+	if len(s.rows) == 0 {
+		return nil
+	}
+	if len(s.cfg.Keys) > 0 {
+		s.rows = s.lastByKey(s.rows)
+	}
+	tx, err := s.cfg.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("sql sink: %v", err)
+	}
+	bad, err := s.write(tx, s.rows)
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			log.Error("sql sink: rollback failed", zap.String("table", s.cfg.Table), zap.Error(rErr))
+		}
+		return fmt.Errorf("sql sink: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sql sink: %v", err)
+	}
+	s.rows = nil
+	s.full = false
+	// rejected rows are reported once the rest is committed, since a failed commit writes them again
+	for _, r := range bad {
+		s.deadLetter(r.payload, r.err)
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package sql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/discretemind/glink/stream"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+type wordCount struct {
+	Word  string `db:"word"`
+	Count int    `db:"count"`
+	Debug string `db:"-"`
+}
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE words (word TEXT PRIMARY KEY, count INTEGER)")
+	assert.NoError(t, err)
+	return db
+}
+
+func count(t *testing.T, db *sql.DB, word string) (res int) {
+	err := db.QueryRow("SELECT count FROM words WHERE word = ?", word).Scan(&res)
+	if err == sql.ErrNoRows {
+		return -1
+	}
+	assert.NoError(t, err)
+	return
+}
+
+func TestUpsertOnCheckpoint(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	s, err := Sink(SinkConfig{DB: db, Table: "words", Keys: []string{"word"}, BatchSize: 2})
+	assert.NoError(t, err)
+
+	s.Push(&stream.Event{Payload: wordCount{Word: "a", Count: 1}})
+	s.Push(&stream.Event{Payload: &wordCount{Word: "a", Count: 2}})
+	s.Push(&stream.Event{Payload: wordCount{Word: "b", Count: 1}})
+	assert.NoError(t, s.CheckpointComplete(1))
+	assert.Equal(t, 2, count(t, db, "a"))
+	assert.Equal(t, 1, count(t, db, "b"))
+
+	s.Push(&stream.Event{Payload: wordCount{Word: "b", Count: 5}})
+	assert.Equal(t, 1, count(t, db, "b"))
+	assert.NoError(t, s.Close())
+	assert.Equal(t, 5, count(t, db, "b"))
+}
+
+func TestInsertStatement(t *testing.T) {
+	s, err := Sink(SinkConfig{DB: &sql.DB{}, Table: "public.words", Placeholder: Dollar})
+	assert.NoError(t, err)
+	assert.NoError(t, s.add(wordCount{Word: "a", Count: 1}))
+
+	query, args := s.statement([]row{{values: []interface{}{"a", 1}}, {values: []interface{}{"b", 2}}})
+	assert.Equal(t, `INSERT INTO "public"."words" ("word", "count") VALUES ($1, $2), ($3, $4)`, query)
+	assert.Equal(t, []interface{}{"a", 1, "b", 2}, args)
+
+	s.cfg.Keys = []string{"word"}
+	query, _ = s.statement([]row{{values: []interface{}{"a", 1}}})
+	assert.Equal(t, `INSERT INTO "public"."words" ("word", "count") VALUES ($1, $2) ON CONFLICT ("word") DO UPDATE SET "count" = excluded."count"`, query)
+
+	assert.Equal(t, "`my``table`", Backtick("my`table"))
+}
+
+func TestDeadLetterRejectedRows(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	_, err := db.Exec(`CREATE TRIGGER fail BEFORE INSERT ON words WHEN NEW.word LIKE 'bad%'
+		BEGIN SELECT RAISE(ABORT, 'broken'); END`)
+	assert.NoError(t, err)
+
+	var rejected []interface{}
+	s, err := Sink(SinkConfig{DB: db, Table: "words", BatchSize: 4, DeadLetter: func(payload interface{}, err error) {
+		rejected = append(rejected, payload)
+	}})
+	assert.NoError(t, err)
+
+	for _, word := range []string{"a", "bad1", "b", "c", "bad2", "d"} {
+		s.Push(&stream.Event{Payload: wordCount{Word: word, Count: 1}})
+	}
+	assert.NoError(t, s.CheckpointComplete(1))
+	for _, word := range []string{"a", "b", "c", "d"} {
+		assert.Equal(t, 1, count(t, db, word), word)
+	}
+	assert.Equal(t, []interface{}{wordCount{Word: "bad1", Count: 1}, wordCount{Word: "bad2", Count: 1}}, rejected)
+}
+
+func TestRetryFailedCommit(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	closed, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	assert.NoError(t, closed.Close())
+
+	s, err := Sink(SinkConfig{DB: closed, Table: "words", BatchSize: 2})
+	assert.NoError(t, err)
+	for _, word := range []string{"a", "b", "c"} {
+		s.Push(&stream.Event{Payload: wordCount{Word: word, Count: 1}})
+	}
+	assert.Error(t, s.CheckpointComplete(1))
+
+	// rows are kept until they are committed
+	s.cfg.DB = db
+	assert.NoError(t, s.CheckpointComplete(2))
+	for _, word := range []string{"a", "b", "c"} {
+		assert.Equal(t, 1, count(t, db, word), word)
+	}
+}
+
+func TestDropWhenBufferIsFull(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	s, err := Sink(SinkConfig{Name: "sql-full", DB: db, Table: "words", Keys: []string{"word"}, MaxBuffered: 2})
+	assert.NoError(t, err)
+	dropped := s.metrics.Dropped()
+	// updates of buffered keys are compacted instead of dropped
+	for i := 1; i <= 3; i++ {
+		s.Push(&stream.Event{Payload: wordCount{Word: "a", Count: i}})
+	}
+	s.Push(&stream.Event{Payload: wordCount{Word: "b", Count: 1}})
+	s.Push(&stream.Event{Payload: wordCount{Word: "c", Count: 1}})
+	assert.Equal(t, dropped+1, s.metrics.Dropped())
+
+	assert.NoError(t, s.CheckpointComplete(1))
+	assert.Equal(t, 3, count(t, db, "a"))
+	assert.Equal(t, 1, count(t, db, "b"))
+	assert.Equal(t, -1, count(t, db, "c"))
+}
@@ -6,50 +6,145 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/discretemind/glink/stream/quantum"
 	"github.com/discretemind/glink/utils/crypto"
 	"github.com/discretemind/glink/utils/encoder"
+	"github.com/discretemind/glink/utils/metrics"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 	"go.uber.org/zap"
 	"math"
 	"net"
 	"reflect"
+	"sync"
 	"time"
 )
 
 type handlerType reflect.Value
 
 type client struct {
+	sync.RWMutex
 	key          crypto.PrivateKey
 	version      Version
 	logger       *zap.Logger
 	outbox       chan *Packet
 	clusterIndex uint16
 	clusterId    crypto.Certificate
-	clusterKey   crypto.PublicKey
 	conn         *net.UDPConn
-	handlers     map[uint16]handlerType
+	// token authorizes the worker to join the cluster
+	token string
+	// connecting is the handshake waiting for the accept. session encrypts commands once it's accepted
+	// and nonce is the connect nonce of the session
+	connecting *pendingConnect
+	session    *session
+	nonce      Nonce
+	// heard is the last time a command of the master was received
+	heard     time.Time
+	heartbeat HeartbeatConfig
+	handlers  map[uint16]handlerType
+	link      *link
+	quanta    *quantum.Set
+	releases  map[uint32]*release
+	// migration hooks move keyed state of quanta through checkpoints
+	onAssignQuanta  func(quanta []quantum.Quantum, checkpoint uint64) error
+	onReleaseQuanta func(quanta []quantum.Quantum) (checkpoint uint64, err error)
+	// job hooks run the job of the master. jobDone is closed, when the last hook completes
+	onStart  func(config []byte) error
+	onStop   func() error
+	job      JobStatus
+	jobError string
+	jobDone  chan struct{}
 }
 
 func Client(version Version, logger *zap.Logger) (res *client) {
 	res = &client{
-		key:      crypto.GeneratePrivateKey(),
-		version:  version,
-		outbox:   make(chan *Packet, 100),
-		handlers: make(map[uint16]handlerType),
+		key:       crypto.GeneratePrivateKey(),
+		version:   version,
+		outbox:    make(chan *Packet, 100),
+		handlers:  make(map[uint16]handlerType),
+		quanta:    quantum.NewSet(),
+		releases:  make(map[uint32]*release),
+		heartbeat: DefaultHeartbeat,
+		jobDone:   make(chan struct{}),
 	}
+	close(res.jobDone)
 	res.logger = logger.With(zap.String("id", res.key.Certificate().String()))
+	res.link = newLink(len(Packet{})-2, res.writeFrame)
 	res.registerHandler(res.startHandler)
+	res.registerHandler(res.stopHandler)
+	res.registerHandler(res.assignQuantumHandler)
+	res.registerHandler(res.releaseQuantumHandler)
+	res.registerHandler(res.releasingQuantumResponseHandler)
+	res.registerHandler(res.syncStatusHandler)
+	res.registerHandler(res.prepareQuantumHandler)
+	res.quanta.OnRelease(res.quantumReleased)
 	return
 }
 
-func (c *client) runOutbox(parent context.Context) {
-	ctx, _ := context.WithCancel(parent)
+// SetKey sets the identity of the worker, which the master allows by its certificate. It's set before Connect
+func (c *client) SetKey(key crypto.PrivateKey, logger *zap.Logger) {
+	c.Lock()
+	defer c.Unlock()
+	c.key = key
+	c.logger = logger.With(zap.String("id", key.Certificate().String()))
+}
+
+// SetToken sets the token authorizing the worker to join the cluster. It's set before Connect
+func (c *client) SetToken(token string) {
+	c.Lock()
+	defer c.Unlock()
+	c.token = token
+}
+
+// HeartbeatConfig tells how often the worker sends heartbeats and when it considers the master lost
+// and reconnects. The master returns every heartbeat
+type HeartbeatConfig struct {
+	Period  time.Duration
+	Timeout time.Duration
+}
+
+var DefaultHeartbeat = HeartbeatConfig{
+	Period:  time.Second,
+	Timeout: 10 * time.Second,
+}
+
+// SetHeartbeat is set before Connect
+func (c *client) SetHeartbeat(cfg HeartbeatConfig) {
+	c.Lock()
+	defer c.Unlock()
+	c.heartbeat = cfg
+}
+
+// RejectedError is returned by Connect, when the master rejects the worker or revokes its session
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return "rejected by master: " + e.Reason
+}
+
+// isRejected tells whether the master won't accept the worker
+func isRejected(err error) bool {
+	var r *RejectedError
+	var v *IncompatibleVersionError
+	return errors.As(err, &r) || errors.As(err, &v)
+}
+
+func (c *client) runOutbox(ctx context.Context) {
+	retransmit := time.NewTicker(retransmitPeriod)
+	defer retransmit.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			c.logger.Info("Close client")
 			return
+		case now := <-retransmit.C:
+			// the master doesn't deliver commands after a lost one, so the session starts again
+			if _, l := c.currentSession(); l.Retransmit(now) > 0 {
+				c.logger.Warn("commands to master are lost, reconnecting")
+				c.reconnect()
+			}
 		case p := <-c.outbox:
 			if p == nil {
 				c.logger.Info("Close client channel")
@@ -66,6 +161,30 @@ func (c *client) ID() crypto.Certificate {
 	return c.key.Certificate()
 }
 
+// Protocol is the version of commands negotiated with the master. It's ZeroVersion before the connection
+func (c *client) Protocol() Version {
+	c.RLock()
+	defer c.RUnlock()
+	if c.session == nil {
+		return ZeroVersion
+	}
+	return c.session.protocol
+}
+
+// ClusterIndex is assigned by the master, when it accepts the connection. It's 0 before
+func (c *client) ClusterIndex() uint16 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.clusterIndex
+}
+
+// currentSession returns the session and the link, which are replaced by reconnects
+func (c *client) currentSession() (*session, *link) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.session, c.link
+}
+
 func (c *client) Connect(ctx context.Context, master string, id string) (err error) {
 	clusterIdData, err := base64.StdEncoding.DecodeString(id)
 	if err != nil {
@@ -89,124 +208,331 @@ func (c *client) Connect(ctx context.Context, master string, id string) (err err
 		return err
 	}
 
-	pub := c.key.Public()
-	pk := NewPeerKey(c.key.Certificate(), pub)
-	packet := Packet{01, 01}
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.runConnectionReader(c.conn)
+	}()
+
+	go c.runOutbox(childCtx)
+	go c.runSession(childCtx)
+	go c.runHealthCheck(childCtx, 5*time.Second)
+
+	select {
+	case <-ctx.Done():
+		_ = c.conn.Close()
+		return nil
+	case err = <-done:
+		_ = c.conn.Close()
+		return err
+	}
+}
+
+// pendingConnect is the ephemeral key and the challenge of the connect
+type pendingConnect struct {
+	ephemeral crypto.PrivateKey
+	nonce     Nonce
+	signed    time.Time
+	packet    Packet
+}
 
+// newConnect signs the connect with a new ephemeral key, so every session has its own keys
+func (c *client) newConnect() (res *pendingConnect, err error) {
+	res = &pendingConnect{
+		ephemeral: crypto.GeneratePrivateKey(),
+		signed:    time.Now(),
+		packet:    Packet{01, 01},
+	}
+	if res.nonce, err = newNonce(); err != nil {
+		return nil, err
+	}
 	data := encoder.EncodeRaw(ConnectCmd{
 		Cluster: c.clusterId,
 		Version: c.version,
-		Peer:    pk,
+		Peer:    NewPeerKey(c.key.Certificate(), c.key.Public()),
+		Session: res.ephemeral.Public(),
+		Nonce:   res.nonce,
+		Time:    res.signed.UnixNano(),
+		Token:   c.connectToken(res.nonce),
 	})
 	signedData := encoder.EncodeRaw(SignedMessage{
 		Data:      data,
 		Signature: c.key.Sign(data[:]),
 	})
+	copy(res.packet[2:], signedData[:])
+	return
+}
 
-	copy(packet[2:], signedData[:])
-
-	childCtx, cancel := context.WithCancel(ctx)
-	go func() {
-		err = c.runConnectionReader(childCtx, c.conn, c.clusterId, pub)
-		cancel()
-	}()
-
-	go c.runOutbox(ctx)
-
-	_, err = c.conn.Write(packet[:])
-	if err != nil {
-		cancel()
+// connectToken proves the token of the worker for the connect
+func (c *client) connectToken(nonce Nonce) (res [32]byte) {
+	if c.token == "" {
+		return
 	}
+	return tokenProof(digestToken(c.token), nonce, c.key.Certificate())
+}
 
-	<-ctx.Done()
-
-	return err
+// runSession keeps the session with the master. It repeats the connect with backoff until the master accepts
+// it, since it may be lost. Then it sends heartbeats and reconnects with the identity of the worker, when the master
+// isn't heard for the heartbeat timeout
+func (c *client) runSession(ctx context.Context) {
+	backoff := retransmitTimeout
+	for {
+		c.RLock()
+		cfg, heard, connected := c.heartbeat, c.heard, c.clusterIndex != 0
+		c.RUnlock()
+		wait := cfg.Period
+		switch {
+		case !connected:
+			c.sendConnect()
+			wait = backoff
+			if backoff *= 2; backoff > maxRetransmitTimeout {
+				backoff = maxRetransmitTimeout
+			}
+		case time.Since(heard) > cfg.Timeout:
+			c.logger.Warn("Master is lost, reconnecting", zap.Duration("silent", time.Since(heard)))
+			c.reconnect()
+			backoff = retransmitTimeout
+			continue
+		default:
+			backoff = retransmitTimeout
+			if err := c.send(heartbeatCmd{Time: time.Now().UnixNano()}); err != nil {
+				c.logger.Error("can't send heartbeat", zap.Error(err))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
 }
 
-func (c *client) runConnectionReader(ctx context.Context, conn *net.UDPConn, clusterId crypto.Certificate, pubKey [32]byte) (err error) {
-	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	if err != nil {
-		return
+// sendConnect writes the pending connect. The connect is signed again before the master considers it expired
+func (c *client) sendConnect() {
+	c.Lock()
+	if c.connecting == nil || time.Since(c.connecting.signed) > connectMaxAge/2 {
+		connecting, err := c.newConnect()
+		if err != nil {
+			c.Unlock()
+			c.logger.Error("can't sign connect", zap.Error(err))
+			return
+		}
+		c.connecting = connecting
 	}
+	packet := c.connecting.packet
+	c.Unlock()
+	if _, err := c.conn.Write(packet[:]); err != nil {
+		c.logger.Error("can't connect to master", zap.Error(err))
+	}
+}
 
-	packet := Packet{}
-	if _, err = conn.Read(packet[:]); err != nil {
-		return err
+// reconnect drops the session, so the worker connects again. Commands of the session not delivered yet are lost
+func (c *client) reconnect() {
+	c.Lock()
+	defer c.Unlock()
+	c.clusterIndex = 0
+	c.session = nil
+	c.connecting = nil
+	c.link = newLink(len(Packet{})-2, c.writeFrame)
+}
+
+// runConnectionReader handles accepts until the session starts and commands of the session then.
+// It returns, when the master rejects the worker
+func (c *client) runConnectionReader(conn *net.UDPConn) error {
+	for {
+		packet := Packet{}
+		if _, err := conn.Read(packet[:]); err != nil {
+			return err
+		}
+		var err error
+		if c.ClusterIndex() == 0 {
+			err = c.handleAccept(packet[:])
+		} else {
+			err = c.handlePacket(packet[:])
+		}
+		if err != nil {
+			if isRejected(err) {
+				return err
+			}
+			c.logger.Error("can't handle packet", zap.Error(err))
+		}
 	}
+}
 
+// decodeSigned decodes the message signed by the master
+func (c *client) decodeSigned(data []byte, cmd interface{}) error {
 	msg := SignedMessage{}
-	if err := encoder.DecodeRaw(packet[:], &msg); err != nil {
+	if err := decodeRaw(data, &msg); err != nil {
 		return err
 	}
-
-	if !clusterId.Verify(msg.Data, msg.Signature) {
+	if !c.clusterId.Verify(msg.Data, msg.Signature) {
 		return errors.New("invalid signature")
 	}
+	return decodeRaw(msg.Data, cmd)
+}
 
+// handleAccept starts the session, when the master accepts the pending connect. Accepts of other connects
+// are replays or answers to expired connects
+func (c *client) handleAccept(data []byte) error {
+	switch binary.BigEndian.Uint16(data[:2]) {
+	case rejectHeader:
+		return c.handleReject(data[2:])
+	case connectHeader:
+	default:
+		return errors.New("accept is expected")
+	}
 	cmd := AcceptCmd{}
-	if err := encoder.DecodeRaw(msg.Data, &cmd); err != nil {
+	if err := c.decodeSigned(data[2:], &cmd); err != nil {
 		return err
 	}
 
-	c.clusterKey = cmd.Key
+	if cmd.Version.Major() != c.version.Major() || c.version.CompareByMinor(cmd.Version) < 0 {
+		return fmt.Errorf("protocol %s isn't supported by version %s", cmd.Version, c.version)
+	}
+	c.Lock()
+	if c.connecting == nil || c.connecting.nonce != cmd.Nonce {
+		c.Unlock()
+		return errors.New("accept of another connect")
+	}
+	send, receive, err := sessionKeys(c.connecting.ephemeral, cmd.Key, cmd.Nonce, cmd.Challenge, c.clusterId, c.ID())
+	if err != nil {
+		c.Unlock()
+		return err
+	}
+	c.session = newSession(send, receive, cmd.Version)
+	c.link = c.session.link(len(Packet{})-2, c.writeFrame)
+	c.nonce = cmd.Nonce
 	c.clusterIndex = cmd.ClusterIndex
+	c.connecting = nil
+	c.heard = time.Now()
+	c.Unlock()
+
+	c.logger.Info("Connection Accepted", zap.Uint16("Cluster", cmd.ClusterIndex), zap.Bool("resumed", cmd.Resumed),
+		zap.String("protocol", cmd.Version.String()))
+	if !cmd.Resumed {
+		// commands of the session are read after the quanta are dropped
+		c.dropQuanta()
+	}
+	if status, _ := c.JobStatus(); status != JobIdle {
+		// the master forgets jobs of workers it has evicted
+		c.reportJob()
+	}
+	return nil
+}
 
-	c.logger.Info("Connection Accepted", zap.Uint16("Cluster", cmd.ClusterIndex))
-	if err := conn.SetReadDeadline(time.Time{}); err != nil {
-		return err
+// dropQuanta forgets quanta of the worker, which the master doesn't know anymore, since they are given to other
+// workers. Their keyed state is dropped by the release hook
+func (c *client) dropQuanta() {
+	quanta := c.quanta.Quanta()
+	if len(quanta) == 0 {
+		return
 	}
-	for {
-		go c.runHealthCheck(ctx, 5*time.Second)
-		packet := Packet{}
-		if _, err := conn.Read(packet[:]); err != nil {
-			return err
-		}
-		if err = c.handlePacket(packet[:]); err != nil {
-			c.logger.Error("can't handle error", zap.Error(err))
+	c.quanta.Drop(quanta...)
+	c.Lock()
+	hook := c.onReleaseQuanta
+	for _, q := range quanta {
+		delete(c.releases, q.Index)
+	}
+	c.Unlock()
+	c.logger.Warn("Quanta are lost", zap.Int("count", len(quanta)))
+	if hook != nil {
+		if _, err := hook(quanta); err != nil {
+			c.logger.Error("can't drop quanta state", zap.Error(err))
 		}
-
 	}
 }
-func (c *client) handlePacket(data []byte) error {
-	msg := ProtectedCommand{}
-	if err := encoder.DecodeRaw(data, &msg); err != nil {
+
+// handleReject returns RejectedError, when the master rejects the pending connect or the session
+func (c *client) handleReject(data []byte) error {
+	cmd := RejectCmd{}
+	if err := c.decodeSigned(data, &cmd); err != nil {
 		return err
 	}
-	cmd, ok := ProtectedCommands.GetCommand(msg.Command)
-	if !ok {
-		return fmt.Errorf("command not found %d", msg.Command)
+	c.RLock()
+	pending := c.connecting != nil && c.connecting.nonce == cmd.Nonce
+	current := c.session != nil && c.nonce == cmd.Nonce
+	c.RUnlock()
+	if !pending && !current {
+		return errors.New("reject of another connect")
 	}
-	c.logger.Info("Client command ", zap.Uint16("id", msg.Command))
-	h, ok := c.handlers[msg.Command]
-	if !ok {
-		return fmt.Errorf("can't execute. handler not found %d", msg.Command)
+	c.logger.Error("Connection rejected", zap.String("reason", cmd.Reason))
+	if cmd.Version != ZeroVersion {
+		return &IncompatibleVersionError{Master: cmd.Version, Worker: c.version}
 	}
+	return &RejectedError{Reason: cmd.Reason}
+}
 
-	decoded, ok := c.key.Decrypt(c.clusterKey, msg.Payload[:])
-	if !ok {
-		return errors.New("can't decrypt message")
+// handlePacket delivers commands of the master in the order of their channel. Accepts repeated
+// for repeated connects are skipped
+func (c *client) handlePacket(data []byte) error {
+	switch binary.BigEndian.Uint16(data[:2]) {
+	case connectHeader:
+		return nil
+	case rejectHeader:
+		return c.handleReject(data[2:])
 	}
-
-	if err := encoder.DecodeRaw(decoded, cmd.Interface()); err != nil {
+	_, l := c.currentSession()
+	f, err := l.decode(data)
+	if err != nil {
 		return err
 	}
+	if f.kind == frameAck {
+		l.Ack(f)
+		return nil
+	}
+	messages, err := l.Receive(f)
+	for _, message := range messages {
+		call, dErr := c.decodeCommand(f.channel, message)
+		if dErr == nil {
+			dErr = call()
+		}
+		if dErr != nil && err == nil {
+			err = dErr
+		}
+	}
+	return err
+}
 
-	v := reflect.Value(h)
-	res := v.Call([]reflect.Value{cmd})
-	if len(res) > 0 && !res[0].IsNil() {
-		return res[0].Interface().(error)
-
+// decodeCommand decrypts the command with the session, so only commands of the master are delivered once,
+// and binds its handler
+func (c *client) decodeCommand(channel Channel, data []byte) (func() error, error) {
+	s, _ := c.currentSession()
+	if s == nil {
+		return nil, errors.New("not connected")
 	}
-	return nil
+	id, cmd, err := s.open(channel, data)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	c.heard = time.Now()
+	c.Unlock()
+	c.logger.Debug("client command", zap.Uint16("id", id))
+	h, ok := c.handlers[id]
+	if !ok {
+		return nil, fmt.Errorf("can't execute. handler not found %d", id)
+	}
+
+	return func() error {
+		res := reflect.Value(h).Call([]reflect.Value{cmd})
+		if len(res) > 0 && !res[0].IsNil() {
+			return res[0].Interface().(error)
+		}
+		return nil
+	}, nil
 }
 
-func (c *client) runHealthCheck(parent context.Context, period time.Duration) {
-	ctx, _ := context.WithCancel(parent)
+// runHealthCheck publishes metrics of the host, while the worker is connected
+func (c *client) runHealthCheck(ctx context.Context, period time.Duration) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-time.After(period):
+			if c.ClusterIndex() == 0 {
+				continue
+			}
 			if err := c.publishMetrics(); err != nil {
 				c.logger.Error("can't publish metrics ", zap.Error(err))
 			}
@@ -230,33 +556,37 @@ func (c *client) publishMetrics() error {
 		MemUsed:  uint32(v.Used / mBytes),
 		MemFree:  uint32(v.Free / mBytes),
 	}
-	packet, err := c.packMessage(cmd)
-	if err != nil {
-		return err
-	}
-	c.outbox <- packet
-	return nil
+	metrics.Host(c.ID().String(), metrics.HostMetrics{
+		CpuUsage: cmd.CpuUsage,
+		MemTotal: cmd.MemTotal,
+		MemUsed:  cmd.MemUsed,
+		MemFree:  cmd.MemFree,
+	})
+	return c.send(cmd)
 }
 
-func (c *client) packMessage(value interface{}) (res *Packet, err error) {
-	packet := Packet{}
-	binary.BigEndian.PutUint16(packet[:2], c.clusterIndex)
-
-	commandId, ok := ProtectedCommands.GetId(value)
-	if !ok {
-		return nil, fmt.Errorf("command not registered %v", reflect.TypeOf(value).String())
+// send encrypts the command with the session and delivers it to the master by its channel
+func (c *client) send(cmd interface{}) error {
+	s, l := c.currentSession()
+	if s == nil {
+		return errors.New("not connected")
 	}
+	return s.send(l, cmd)
+}
 
-	encoded, err := c.key.Encrypt(c.clusterKey, encoder.EncodeRaw(value))
-	if err != nil {
-		return
+// writeFrame queues the frame of the worker. A full outbox drops it, so control frames wait for retransmit
+func (c *client) writeFrame(data []byte) error {
+	packet := Packet{}
+	if 2+len(data) > len(packet) {
+		return fmt.Errorf("message of %d bytes doesn't fit the packet", len(data))
 	}
-
-	cmd := &ProtectedCommand{
-		Command: commandId,
-		Payload: encoded,
+	index := c.ClusterIndex()
+	binary.BigEndian.PutUint16(packet[:2], index)
+	copy(packet[2:], data)
+	select {
+	case c.outbox <- &packet:
+		return nil
+	default:
+		return errors.New("outbox is full")
 	}
-
-	copy(packet[2:], encoder.EncodeRaw(cmd))
-	return &packet, nil
 }
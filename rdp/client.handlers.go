@@ -1,24 +1,33 @@
 package rdp
 
 import (
+	"errors"
+	"github.com/discretemind/glink/stream/quantum"
 	"go.uber.org/zap"
 	"log"
 	"reflect"
+	"time"
 )
 
 func (c *client) registerHandler(value interface{}) {
+	id, h := commandHandler(value, 1)
+	c.handlers[id] = h
+}
+
+// commandHandler finds the command of the handler by the type of its last argument
+func commandHandler(value interface{}, args int) (uint16, handlerType) {
 	v := reflect.ValueOf(value)
 	t := v.Type()
-	if t.NumIn() != 1 {
+	if t.NumIn() != args {
 		log.Fatal("invalid handler func")
 	}
-	cmdType := t.In(0)
+	cmdType := t.In(args - 1)
 
 	id, ok := ProtectedCommands.GetIdByType(cmdType)
 	if !ok {
 		log.Fatal("invalid handler func. Command not found")
 	}
-	c.handlers[id] = handlerType(v)
+	return id, handlerType(v)
 }
 
 //func (c *client) execHandler(id uint16, cmd reflect.Value) error {
@@ -33,17 +42,239 @@ func (c *client) registerHandler(value interface{}) {
 //	return nil
 //}
 
+// OnStart sets the hook running the job of StartCmd. The config is JSON encoded glink.Config. The job is
+// reported running, unless the hook fails
+func (c *client) OnStart(f func(config []byte) error) {
+	c.Lock()
+	defer c.Unlock()
+	c.onStart = f
+}
+
+// OnStop sets the hook stopping the job gracefully by StopCmd
+func (c *client) OnStop(f func() error) {
+	c.Lock()
+	defer c.Unlock()
+	c.onStop = f
+}
+
+// JobStatus is the last status of the job reported to the master
+func (c *client) JobStatus() (JobStatus, string) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.job, c.jobError
+}
+
 func (c *client) startHandler(cmd *StartCmd) {
-	c.logger.Info("startHandler", zap.String("cmd", string(cmd.Config)))
+	c.runJobHook(func() error {
+		c.RLock()
+		hook := c.onStart
+		c.RUnlock()
+		if hook == nil {
+			return errors.New("worker doesn't run jobs")
+		}
+		return hook(cmd.Config)
+	}, JobRunning)
 }
 
-func (c *client) OnStart(f func(cfg string)) (err error) {
-	//return c.subscribe(func(cmd *StartCmd) {
-	//	f(string(cmd.Config))
-	//})
-	return nil
+func (c *client) stopHandler(cmd *StopCmd) {
+	c.runJobHook(func() error {
+		c.RLock()
+		hook := c.onStop
+		c.RUnlock()
+		if hook == nil {
+			return nil
+		}
+		return hook()
+	}, JobStopped)
 }
 
-func (c *client) OnStop(f func() error) {
+// runJobHook runs the hook out of the connection reader, since jobs take time to start and to complete
+// the final checkpoint. Hooks run in the order of commands and their outcome is reported to the master
+func (c *client) runJobHook(hook func() error, status JobStatus) {
+	c.Lock()
+	prev, done := c.jobDone, make(chan struct{})
+	c.jobDone = done
+	c.Unlock()
+
+	go func() {
+		defer close(done)
+		<-prev
+		err := hook()
+		c.Lock()
+		c.job, c.jobError = status, ""
+		if err != nil {
+			c.job, c.jobError = JobFailed, err.Error()
+		}
+		c.Unlock()
+		c.reportJob()
+	}()
+}
+
+// reportJob sends the job status to the master. Masters of the older protocol don't track jobs
+func (c *client) reportJob() {
+	c.RLock()
+	cmd := jobStatusCmd{Status: c.job, Error: c.jobError}
+	c.RUnlock()
+	if err := c.send(cmd); err != nil {
+		c.logger.Warn("can't report job status", zap.Stringer("status", cmd.Status), zap.Error(err))
+	}
+}
+
+// Quanta assigned to the worker by the master. Jobs limit their keyed streams to them
+func (c *client) Quanta() *quantum.Set {
+	return c.quanta
+}
+
+// OnAssignQuanta sets the hook restoring state of quanta moved to the worker from the checkpoint.
+// Keys of the quanta are paused until it returns
+func (c *client) OnAssignQuanta(f func(quanta []quantum.Quantum, checkpoint uint64) error) {
+	c.Lock()
+	defer c.Unlock()
+	c.onAssignQuanta = f
+}
+
+// OnReleaseQuanta sets the hook saving state of quanta moving to another worker. It returns the checkpoint,
+// which the master passes to the new owner. The worker keeps the quanta and retries the hook, when it fails
+func (c *client) OnReleaseQuanta(f func(quanta []quantum.Quantum) (checkpoint uint64, err error)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onReleaseQuanta = f
+}
+
+func (c *client) prepareQuantumHandler(cmd *prepareQuantumCmd) error {
+	return c.quanta.Prepare(cmd.Quantum...)
+}
+
+// assignQuantumHandler restores state of quanta, which the worker doesn't own yet, and then assigns them,
+// so their paused keys are resumed
+func (c *client) assignQuantumHandler(cmd *assignQuantumCmd) error {
+	status := c.quanta.Status()
+	var added []quantum.Quantum
+	for _, q := range cmd.Quantum {
+		if _, ok := status[q.Index]; !ok {
+			added = append(added, q)
+		}
+	}
+	err := c.quanta.Prepare(added...)
+	c.RLock()
+	hook := c.onAssignQuanta
+	c.RUnlock()
+	if err == nil && hook != nil && len(added) > 0 {
+		if err = hook(added, cmd.Checkpoint); err != nil {
+			c.logger.Error("can't restore quanta state", zap.Uint64("checkpoint", cmd.Checkpoint), zap.Error(err))
+		}
+	}
+	// keys must not stay paused, even if their state is lost
+	if aErr := c.quanta.Assign(cmd.Quantum...); aErr != nil {
+		err = aErr
+	}
+	if sErr := c.send(assignQuantumResponseCmd{ID: cmd.ID, OK: err == nil}); sErr != nil {
+		return sErr
+	}
+	return err
+}
+
+type release struct {
+	id         string
+	checkpoint uint64
+	// migrating delays the notification until the state is saved
+	migrating bool
+	released  bool
+}
+
+// releaseRetryDelay is the pause before the release hook is retried, when the state isn't saved
+var releaseRetryDelay = time.Second
+
+// releaseQuantumHandler saves state of the quanta by the release hook and releases them. Without the hook
+// quanta are released once their windows are closed. The master is notified by quantumReleased
+func (c *client) releaseQuantumHandler(cmd *releaseQuantumCmd) {
+	c.Lock()
+	hook := c.onReleaseQuanta
+	for _, q := range cmd.Quantum {
+		c.releases[q.Index] = &release{id: cmd.ID, migrating: hook != nil}
+	}
+	c.Unlock()
+
+	if hook != nil {
+		checkpoint, err := hook(cmd.Quantum)
+		if err != nil {
+			// the quanta are kept with their state until it's saved
+			c.logger.Error("can't save quanta state, the release is retried", zap.Error(err))
+			time.AfterFunc(releaseRetryDelay, func() {
+				c.retryRelease(cmd)
+			})
+			return
+		}
+		var released []quantum.Quantum
+		c.Lock()
+		for _, q := range cmd.Quantum {
+			if r, ok := c.releases[q.Index]; ok && r.id == cmd.ID {
+				r.checkpoint = checkpoint
+				r.migrating = false
+				if r.released {
+					released = append(released, q)
+				}
+			}
+		}
+		c.Unlock()
+		for _, q := range released {
+			c.notifyReleased(q)
+		}
+	}
+	for _, q := range cmd.Quantum {
+		c.quanta.Release(q)
+	}
+}
+
+// retryRelease runs the release again for quanta, which aren't dropped or released by another command meanwhile
+func (c *client) retryRelease(cmd *releaseQuantumCmd) {
+	retry := &releaseQuantumCmd{ID: cmd.ID}
+	c.RLock()
+	for _, q := range cmd.Quantum {
+		if r, ok := c.releases[q.Index]; ok && r.id == cmd.ID {
+			retry.Quantum = append(retry.Quantum, q)
+		}
+	}
+	c.RUnlock()
+	if len(retry.Quantum) > 0 {
+		c.releaseQuantumHandler(retry)
+	}
+}
+
+func (c *client) quantumReleased(q quantum.Quantum) {
+	c.Lock()
+	if r, ok := c.releases[q.Index]; ok && r.migrating {
+		r.released = true
+		c.Unlock()
+		return
+	}
+	c.Unlock()
+	c.notifyReleased(q)
+}
+
+func (c *client) notifyReleased(q quantum.Quantum) {
+	c.Lock()
+	cmd := releasingQuantumCmd{Quantum: q}
+	if r, ok := c.releases[q.Index]; ok {
+		cmd.ID = r.id
+		cmd.Checkpoint = r.checkpoint
+	}
+	delete(c.releases, q.Index)
+	c.Unlock()
+	if err := c.send(cmd); err != nil {
+		c.logger.Error("can't notify quantum release", zap.Uint32("quantum", q.Index), zap.Error(err))
+	}
+}
+
+func (c *client) releasingQuantumResponseHandler(cmd *releasingQuantumResponseCmd) {
+	if !cmd.OK {
+		c.logger.Warn("quantum release is not accepted", zap.String("id", cmd.ID))
+	}
+}
 
+func (c *client) syncStatusHandler(cmd *syncStatusCmd) error {
+	return c.send(syncStatusResponseCmd{
+		ID:      cmd.ID,
+		Quantum: c.quanta.Status(),
+	})
 }
@@ -1,6 +1,7 @@
 package rdp
 
 import (
+	"errors"
 	"fmt"
 	"github.com/discretemind/glink/stream/quantum"
 	"github.com/discretemind/glink/utils/crypto"
@@ -28,12 +29,27 @@ type ConnectCmd struct {
 	Cluster crypto.Certificate //
 	Peer    PeerKey            //32 byte
 	Version Version
+	Session crypto.PublicKey //Ephemeral key of the session
+	Nonce   Nonce            //Challenge of the worker, which the accept returns
+	Time    int64            //Unix nanoseconds, when the connect was signed
+	Token   [32]byte         //Proof of the worker token. Empty without the token
 }
 
 //Command from manager
 type AcceptCmd struct {
-	Key          crypto.PublicKey
+	Key          crypto.PublicKey //Ephemeral key of the master for the session
 	ClusterIndex uint16
+	Nonce        Nonce   //Challenge of the accepted connect
+	Challenge    Nonce   //Challenge of the master, which salts session keys
+	Resumed      bool    //The master knows the worker, so it keeps its quanta
+	Version      Version //Protocol negotiated for the session
+}
+
+//Command from manager. Rejects the connect or the session with the nonce
+type RejectCmd struct {
+	Nonce   Nonce
+	Reason  string
+	Version Version //Version of the master, when the major version of the worker is incompatible
 }
 
 /*
@@ -43,10 +59,13 @@ var ProtectedCommands *commandRegistry
 
 type ProtectedCommand struct {
 	Command uint16
+	Epoch   uint32 //Rotation of the session key
+	Counter uint64 //Grows with every command of the channel
 	Payload []byte
 }
 
 type StartCmd struct {
+	//JSON encoded glink.Config of the job
 	Config []byte
 	Start  bool
 }
@@ -55,6 +74,17 @@ type StopCmd struct {
 	Stop bool
 }
 
+//job => master. Status of the job started by StartCmd
+type jobStatusCmd struct {
+	Status JobStatus
+	Error  string //Why the job failed
+}
+
+//job => master and back. Shows both sides are alive
+type heartbeatCmd struct {
+	Time int64 //Unix nanoseconds, when the worker sent it
+}
+
 //from jobs
 type MetricsCmd struct {
 	CpuUsage                   uint32
@@ -72,14 +102,21 @@ type syncStatusCmd struct {
 //job =>
 type syncStatusResponseCmd struct {
 	ID      string
-	Quantum map[uint32]uint32 //running quantum by space. Sometime it's not possible to close quantum space immediately because of Time Windows. When window will be closed - quantum will be released
+	Quantum map[uint32]uint32 //Open windows by running quantum. Sometime it's not possible to close quantum space immediately because of Time Windows. When window will be closed - quantum will be released
 	Status  uint8             //Current job status
 }
 
+//master => job. Asks to release quanta, when their windows are closed
+type releaseQuantumCmd struct {
+	ID      string
+	Quantum []quantum.Quantum
+}
+
 //job => master
 type releasingQuantumCmd struct {
-	ID    string
-	Space uint32 //Quantum space to release
+	ID         string
+	Checkpoint uint64          //Checkpoint with the state of the quantum keys. 0 when the state is not migrated
+	Quantum    quantum.Quantum //Released quantum
 }
 
 //master => job. Accepted
@@ -90,6 +127,13 @@ type releasingQuantumResponseCmd struct {
 
 //master =>
 type assignQuantumCmd struct {
+	ID         string
+	Checkpoint uint64 //Checkpoint to restore the state of the quanta keys from. 0 for quanta without state
+	Quantum    []quantum.Quantum
+}
+
+//master => job. Pauses keys of quanta, which are moving to the worker, until they are assigned
+type prepareQuantumCmd struct {
 	ID      string
 	Quantum []quantum.Quantum
 }
@@ -114,15 +158,20 @@ type recordSyncCmd struct {
 type commandRegistry struct {
 	byType map[reflect.Type]uint16
 	byID   map[uint16]reflect.Type
+	since  map[uint16]Version
 }
 
 func newRegistry() *commandRegistry {
 	return &commandRegistry{
 		byType: make(map[reflect.Type]uint16),
 		byID:   make(map[uint16]reflect.Type),
+		since:  make(map[uint16]Version),
 	}
 }
 
+// ErrUnsupportedCommand is returned for commands newer than the protocol of the session
+var ErrUnsupportedCommand = errors.New("command is not supported by the protocol")
+
 type PeerKey [64]byte
 
 func NewPeerKey(id crypto.Certificate, pub crypto.PublicKey) (res PeerKey) {
@@ -142,10 +191,27 @@ func (pk PeerKey) Public() (res crypto.PublicKey) {
 }
 
 func (r commandRegistry) register(id uint16, value interface{}) {
+	r.registerSince(id, value, NewVersion(1, 0, 0))
+}
+
+// registerSince adds the command introduced by the protocol version
+func (r commandRegistry) registerSince(id uint16, value interface{}, since Version) {
 	t := reflect.TypeOf(value)
-	fmt.Println("register", id, value)
 	r.byType[t] = id
 	r.byID[id] = t
+	r.since[id] = since.Protocol()
+}
+
+// Supports tells whether the command is known by the protocol
+func (r commandRegistry) Supports(id uint16, protocol Version) error {
+	since, ok := r.since[id]
+	if !ok {
+		return fmt.Errorf("command not found %d", id)
+	}
+	if protocol.Major() != since.Major() || protocol.CompareByMinor(since) < 0 {
+		return fmt.Errorf("%w: command %d is added by %s, protocol is %s", ErrUnsupportedCommand, id, since, protocol)
+	}
+	return nil
 }
 
 func (r commandRegistry) GetCommand(id uint16) (res reflect.Value, ok bool) {
@@ -179,4 +245,14 @@ func init() {
 	ProtectedCommands.register(1, MetricsCmd{})
 	ProtectedCommands.register(2, StartCmd{})
 	ProtectedCommands.register(3, StopCmd{})
+	ProtectedCommands.register(4, assignQuantumCmd{})
+	ProtectedCommands.register(5, assignQuantumResponseCmd{})
+	ProtectedCommands.register(6, releaseQuantumCmd{})
+	ProtectedCommands.register(7, releasingQuantumCmd{})
+	ProtectedCommands.register(8, releasingQuantumResponseCmd{})
+	ProtectedCommands.register(9, syncStatusCmd{})
+	ProtectedCommands.register(10, syncStatusResponseCmd{})
+	ProtectedCommands.register(11, prepareQuantumCmd{})
+	ProtectedCommands.register(12, heartbeatCmd{})
+	ProtectedCommands.registerSince(13, jobStatusCmd{}, NewVersion(1, 1, 0))
 }
@@ -0,0 +1,151 @@
+package rdp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/discretemind/glink/utils/encoder"
+	"go.uber.org/zap"
+)
+
+// tokenDigest is kept by the master instead of the token
+type tokenDigest [32]byte
+
+func digestToken(token string) tokenDigest {
+	return sha256.Sum256([]byte(token))
+}
+
+// tokenProof shows the token in the connect. It's bound to the connect nonce and the worker, so
+// the token can't be taken from captured connects
+func tokenProof(digest tokenDigest, nonce Nonce, id crypto.Certificate) (res [32]byte) {
+	h := hmac.New(sha256.New, digest[:])
+	h.Write(nonce[:])
+	h.Write(id[:])
+	copy(res[:], h.Sum(nil))
+	return
+}
+
+// AllowToken lets workers connecting with the token join the cluster. A cluster without tokens and allowed
+// workers is open to any worker knowing its id
+func (m *master) AllowToken(token string) {
+	m.Lock()
+	defer m.Unlock()
+	digest := digestToken(token)
+	m.tokens[digest] = true
+	delete(m.revokedTokens, digest)
+	m.restricted = true
+}
+
+// RevokeToken rejects connects with the token. Workers joined with it are disconnected,
+// unless they are allowed by their certificates. An open cluster stays open to other workers
+func (m *master) RevokeToken(token string) error {
+	digest := digestToken(token)
+	var revoked []uint16
+	m.Lock()
+	delete(m.tokens, digest)
+	m.revokedTokens[digest] = true
+	for index, h := range m.handshakes {
+		if h.token == digest && !m.allowed[m.workers[index].ID] {
+			revoked = append(revoked, index)
+		}
+	}
+	m.Unlock()
+	for _, index := range revoked {
+		if err := m.disconnect(index, "token is revoked"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowWorker lets the worker join the cluster by its certificate without a token
+func (m *master) AllowWorker(id crypto.Certificate) {
+	m.Lock()
+	defer m.Unlock()
+	m.allowed[id] = true
+	delete(m.revokedWorkers, id)
+	m.restricted = true
+}
+
+// RevokeWorker rejects connects of the worker even with a valid token. The connected worker is disconnected.
+// An open cluster stays open to other workers
+func (m *master) RevokeWorker(id crypto.Certificate) error {
+	m.Lock()
+	delete(m.allowed, id)
+	m.revokedWorkers[id] = true
+	index, ok := m.byID[id]
+	m.Unlock()
+	if !ok {
+		return nil
+	}
+	return m.disconnect(index, "worker is revoked")
+}
+
+// authorize checks the worker by allowed certificates and tokens. It returns the digest of the token,
+// which the worker has joined with, or the reason of the rejection
+func (m *master) authorize(cmd ConnectCmd) (token tokenDigest, reason string) {
+	m.RLock()
+	defer m.RUnlock()
+	id := cmd.Peer.ID()
+	if m.revokedWorkers[id] {
+		return token, "worker is revoked"
+	}
+	if m.allowed[id] {
+		return token, ""
+	}
+	for digest := range m.revokedTokens {
+		if proof := tokenProof(digest, cmd.Nonce, id); hmac.Equal(proof[:], cmd.Token[:]) {
+			return token, "token is revoked"
+		}
+	}
+	for digest := range m.tokens {
+		if proof := tokenProof(digest, cmd.Nonce, id); hmac.Equal(proof[:], cmd.Token[:]) {
+			return digest, ""
+		}
+	}
+	// revoking every token and worker doesn't open the cluster
+	switch {
+	case !m.restricted:
+		return token, ""
+	case cmd.Token == [32]byte{}:
+		return token, "token is required"
+	}
+	return token, "unknown token"
+}
+
+// reject tells the worker, why its connect or session is rejected
+func (m *master) reject(addr *net.UDPAddr, cmd RejectCmd) error {
+	data := encoder.EncodeRaw(cmd)
+	reject := make([]byte, 2)
+	binary.BigEndian.PutUint16(reject, rejectHeader)
+	return m.write(addr, append(reject, encoder.EncodeRaw(SignedMessage{
+		Data:      data,
+		Signature: m.key.Sign(data),
+	})...))
+}
+
+// disconnect rejects the session of the worker and removes it
+func (m *master) disconnect(index uint16, reason string) error {
+	m.RLock()
+	w, ok := m.workers[index]
+	var addr *net.UDPAddr
+	var worker Worker
+	if ok {
+		addr, worker = w.Addr, *w
+	}
+	h := m.handshakes[index]
+	m.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown worker %d", index)
+	}
+	if err := m.reject(addr, RejectCmd{Nonce: h.nonce, Reason: reason}); err != nil {
+		m.logger.Warn("can't reject worker", zap.Uint16("index", index), zap.Error(err))
+	}
+	err := m.Remove(index)
+	m.emit(WorkerEvent{Type: WorkerRevoked, Worker: worker})
+	return err
+}
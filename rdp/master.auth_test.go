@@ -0,0 +1,108 @@
+package rdp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// run connects the client and returns the result of Connect
+func run(ctx context.Context, m *master, c *client) chan error {
+	res := make(chan error, 1)
+	go func() {
+		res <- c.Connect(ctx, m.Addr().String(), m.ClusterID())
+	}()
+	return res
+}
+
+func rejected(t *testing.T, result chan error) string {
+	select {
+	case err := <-result:
+		var r *RejectedError
+		if assert.True(t, errors.As(err, &r), "%v", err) {
+			return r.Reason
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the worker isn't rejected")
+	}
+	return ""
+}
+
+func TestMasterAuthorizesWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	m.AllowToken("secret")
+
+	anonymous := Client(NewVersion(1, 0, 0), zap.NewNop())
+	assert.Equal(t, "token is required", rejected(t, run(ctx, m, anonymous)))
+	wrong := Client(NewVersion(1, 0, 0), zap.NewNop())
+	wrong.SetToken("guess")
+	assert.Equal(t, "unknown token", rejected(t, run(ctx, m, wrong)))
+	assert.Empty(t, m.Workers())
+
+	connect(t, ctx, m, func(c *client) {
+		c.SetToken("secret")
+	})
+	key := crypto.GeneratePrivateKey()
+	m.AllowWorker(key.Certificate())
+	connect(t, ctx, m, func(c *client) {
+		c.SetKey(key, zap.NewNop())
+	})
+	assert.Len(t, m.Workers(), 2)
+}
+
+func TestMasterRevokesWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	m.AllowToken("secret")
+	key := crypto.GeneratePrivateKey()
+	m.AllowWorker(key.Certificate())
+
+	byToken := Client(NewVersion(1, 0, 0), zap.NewNop())
+	byToken.SetToken("secret")
+	byTokenResult := run(ctx, m, byToken)
+	allowed := Client(NewVersion(1, 0, 0), zap.NewNop())
+	allowed.SetKey(key, zap.NewNop())
+	allowed.SetToken("secret")
+	allowedResult := run(ctx, m, allowed)
+	assert.Eventually(t, func() bool {
+		return byToken.ClusterIndex() != 0 && allowed.ClusterIndex() != 0
+	}, time.Second, 5*time.Millisecond)
+
+	// the worker allowed by its certificate stays
+	assert.NoError(t, m.RevokeToken("secret"))
+	assert.Equal(t, "token is revoked", rejected(t, byTokenResult))
+	_, ok := m.owner(byToken.ID())
+	assert.False(t, ok)
+	again := Client(NewVersion(1, 0, 0), zap.NewNop())
+	again.SetToken("secret")
+	assert.Equal(t, "token is revoked", rejected(t, run(ctx, m, again)))
+
+	assert.NoError(t, m.RevokeWorker(allowed.ID()))
+	assert.Equal(t, "worker is revoked", rejected(t, allowedResult))
+	assert.Empty(t, m.Workers())
+}
+
+func TestOpenClusterStaysOpenOnRevoke(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+
+	revoked := Client(NewVersion(1, 0, 0), zap.NewNop())
+	assert.NoError(t, m.RevokeWorker(revoked.ID()))
+	assert.NoError(t, m.RevokeToken("leaked"))
+	assert.Equal(t, "worker is revoked", rejected(t, run(ctx, m, revoked)))
+	byToken := Client(NewVersion(1, 0, 0), zap.NewNop())
+	byToken.SetToken("leaked")
+	assert.Equal(t, "token is revoked", rejected(t, run(ctx, m, byToken)))
+
+	connect(t, ctx, m)
+	assert.Len(t, m.Workers(), 1)
+}
@@ -0,0 +1,167 @@
+package rdp
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FailureConfig tells when the master suspects and evicts workers, which heartbeats aren't received.
+// Zero durations disable the stage
+type FailureConfig struct {
+	SuspectAfter time.Duration
+	EvictAfter   time.Duration
+}
+
+// DefaultFailure tolerates a few heartbeats lost by DefaultHeartbeat
+var DefaultFailure = FailureConfig{
+	SuspectAfter: 5 * time.Second,
+	EvictAfter:   30 * time.Second,
+}
+
+// failureCheckPeriod is how often the master checks heartbeats of workers
+var failureCheckPeriod = 100 * time.Millisecond
+
+type WorkerEventType uint8
+
+const (
+	WorkerJoined WorkerEventType = iota
+	// WorkerResumed is a reconnect of a known worker, which keeps its cluster index and quanta
+	WorkerResumed
+	WorkerSuspected
+	WorkerRecovered
+	// WorkerLost is an evicted worker. Its quanta are free, so they are reassigned by the rebalance
+	WorkerLost
+	WorkerRevoked
+)
+
+func (t WorkerEventType) String() string {
+	switch t {
+	case WorkerJoined:
+		return "joined"
+	case WorkerResumed:
+		return "resumed"
+	case WorkerSuspected:
+		return "suspected"
+	case WorkerRecovered:
+		return "recovered"
+	case WorkerLost:
+		return "lost"
+	case WorkerRevoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// WorkerEvent is a change of the worker membership
+type WorkerEvent struct {
+	Type   WorkerEventType
+	Worker Worker
+}
+
+func (m *master) SetFailureDetection(cfg FailureConfig) {
+	m.Lock()
+	defer m.Unlock()
+	m.failureCfg = cfg
+}
+
+// OnWorkerEvent adds the listener of worker events. Listeners of lost workers may reassign their quanta,
+// when the automatic rebalance is disabled
+func (m *master) OnWorkerEvent(f func(e WorkerEvent)) {
+	m.Lock()
+	defer m.Unlock()
+	m.onWorkerEvent = append(m.onWorkerEvent, f)
+}
+
+func (m *master) emit(events ...WorkerEvent) {
+	m.RLock()
+	listeners := append([]func(e WorkerEvent){}, m.onWorkerEvent...)
+	m.RUnlock()
+	for _, e := range events {
+		m.logger.Info("Worker event", zap.Stringer("type", e.Type), zap.Uint16("index", e.Worker.ClusterIndex),
+			zap.String("id", e.Worker.ID.String()))
+		for _, f := range listeners {
+			f(e)
+		}
+	}
+}
+
+// heartbeatHandler returns the heartbeat, so the worker knows the master is alive
+func (m *master) heartbeatHandler(w Worker, cmd *heartbeatCmd) error {
+	return m.Send(w.ClusterIndex, heartbeatCmd{Time: cmd.Time})
+}
+
+func (m *master) runFailureDetector(ctx context.Context) {
+	ticker := time.NewTicker(failureCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.checkFailures(now)
+		}
+	}
+}
+
+// checkFailures suspects workers silent for SuspectAfter and evicts ones silent for EvictAfter
+func (m *master) checkFailures(now time.Time) {
+	var events []WorkerEvent
+	var lost []uint16
+	m.Lock()
+	cfg := m.failureCfg
+	for index, w := range m.workers {
+		silent := now.Sub(w.LastSeen)
+		switch {
+		case cfg.EvictAfter > 0 && silent > cfg.EvictAfter:
+			lost = append(lost, index)
+		case cfg.SuspectAfter > 0 && silent > cfg.SuspectAfter:
+			if !w.Suspected {
+				w.Suspected = true
+				events = append(events, WorkerEvent{Type: WorkerSuspected, Worker: *w})
+			}
+		case w.Suspected:
+			w.Suspected = false
+			events = append(events, WorkerEvent{Type: WorkerRecovered, Worker: *w})
+		}
+	}
+	m.Unlock()
+
+	sort.Slice(lost, func(i, j int) bool {
+		return lost[i] < lost[j]
+	})
+	for _, index := range lost {
+		if e, ok := m.evict(index); ok {
+			events = append(events, e)
+		}
+	}
+	m.emit(events...)
+}
+
+// evict removes the worker. It connects again as a new one, once it finds the master doesn't answer
+func (m *master) evict(index uint16) (e WorkerEvent, ok bool) {
+	w, ok := m.Worker(index)
+	if !ok {
+		return
+	}
+	if err := m.Remove(index); err != nil {
+		m.logger.Error("can't evict worker", zap.Uint16("index", index), zap.Error(err))
+	}
+	return WorkerEvent{Type: WorkerLost, Worker: w}, true
+}
+
+// evictLink evicts the worker, which hasn't acknowledged commands of the link. The worker never receives
+// commands after them. Links replaced by reconnects meanwhile are ignored
+func (m *master) evictLink(index uint16, l *link) {
+	m.RLock()
+	current := m.links[index] == l
+	m.RUnlock()
+	if !current {
+		return
+	}
+	if e, ok := m.evict(index); ok {
+		m.emit(e)
+	}
+}
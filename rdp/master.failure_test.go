@@ -0,0 +1,192 @@
+package rdp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// events records worker events of the master
+type events struct {
+	sync.Mutex
+	list []WorkerEventType
+}
+
+func record(m *master, index func() uint16) *events {
+	res := &events{}
+	m.OnWorkerEvent(func(e WorkerEvent) {
+		res.Lock()
+		defer res.Unlock()
+		if e.Worker.ClusterIndex == index() {
+			res.list = append(res.list, e.Type)
+		}
+	})
+	return res
+}
+
+func (e *events) has(types ...WorkerEventType) bool {
+	e.Lock()
+	defer e.Unlock()
+	i := 0
+	for _, t := range e.list {
+		if i < len(types) && t == types[i] {
+			i++
+		}
+	}
+	return i == len(types)
+}
+
+func fastHeartbeat(c *client) {
+	c.SetHeartbeat(HeartbeatConfig{Period: 20 * time.Millisecond, Timeout: 200 * time.Millisecond})
+}
+
+func TestMasterEvictsLostWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	m.SetFailureDetection(FailureConfig{SuspectAfter: 150 * time.Millisecond, EvictAfter: 400 * time.Millisecond})
+	assert.NoError(t, m.SetQuantumSpace(4))
+	m.SetRebalance(RebalanceConfig{Auto: true})
+
+	c1 := connect(t, ctx, m, fastHeartbeat)
+	crashCtx, crash := context.WithCancel(ctx)
+	c2 := connect(t, crashCtx, m, fastHeartbeat)
+	w2, _ := m.owner(c2.ID())
+	e := record(m, func() uint16 {
+		return w2.ClusterIndex
+	})
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 2 && len(c2.Quanta().Quanta()) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// heartbeats keep workers alive
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(t, m.Workers(), 2)
+	crash()
+	assert.Eventually(t, func() bool {
+		return e.has(WorkerSuspected, WorkerLost)
+	}, 2*time.Second, 10*time.Millisecond)
+	_, ok := m.owner(c2.ID())
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 4
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWorkerReconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	m.SetFailureDetection(FailureConfig{SuspectAfter: 100 * time.Millisecond})
+	assert.NoError(t, m.SetQuantumSpace(2))
+	p := proxy(t, ctx, m.Addr(), 0, 0)
+	partition := func(loss float64) {
+		p.Lock()
+		defer p.Unlock()
+		p.loss = loss
+	}
+
+	c := Client(NewVersion(1, 0, 0), zap.NewNop())
+	fastHeartbeat(c)
+	go func() {
+		_ = c.Connect(ctx, p.conn.LocalAddr().String(), m.ClusterID())
+	}()
+	assert.Eventually(t, func() bool {
+		w, ok := m.owner(c.ID())
+		return ok && c.ClusterIndex() == w.ClusterIndex
+	}, time.Second, 5*time.Millisecond)
+	w, _ := m.owner(c.ID())
+	e := record(m, func() uint16 {
+		return w.ClusterIndex
+	})
+	assert.NoError(t, m.Distribute())
+	assert.Eventually(t, func() bool {
+		return len(c.Quanta().Quanta()) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// the suspected worker resumes its session with its index and quanta
+	partition(1)
+	assert.Eventually(t, func() bool {
+		return c.ClusterIndex() == 0
+	}, time.Second, 5*time.Millisecond)
+	partition(0)
+	assert.Eventually(t, func() bool {
+		return c.ClusterIndex() == w.ClusterIndex && e.has(WorkerSuspected, WorkerResumed, WorkerRecovered)
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Len(t, c.Quanta().Quanta(), 2)
+	assert.Len(t, m.Quanta(w.ClusterIndex), 2)
+
+	// the evicted worker joins again without its quanta, since they are free
+	m.SetFailureDetection(FailureConfig{EvictAfter: 100 * time.Millisecond})
+	partition(1)
+	assert.Eventually(t, func() bool {
+		return e.has(WorkerLost)
+	}, time.Second, 5*time.Millisecond)
+	partition(0)
+	assert.Eventually(t, func() bool {
+		_, ok := m.owner(c.ID())
+		return ok && c.ClusterIndex() != 0 && len(c.Quanta().Quanta()) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+// lostCommand adds a control frame to the link, which is given up by the next retransmit
+func lostCommand(l *link) {
+	l.Lock()
+	s := &l.sending[ControlChannel]
+	s.last++
+	s.pending[s.last] = &pendingFrame{seq: s.last}
+	l.Unlock()
+	giveUp(l)
+}
+
+func TestMasterEvictsWorkerOfLostCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	c := connect(t, ctx, m, fastHeartbeat)
+	w, _ := m.owner(c.ID())
+	e := record(m, func() uint16 {
+		return w.ClusterIndex
+	})
+
+	m.RLock()
+	l := m.links[w.ClusterIndex]
+	m.RUnlock()
+	lostCommand(l)
+	assert.Eventually(t, func() bool {
+		return e.has(WorkerLost)
+	}, time.Second, 5*time.Millisecond)
+
+	// the worker doesn't hear the master and connects again
+	assert.Eventually(t, func() bool {
+		w, ok := m.owner(c.ID())
+		return ok && c.ClusterIndex() == w.ClusterIndex
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestWorkerReconnectsOnLostCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	c := connect(t, ctx, m, fastHeartbeat)
+	w, _ := m.owner(c.ID())
+	e := record(m, func() uint16 {
+		return w.ClusterIndex
+	})
+	assert.Eventually(t, func() bool {
+		return c.ClusterIndex() == w.ClusterIndex
+	}, time.Second, 5*time.Millisecond)
+
+	_, l := c.currentSession()
+	lostCommand(l)
+	assert.Eventually(t, func() bool {
+		return e.has(WorkerResumed)
+	}, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return c.ClusterIndex() == w.ClusterIndex
+	}, time.Second, 5*time.Millisecond)
+}
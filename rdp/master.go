@@ -0,0 +1,520 @@
+package rdp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/discretemind/glink/utils/encoder"
+	"github.com/discretemind/glink/utils/metrics"
+	"go.uber.org/zap"
+)
+
+// connectHeader starts connect packets of clients and accepts of the master, and rejectHeader starts
+// rejects of the master, so they are never assigned as cluster indexes
+const (
+	connectHeader = 0x0101
+	rejectHeader  = 0x0102
+)
+
+// Worker is a client connected to the master
+type Worker struct {
+	ID           crypto.Certificate
+	Key          crypto.PublicKey
+	ClusterIndex uint16
+	Version      Version
+	// Protocol is the version of commands, which the master and the worker exchange
+	Protocol  Version
+	Addr      *net.UDPAddr
+	Connected time.Time
+	LastSeen  time.Time
+	Metrics   MetricsCmd
+	// Overloaded workers get a smaller share of quanta by the rebalance
+	Overloaded bool
+	// Job is the last status of the job reported by the worker
+	Job      JobStatus
+	JobError string
+	// Suspected workers haven't sent heartbeats for FailureConfig.SuspectAfter
+	Suspected bool
+	// QuantumStatus is open windows by quanta of the worker reported by the last sync
+	QuantumStatus map[uint32]uint32
+}
+
+type master struct {
+	sync.RWMutex
+	key       crypto.PrivateKey
+	version   Version
+	logger    *zap.Logger
+	conn      *net.UDPConn
+	workers   map[uint16]*Worker
+	byID      map[crypto.Certificate]uint16
+	nextIndex uint16
+	handlers  map[uint16]handlerType
+	links     map[uint16]*link
+	sessions  map[uint16]*session
+	// handshakes are the accepted connects of worker sessions and connects the recently seen connect nonces
+	handshakes map[uint16]handshake
+	connects   map[Nonce]time.Time
+	// tokens and allowed workers authorize connects. The cluster is open to any worker, until one is allowed
+	tokens         map[tokenDigest]bool
+	allowed        map[crypto.Certificate]bool
+	revokedTokens  map[tokenDigest]bool
+	revokedWorkers map[crypto.Certificate]bool
+	restricted     bool
+	pool           quantum.IPool
+	// moves are target workers of quanta being released by their owners
+	moves       map[uint32]crypto.Certificate
+	handshakeId uint64
+	// rebalancing serializes rebalances. A pending rebalance runs once moves are completed
+	rebalancing      sync.Mutex
+	rebalanceCfg     RebalanceConfig
+	rebalancePending bool
+	failureCfg       FailureConfig
+	onWorkerEvent    []func(e WorkerEvent)
+}
+
+// Master coordinates workers of the cluster. Its key certificate is the cluster id, which workers connect with
+func Master(key crypto.PrivateKey, version Version, logger *zap.Logger) (res *master) {
+	res = &master{
+		key:      key,
+		version:  version,
+		workers:  make(map[uint16]*Worker),
+		byID:     make(map[crypto.Certificate]uint16),
+		handlers: make(map[uint16]handlerType),
+		links:    make(map[uint16]*link),
+		sessions: make(map[uint16]*session),
+		moves:    make(map[uint32]crypto.Certificate),
+
+		handshakes: make(map[uint16]handshake),
+		connects:   make(map[Nonce]time.Time),
+		tokens:     make(map[tokenDigest]bool),
+		allowed:    make(map[crypto.Certificate]bool),
+		failureCfg: DefaultFailure,
+
+		revokedTokens:  make(map[tokenDigest]bool),
+		revokedWorkers: make(map[crypto.Certificate]bool),
+	}
+	res.logger = logger.With(zap.String("cluster", key.Certificate().String()))
+	res.registerHandler(res.metricsHandler)
+	res.registerHandler(res.assignQuantumResponseHandler)
+	res.registerHandler(res.releasingQuantumHandler)
+	res.registerHandler(res.syncStatusResponseHandler)
+	res.registerHandler(res.heartbeatHandler)
+	res.registerHandler(res.jobStatusHandler)
+	return
+}
+
+func (m *master) registerHandler(value interface{}) {
+	id, h := commandHandler(value, 2)
+	m.handlers[id] = h
+}
+
+// ClusterID is the base64 certificate, which workers pass to Connect
+func (m *master) ClusterID() string {
+	return m.key.Certificate().String()
+}
+
+func (m *master) Listen(addr string) error {
+	local, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	m.conn, err = net.ListenUDP("udp", local)
+	return err
+}
+
+func (m *master) Addr() net.Addr {
+	return m.conn.LocalAddr()
+}
+
+// Serve handles packets until the context is done
+func (m *master) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		_ = m.conn.Close()
+	}()
+	go m.runRetransmits(ctx)
+	go m.runFailureDetector(ctx)
+	for {
+		packet := Packet{}
+		n, addr, err := m.conn.ReadFromUDP(packet[:])
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err = m.handlePacket(packet[:n], addr); err != nil {
+			m.logger.Error("can't handle packet", zap.String("from", addr.String()), zap.Error(err))
+		}
+	}
+}
+
+// runRetransmits resends commands not acknowledged by workers
+func (m *master) runRetransmits(ctx context.Context) {
+	ticker := time.NewTicker(retransmitPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.RLock()
+			links := make(map[uint16]*link, len(m.links))
+			for index, l := range m.links {
+				links[index] = l
+			}
+			m.RUnlock()
+			for index, l := range links {
+				if lost := l.Retransmit(now); lost > 0 {
+					m.logger.Warn("commands to worker are lost, evicting it", zap.Uint16("index", index), zap.Int("count", lost))
+					m.evictLink(index, l)
+				}
+			}
+		}
+	}
+}
+
+func (m *master) Close() error {
+	return m.conn.Close()
+}
+
+func (m *master) handlePacket(data []byte, addr *net.UDPAddr) error {
+	if len(data) < 2 {
+		return errors.New("packet is too short")
+	}
+	index := binary.BigEndian.Uint16(data[:2])
+	if index == connectHeader {
+		return m.handleConnect(data[2:], addr)
+	}
+	return m.handleCommand(index, data[2:], addr)
+}
+
+func (m *master) handleConnect(data []byte, addr *net.UDPAddr) error {
+	msg := SignedMessage{}
+	if err := decodeRaw(data, &msg); err != nil {
+		return err
+	}
+	cmd := ConnectCmd{}
+	if err := decodeRaw(msg.Data, &cmd); err != nil {
+		return err
+	}
+	if cmd.Cluster != m.key.Certificate() {
+		return fmt.Errorf("connect to another cluster %s", cmd.Cluster)
+	}
+	id := cmd.Peer.ID()
+	if !id.Verify(msg.Data, msg.Signature) {
+		return fmt.Errorf("invalid signature of %s", id)
+	}
+
+	signed := time.Unix(0, cmd.Time)
+	if age := time.Since(signed); age > connectMaxAge || age < -connectMaxAge {
+		return fmt.Errorf("connect of %s signed at %s is expired", id, signed)
+	}
+	token, reason := m.authorize(cmd)
+	if reason != "" {
+		if err := m.reject(addr, RejectCmd{Nonce: cmd.Nonce, Reason: reason}); err != nil {
+			return err
+		}
+		return fmt.Errorf("worker %s is rejected: %s", id, reason)
+	}
+	protocol, err := Negotiate(m.version, cmd.Version)
+	if err != nil {
+		if rErr := m.reject(addr, RejectCmd{Nonce: cmd.Nonce, Reason: err.Error(), Version: m.version}); rErr != nil {
+			return rErr
+		}
+		return err
+	}
+	if accept, ok := m.repeatedConnect(id, cmd.Nonce); ok {
+		return m.write(addr, accept)
+	}
+
+	ephemeral := crypto.GeneratePrivateKey()
+	challenge, err := newNonce()
+	if err != nil {
+		return err
+	}
+	receive, send, err := sessionKeys(ephemeral, cmd.Session, cmd.Nonce, challenge, m.key.Certificate(), id)
+	if err != nil {
+		return err
+	}
+	h := handshake{nonce: cmd.Nonce, signed: signed, token: token, protocol: protocol}
+	s := newSession(send, receive, protocol)
+	w, joined, err := m.accept(id, cmd, addr, s, h, func(index uint16, resumed bool) []byte {
+		data := encoder.EncodeRaw(AcceptCmd{
+			Key:          ephemeral.Public(),
+			ClusterIndex: index,
+			Nonce:        cmd.Nonce,
+			Challenge:    challenge,
+			Resumed:      resumed,
+			Version:      protocol,
+		})
+		accept := make([]byte, 2)
+		binary.BigEndian.PutUint16(accept, connectHeader)
+		return append(accept, encoder.EncodeRaw(SignedMessage{
+			Data:      data,
+			Signature: m.key.Sign(data),
+		})...)
+	})
+	if err != nil {
+		return err
+	}
+	m.logger.Info("Worker connected", zap.String("id", id.String()), zap.Uint16("index", w.ClusterIndex),
+		zap.String("addr", addr.String()), zap.String("version", cmd.Version.String()),
+		zap.String("protocol", protocol.String()))
+
+	accept, _ := m.repeatedConnect(id, cmd.Nonce)
+	if err = m.write(addr, accept); err != nil {
+		return err
+	}
+	if !joined {
+		m.emit(WorkerEvent{Type: WorkerResumed, Worker: w})
+		return nil
+	}
+	m.emit(WorkerEvent{Type: WorkerJoined, Worker: w})
+	return m.autoRebalance()
+}
+
+// handshake is the accepted connect of the worker session
+type handshake struct {
+	nonce  Nonce
+	signed time.Time
+	accept []byte
+	token  tokenDigest
+	// protocol is negotiated by versions of the master and the worker
+	protocol Version
+}
+
+// repeatedConnect returns the accept of the session, when the worker repeats its connect, since the accept
+// may be lost
+func (m *master) repeatedConnect(id crypto.Certificate, nonce Nonce) ([]byte, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	index, ok := m.byID[id]
+	if !ok {
+		return nil, false
+	}
+	h, ok := m.handshakes[index]
+	return h.accept, ok && h.nonce == nonce
+}
+
+// accept starts the session of the worker. A reconnecting worker keeps its cluster index, but gets a new link.
+// Connects seen within connectMaxAge and ones older than the session are replays. joined is true for new workers
+func (m *master) accept(id crypto.Certificate, cmd ConnectCmd, addr *net.UDPAddr, s *session, h handshake,
+	accept func(index uint16, resumed bool) []byte) (res Worker, joined bool, err error) {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for nonce, at := range m.connects {
+		if now.Sub(at) > connectMaxAge {
+			delete(m.connects, nonce)
+		}
+	}
+	if _, ok := m.connects[cmd.Nonce]; ok {
+		return res, false, fmt.Errorf("replayed connect of %s", id)
+	}
+	index, ok := m.byID[id]
+	if ok && h.signed.Before(m.handshakes[index].signed) {
+		return res, false, fmt.Errorf("connect of %s is older than its session", id)
+	}
+	m.connects[cmd.Nonce] = now
+
+	if !ok {
+		joined = true
+		index = m.freeIndex()
+		m.byID[id] = index
+		m.workers[index] = &Worker{
+			ID:           id,
+			ClusterIndex: index,
+			Connected:    now,
+		}
+	}
+	m.links[index] = s.link(len(Packet{}), func(data []byte) error {
+		w, ok := m.Worker(index)
+		if !ok {
+			return fmt.Errorf("unknown worker %d", index)
+		}
+		return m.write(w.Addr, data)
+	})
+	m.sessions[index] = s
+	h.accept = accept(index, !joined)
+	m.handshakes[index] = h
+	w := m.workers[index]
+	w.Key = cmd.Peer.Public()
+	w.Version = cmd.Version
+	w.Protocol = h.protocol
+	w.Addr = addr
+	w.LastSeen = now
+	return *w, joined, nil
+}
+
+func (m *master) freeIndex() uint16 {
+	for {
+		m.nextIndex++
+		if m.nextIndex == 0 || m.nextIndex == connectHeader || m.nextIndex == rejectHeader {
+			continue
+		}
+		if _, ok := m.workers[m.nextIndex]; !ok {
+			return m.nextIndex
+		}
+	}
+}
+
+// handleCommand delivers commands of the worker in the order of their channel
+func (m *master) handleCommand(index uint16, data []byte, addr *net.UDPAddr) error {
+	m.RLock()
+	w, ok := m.workers[index]
+	l, s := m.links[index], m.sessions[index]
+	if ok {
+		ok = w.Addr.String() == addr.String()
+	}
+	m.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown worker %d", index)
+	}
+
+	f, err := l.decode(data)
+	if err != nil {
+		return err
+	}
+	if f.kind == frameAck {
+		l.Ack(f)
+		return nil
+	}
+	messages, err := l.Receive(f)
+	for _, message := range messages {
+		call, dErr := m.decodeCommand(index, s, f.channel, message)
+		if dErr == nil {
+			dErr = call()
+		}
+		if dErr != nil && err == nil {
+			err = dErr
+		}
+	}
+	return err
+}
+
+// decodeCommand decrypts the command of the worker session and binds its handler
+func (m *master) decodeCommand(index uint16, s *session, channel Channel, data []byte) (func() error, error) {
+	id, cmd, err := s.open(channel, data)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := m.handlers[id]
+	if !ok {
+		return nil, fmt.Errorf("can't execute. handler not found %d", id)
+	}
+
+	return func() error {
+		m.Lock()
+		w, ok := m.workers[index]
+		if !ok {
+			m.Unlock()
+			return fmt.Errorf("unknown worker %d", index)
+		}
+		w.LastSeen = time.Now()
+		worker := *w
+		m.Unlock()
+
+		res := reflect.Value(h).Call([]reflect.Value{reflect.ValueOf(worker), cmd})
+		if len(res) > 0 && !res[0].IsNil() {
+			return res[0].Interface().(error)
+		}
+		return nil
+	}, nil
+}
+
+// metricsHandler rebalances, when the worker becomes overloaded or recovers
+func (m *master) metricsHandler(w Worker, cmd *MetricsCmd) error {
+	m.Lock()
+	changed := false
+	if worker, ok := m.workers[w.ClusterIndex]; ok {
+		worker.Metrics = *cmd
+		overloaded := m.rebalanceCfg.overloaded(*cmd)
+		changed = overloaded != worker.Overloaded
+		worker.Overloaded = overloaded
+	}
+	m.Unlock()
+	metrics.Host(w.ID.String(), metrics.HostMetrics{
+		CpuUsage: cmd.CpuUsage,
+		MemTotal: cmd.MemTotal,
+		MemUsed:  cmd.MemUsed,
+		MemFree:  cmd.MemFree,
+	})
+	if !changed {
+		return nil
+	}
+	m.logger.Info("Worker load changed", zap.Uint16("index", w.ClusterIndex), zap.Bool("overloaded", !w.Overloaded))
+	return m.autoRebalance()
+}
+
+// Workers returns connected workers ordered by their cluster index
+func (m *master) Workers() (res []Worker) {
+	m.RLock()
+	defer m.RUnlock()
+	for _, w := range m.workers {
+		res = append(res, *w)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ClusterIndex < res[j].ClusterIndex
+	})
+	return
+}
+
+func (m *master) Worker(index uint16) (res Worker, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+	w, ok := m.workers[index]
+	if ok {
+		res = *w
+	}
+	return
+}
+
+// Start sends the job config to the worker
+func (m *master) Start(index uint16, config []byte) error {
+	return m.Send(index, StartCmd{Config: config, Start: true})
+}
+
+func (m *master) Stop(index uint16) error {
+	return m.Send(index, StopCmd{Stop: true})
+}
+
+// Send encrypts the protected command with the worker session and delivers it by its channel
+func (m *master) Send(index uint16, cmd interface{}) error {
+	m.RLock()
+	l, s := m.links[index], m.sessions[index]
+	m.RUnlock()
+	if l == nil || s == nil {
+		return fmt.Errorf("unknown worker %d", index)
+	}
+	return s.send(l, cmd)
+}
+
+func (m *master) write(addr *net.UDPAddr, data []byte) error {
+	if len(data) > len(Packet{}) {
+		return fmt.Errorf("message of %d bytes doesn't fit the packet", len(data))
+	}
+	packet := Packet{}
+	copy(packet[:], data)
+	_, err := m.conn.WriteToUDP(packet[:], addr)
+	return err
+}
+
+// decodeRaw decodes a packet received from the network, so malformed data returns an error instead of panic
+func decodeRaw(data []byte, obj interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed message: %v", r)
+		}
+	}()
+	return encoder.DecodeRaw(data, obj)
+}
@@ -0,0 +1,45 @@
+package rdp
+
+import (
+	"go.uber.org/zap"
+)
+
+// JobStatus is the state of the job run by the worker, which it reports to the master
+type JobStatus uint8
+
+const (
+	JobIdle JobStatus = iota
+	JobRunning
+	// JobFailed is a job, which can't be started or stopped. Worker.JobError tells why
+	JobFailed
+	JobStopped
+)
+
+func (s JobStatus) String() string {
+	switch s {
+	case JobIdle:
+		return "idle"
+	case JobRunning:
+		return "running"
+	case JobFailed:
+		return "failed"
+	case JobStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// jobStatusHandler tracks the job of the worker
+func (m *master) jobStatusHandler(w Worker, cmd *jobStatusCmd) {
+	m.Lock()
+	if worker, ok := m.workers[w.ClusterIndex]; ok {
+		worker.Job = cmd.Status
+		worker.JobError = cmd.Error
+	}
+	m.Unlock()
+	if cmd.Status == JobFailed {
+		m.logger.Error("Worker job failed", zap.Uint16("index", w.ClusterIndex), zap.String("error", cmd.Error))
+		return
+	}
+	m.logger.Info("Worker job changed", zap.Uint16("index", w.ClusterIndex), zap.Stringer("status", cmd.Status))
+}
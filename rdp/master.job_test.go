@@ -0,0 +1,66 @@
+package rdp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWorkerReportsJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := Master(crypto.GeneratePrivateKey(), NewVersion(1, 1, 0), zap.NewNop())
+	assert.NoError(t, m.Listen("127.0.0.1:0"))
+	go func() {
+		assert.NoError(t, m.Serve(ctx))
+	}()
+
+	started := make(chan string, 2)
+	c := Client(NewVersion(1, 1, 0), zap.NewNop())
+	c.OnStart(func(config []byte) error {
+		started <- string(config)
+		if string(config) == "broken" {
+			return errors.New("invalid config")
+		}
+		return nil
+	})
+	c.OnStop(func() error {
+		// the final checkpoint takes time, but the connection is served
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	go func() {
+		_ = c.Connect(ctx, m.Addr().String(), m.ClusterID())
+	}()
+	assert.Eventually(t, func() bool {
+		w, ok := m.owner(c.ID())
+		return ok && c.ClusterIndex() == w.ClusterIndex
+	}, time.Second, 5*time.Millisecond)
+	w, _ := m.owner(c.ID())
+	job := func(status JobStatus, reason string) func() bool {
+		return func() bool {
+			w, _ := m.Worker(w.ClusterIndex)
+			return w.Job == status && w.JobError == reason
+		}
+	}
+
+	assert.NoError(t, m.Start(w.ClusterIndex, []byte(`{"name":"job"}`)))
+	assert.Eventually(t, job(JobRunning, ""), time.Second, 5*time.Millisecond)
+	assert.Equal(t, `{"name":"job"}`, <-started)
+
+	// stop and start are run in order
+	assert.NoError(t, m.Stop(w.ClusterIndex))
+	assert.NoError(t, m.Start(w.ClusterIndex, []byte("broken")))
+	assert.Eventually(t, job(JobFailed, "invalid config"), time.Second, 5*time.Millisecond)
+	status, reason := c.JobStatus()
+	assert.Equal(t, JobFailed, status)
+	assert.Equal(t, "invalid config", reason)
+
+	assert.NoError(t, m.Stop(w.ClusterIndex))
+	assert.Eventually(t, job(JobStopped, ""), time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,194 @@
+package rdp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/discretemind/glink/utils/crypto"
+	"go.uber.org/zap"
+)
+
+// quantumChunk limits quanta of a command, so it fits the packet
+const quantumChunk = 24
+
+// SetQuantumSpace splits the key space into the number of quanta. The space can be changed only
+// when no quanta are assigned
+func (m *master) SetQuantumSpace(space uint32) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.pool == nil {
+		m.pool = quantum.Pool(space)
+		return nil
+	}
+	return m.pool.Resize(space)
+}
+
+func (m *master) quantumPool() (quantum.IPool, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.pool == nil {
+		return nil, errors.New("quantum space is not set")
+	}
+	return m.pool, nil
+}
+
+// Quanta returns quanta assigned to the worker
+func (m *master) Quanta(index uint16) []quantum.Quantum {
+	w, ok := m.Worker(index)
+	pool, err := m.quantumPool()
+	if !ok || err != nil {
+		return nil
+	}
+	return pool.Assigned(w.ID)
+}
+
+// Distribute assigns free quanta, so every worker has an even share of the space
+func (m *master) Distribute() error {
+	pool, err := m.quantumPool()
+	if err != nil {
+		return err
+	}
+	workers := m.Workers()
+	if len(workers) == 0 {
+		return nil
+	}
+	space := int(pool.Size())
+	for i, w := range workers {
+		share := space / len(workers)
+		if i < space%len(workers) {
+			share++
+		}
+		need := share - len(pool.Assigned(w.ID))
+		if need <= 0 {
+			continue
+		}
+		if err = m.assignQuanta(w, pool.Assign(w.ID, need), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignQuanta sends quanta assigned in the pool to the worker. The checkpoint keeps the state of moved quanta
+func (m *master) assignQuanta(w Worker, quanta []quantum.Quantum, checkpoint uint64) error {
+	for len(quanta) > 0 {
+		n := len(quanta)
+		if n > quantumChunk {
+			n = quantumChunk
+		}
+		cmd := assignQuantumCmd{ID: m.nextHandshake(), Checkpoint: checkpoint, Quantum: quanta[:n]}
+		if err := m.Send(w.ClusterIndex, cmd); err != nil {
+			return err
+		}
+		quanta = quanta[n:]
+	}
+	return nil
+}
+
+func (m *master) nextHandshake() string {
+	return strconv.FormatUint(atomic.AddUint64(&m.handshakeId, 1), 10)
+}
+
+// Move asks the owner to release the quantum and assigns it to the worker, once the owner has saved
+// or closed windows of the quantum. The worker pauses keys of the quantum meanwhile. Free quanta are assigned at once
+func (m *master) Move(q quantum.Quantum, to uint16) error {
+	pool, err := m.quantumPool()
+	if err != nil {
+		return err
+	}
+	target, ok := m.Worker(to)
+	if !ok {
+		return fmt.Errorf("unknown worker %d", to)
+	}
+	owner, ok := pool.Owner(q.Index)
+	if !ok {
+		if err = pool.AssignQuantum(target.ID, q); err != nil {
+			return err
+		}
+		return m.assignQuanta(target, []quantum.Quantum{q}, 0)
+	}
+	if owner == target.ID {
+		return nil
+	}
+
+	if err = m.Send(to, prepareQuantumCmd{ID: m.nextHandshake(), Quantum: []quantum.Quantum{q}}); err != nil {
+		return err
+	}
+	m.Lock()
+	m.moves[q.Index] = target.ID
+	ownerIndex, ok := m.byID[owner]
+	m.Unlock()
+	if !ok {
+		return fmt.Errorf("owner of quantum %d is not connected", q.Index)
+	}
+	return m.Send(ownerIndex, releaseQuantumCmd{ID: m.nextHandshake(), Quantum: []quantum.Quantum{q}})
+}
+
+// Sync asks the worker to report its quanta
+func (m *master) Sync(index uint16) error {
+	pool, err := m.quantumPool()
+	if err != nil {
+		return err
+	}
+	return m.Send(index, syncStatusCmd{ID: m.nextHandshake(), Space: pool.Size()})
+}
+
+func (m *master) assignQuantumResponseHandler(w Worker, cmd *assignQuantumResponseCmd) {
+	if !cmd.OK {
+		m.logger.Warn("quanta are not accepted", zap.Uint16("worker", w.ClusterIndex), zap.String("handshake", cmd.ID))
+	}
+}
+
+// releasingQuantumHandler frees the quantum released by the worker and assigns it to the target of the move
+func (m *master) releasingQuantumHandler(w Worker, cmd *releasingQuantumCmd) error {
+	pool, err := m.quantumPool()
+	if err != nil {
+		return err
+	}
+	err = pool.Release(w.ID, cmd.Quantum)
+	if sErr := m.Send(w.ClusterIndex, releasingQuantumResponseCmd{ID: cmd.ID, OK: err == nil}); sErr != nil {
+		return sErr
+	}
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	targetId, ok := m.moves[cmd.Quantum.Index]
+	delete(m.moves, cmd.Quantum.Index)
+	targetIndex, connected := m.byID[targetId]
+	m.Unlock()
+	if ok && connected {
+		target, _ := m.Worker(targetIndex)
+		if err = pool.AssignQuantum(target.ID, cmd.Quantum); err != nil {
+			return err
+		}
+		err = m.assignQuanta(target, []quantum.Quantum{cmd.Quantum}, cmd.Checkpoint)
+	}
+	if rErr := m.rebalanceMoved(); rErr != nil && err == nil {
+		err = rErr
+	}
+	return err
+}
+
+func (m *master) syncStatusResponseHandler(w Worker, cmd *syncStatusResponseCmd) {
+	m.Lock()
+	defer m.Unlock()
+	if worker, ok := m.workers[w.ClusterIndex]; ok {
+		worker.QuantumStatus = cmd.Quantum
+	}
+}
+
+// owner returns the worker of the certificate
+func (m *master) owner(id crypto.Certificate) (Worker, bool) {
+	m.RLock()
+	index, ok := m.byID[id]
+	m.RUnlock()
+	if !ok {
+		return Worker{}, false
+	}
+	return m.Worker(index)
+}
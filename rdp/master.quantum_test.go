@@ -0,0 +1,67 @@
+package rdp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantumHandshake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	assert.Error(t, m.Distribute(), "space is not set")
+	assert.NoError(t, m.SetQuantumSpace(8))
+
+	c1 := connect(t, ctx, m)
+	c2 := connect(t, ctx, m)
+	w1, _ := m.owner(c1.ID())
+	w2, _ := m.owner(c2.ID())
+	assert.Eventually(t, func() bool {
+		return c1.ClusterIndex() != 0 && c2.ClusterIndex() != 0
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, m.Distribute())
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 4 && len(c2.Quanta().Quanta()) == 4
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, m.Quanta(w1.ClusterIndex), c1.Quanta().Quanta())
+	assert.Equal(t, m.Quanta(w2.ClusterIndex), c2.Quanta().Quanta())
+	assert.Error(t, m.SetQuantumSpace(16), "quanta are assigned")
+
+	// the quantum with an open window moves after the window is closed
+	q := c1.Quanta().Quanta()[0]
+	c1.Quanta().Hold(q)
+	assert.NoError(t, m.Move(q, w2.ClusterIndex))
+	assert.NoError(t, m.Sync(w1.ClusterIndex))
+	assert.Eventually(t, func() bool {
+		w, _ := m.Worker(w1.ClusterIndex)
+		return w.QuantumStatus[q.Index] == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Len(t, m.Quanta(w1.ClusterIndex), 4)
+
+	c1.Quanta().Done(q)
+	assert.Eventually(t, func() bool {
+		return len(c2.Quanta().Quanta()) == 5
+	}, time.Second, 5*time.Millisecond)
+	assert.Len(t, c1.Quanta().Quanta(), 3)
+	assert.Len(t, m.Quanta(w1.ClusterIndex), 3)
+	assert.Contains(t, m.Quanta(w2.ClusterIndex), q)
+
+	_, owned := c2.Quanta().Owns(keyOf(t, q))
+	assert.True(t, owned)
+}
+
+// keyOf finds a key of the quantum
+func keyOf(t *testing.T, q quantum.Quantum) int {
+	for key := 0; ; key++ {
+		kq, err := quantum.Of(key, q.Space)
+		assert.NoError(t, err)
+		if kq == q {
+			return key
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package rdp
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/discretemind/glink/stream/quantum"
+	"go.uber.org/zap"
+)
+
+// DefaultOverloadedWeight is the share of an overloaded worker relative to other workers
+const DefaultOverloadedWeight = 0.5
+
+// RebalanceConfig tells when the master moves quanta between workers. Overload thresholds are compared with
+// MetricsCmd reported by workers. Zero thresholds are disabled
+type RebalanceConfig struct {
+	// Auto rebalances when a worker connects, is removed or its overload changes
+	Auto bool
+	// CpuOverload is CPU usage in hundredths of a percent, e.g. 9000 for 90%
+	CpuOverload uint32
+	// MemOverload is used memory in percent of the total
+	MemOverload uint32
+	// OverloadedWeight is DefaultOverloadedWeight when it's not positive
+	OverloadedWeight float64
+}
+
+func (c RebalanceConfig) overloaded(cmd MetricsCmd) bool {
+	if c.CpuOverload > 0 && cmd.CpuUsage >= c.CpuOverload {
+		return true
+	}
+	return c.MemOverload > 0 && cmd.MemTotal > 0 && uint64(cmd.MemUsed)*100 >= uint64(c.MemOverload)*uint64(cmd.MemTotal)
+}
+
+func (c RebalanceConfig) weight(w Worker) float64 {
+	if !w.Overloaded {
+		return 1
+	}
+	if c.OverloadedWeight <= 0 {
+		return DefaultOverloadedWeight
+	}
+	return c.OverloadedWeight
+}
+
+func (m *master) SetRebalance(cfg RebalanceConfig) {
+	m.Lock()
+	defer m.Unlock()
+	m.rebalanceCfg = cfg
+}
+
+// Rebalance gives workers shares of the key space by their weights. Free quanta are assigned at once and
+// quanta above the share of their owner are moved with their keyed state. A rebalance requested while
+// quanta are moving runs once they are moved
+func (m *master) Rebalance() error {
+	pool, err := m.quantumPool()
+	if err != nil {
+		return err
+	}
+	m.rebalancing.Lock()
+	defer m.rebalancing.Unlock()
+
+	m.Lock()
+	if len(m.moves) > 0 {
+		m.rebalancePending = true
+		m.Unlock()
+		return nil
+	}
+	m.rebalancePending = false
+	cfg := m.rebalanceCfg
+	m.Unlock()
+
+	workers := m.Workers()
+	if len(workers) == 0 {
+		return nil
+	}
+	shares := targetShares(workers, int(pool.Size()), cfg)
+
+	var surplus []quantum.Quantum
+	for i, w := range workers {
+		assigned := pool.Assigned(w.ID)
+		if extra := len(assigned) - shares[i]; extra > 0 {
+			surplus = append(surplus, assigned[len(assigned)-extra:]...)
+		}
+	}
+	for i, w := range workers {
+		need := shares[i] - len(pool.Assigned(w.ID))
+		if need <= 0 {
+			continue
+		}
+		if free := pool.Assign(w.ID, need); len(free) > 0 {
+			if err = m.assignQuanta(w, free, 0); err != nil {
+				return err
+			}
+			need -= len(free)
+		}
+		for ; need > 0 && len(surplus) > 0; need-- {
+			if err = m.Move(surplus[0], w.ClusterIndex); err != nil {
+				return err
+			}
+			surplus = surplus[1:]
+		}
+	}
+	return nil
+}
+
+// targetShares splits the space by worker weights. Quanta left by rounding go to the largest remainders
+func targetShares(workers []Worker, space int, cfg RebalanceConfig) []int {
+	weights := make([]float64, len(workers))
+	total := 0.0
+	for i, w := range workers {
+		weights[i] = cfg.weight(w)
+		total += weights[i]
+	}
+	res := make([]int, len(workers))
+	remainders := make([]float64, len(workers))
+	order := make([]int, len(workers))
+	rest := space
+	for i := range workers {
+		exact := float64(space) * weights[i] / total
+		res[i] = int(exact)
+		remainders[i] = exact - float64(res[i])
+		rest -= res[i]
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for i := 0; i < rest; i++ {
+		res[order[i%len(order)]]++
+	}
+	return res
+}
+
+// autoRebalance rebalances, when it's enabled and the quantum space is set
+func (m *master) autoRebalance() error {
+	m.RLock()
+	run := m.rebalanceCfg.Auto && m.pool != nil
+	m.RUnlock()
+	if !run {
+		return nil
+	}
+	return m.Rebalance()
+}
+
+// rebalanceMoved runs the rebalance postponed by moves, once all quanta are moved
+func (m *master) rebalanceMoved() error {
+	m.RLock()
+	run := len(m.moves) == 0 && (m.rebalancePending || m.rebalanceCfg.Auto)
+	m.RUnlock()
+	if !run {
+		return nil
+	}
+	return m.Rebalance()
+}
+
+// Remove forgets the worker, which has left the cluster. Its quanta are freed without their state
+// and given to other workers by the automatic rebalance
+func (m *master) Remove(index uint16) error {
+	m.Lock()
+	w, ok := m.workers[index]
+	if !ok {
+		m.Unlock()
+		return fmt.Errorf("unknown worker %d", index)
+	}
+	delete(m.workers, index)
+	delete(m.byID, w.ID)
+	delete(m.links, index)
+	delete(m.sessions, index)
+	delete(m.handshakes, index)
+	pool := m.pool
+	// moves from the worker are never completed, and ones to the worker leave their quanta free
+	for q, target := range m.moves {
+		owner, owned := pool.Owner(q)
+		if target == w.ID || owned && owner == w.ID {
+			delete(m.moves, q)
+		}
+	}
+	m.Unlock()
+
+	if pool != nil {
+		pool.ReleaseAll(w.ID)
+	}
+	m.logger.Info("Worker removed", zap.String("id", w.ID.String()), zap.Uint16("index", index))
+	return m.rebalanceMoved()
+}
@@ -0,0 +1,132 @@
+package rdp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetShares(t *testing.T) {
+	workers := []Worker{{}, {Overloaded: true}, {}}
+	assert.Equal(t, []int{4, 2, 4}, targetShares(workers, 10, RebalanceConfig{}))
+	assert.Equal(t, []int{4}, targetShares(workers[1:2], 4, RebalanceConfig{}), "single worker")
+	assert.Equal(t, []int{5, 0, 5}, targetShares(workers, 10, RebalanceConfig{OverloadedWeight: 0.01}))
+	assert.Equal(t, []int{4, 3, 3}, targetShares([]Worker{{}, {}, {}}, 10, RebalanceConfig{}))
+}
+
+func TestRebalance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	assert.NoError(t, m.SetQuantumSpace(12))
+	m.SetRebalance(RebalanceConfig{Auto: true, CpuOverload: 9000})
+
+	var lock sync.Mutex
+	restored := make(map[uint32]uint64)
+	c1 := connect(t, ctx, m, func(c *client) {
+		c.OnReleaseQuanta(func(quanta []quantum.Quantum) (uint64, error) {
+			return 7, nil
+		})
+	})
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 12
+	}, time.Second, 5*time.Millisecond)
+
+	// the joined worker gets half of the quanta with the checkpoint of their state
+	c2 := connect(t, ctx, m, func(c *client) {
+		c.OnAssignQuanta(func(quanta []quantum.Quantum, checkpoint uint64) error {
+			lock.Lock()
+			defer lock.Unlock()
+			for _, q := range quanta {
+				restored[q.Index] = checkpoint
+			}
+			return nil
+		})
+	})
+	w1, _ := m.owner(c1.ID())
+	w2, _ := m.owner(c2.ID())
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 6 && len(c2.Quanta().Quanta()) == 6
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, m.Quanta(w2.ClusterIndex), c2.Quanta().Quanta())
+	lock.Lock()
+	assert.Len(t, restored, 6)
+	for _, checkpoint := range restored {
+		assert.Equal(t, uint64(7), checkpoint)
+	}
+	lock.Unlock()
+
+	// the overloaded worker gets a half share
+	assert.NoError(t, c2.send(MetricsCmd{CpuUsage: 9500}))
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 8 && len(c2.Quanta().Quanta()) == 4
+	}, time.Second, 5*time.Millisecond)
+	w, _ := m.Worker(w2.ClusterIndex)
+	assert.True(t, w.Overloaded)
+
+	// quanta of the removed worker go to the rest
+	assert.NoError(t, m.Remove(w2.ClusterIndex))
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 12
+	}, time.Second, 5*time.Millisecond)
+	assert.Len(t, m.Quanta(w1.ClusterIndex), 12)
+	assert.Error(t, m.Remove(w2.ClusterIndex))
+}
+
+func TestReleaseRetriesUnsavedState(t *testing.T) {
+	releaseRetryDelay = 50 * time.Millisecond
+	defer func() {
+		releaseRetryDelay = time.Second
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	assert.NoError(t, m.SetQuantumSpace(2))
+	m.SetRebalance(RebalanceConfig{Auto: true})
+
+	var lock sync.Mutex
+	attempts := 0
+	c1 := connect(t, ctx, m, func(c *client) {
+		c.OnReleaseQuanta(func(quanta []quantum.Quantum) (uint64, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			attempts++
+			if attempts == 1 {
+				return 0, errors.New("disk is full")
+			}
+			return 7, nil
+		})
+	})
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// the quantum isn't moved, until its state is saved
+	restored := make(chan uint64, 1)
+	c2 := connect(t, ctx, m, func(c *client) {
+		c.OnAssignQuanta(func(quanta []quantum.Quantum, checkpoint uint64) error {
+			restored <- checkpoint
+			return nil
+		})
+	})
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return attempts == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Len(t, c1.Quanta().Quanta(), 2)
+	select {
+	case checkpoint := <-restored:
+		assert.Equal(t, uint64(7), checkpoint)
+	case <-time.After(time.Second):
+		t.Fatal("the quantum is not moved")
+	}
+	assert.Eventually(t, func() bool {
+		return len(c1.Quanta().Quanta()) == 1 && len(c2.Quanta().Quanta()) == 1
+	}, time.Second, 5*time.Millisecond)
+}
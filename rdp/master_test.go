@@ -0,0 +1,187 @@
+package rdp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/discretemind/glink/utils/metrics"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func listen(t *testing.T, ctx context.Context) *master {
+	m := Master(crypto.GeneratePrivateKey(), NewVersion(1, 0, 0), zap.NewNop())
+	assert.NoError(t, m.Listen("127.0.0.1:0"))
+	go func() {
+		assert.NoError(t, m.Serve(ctx))
+	}()
+	return m
+}
+
+// connect runs a client. Setup functions configure it before the connection
+func connect(t *testing.T, ctx context.Context, m *master, setup ...func(c *client)) *client {
+	c := Client(NewVersion(1, 0, 0), zap.NewNop())
+	for _, f := range setup {
+		f(c)
+	}
+	go func() {
+		_ = c.Connect(ctx, m.Addr().String(), m.ClusterID())
+	}()
+	assert.Eventually(t, func() bool {
+		_, ok := m.owner(c.ID())
+		return ok
+	}, time.Second, 5*time.Millisecond)
+	return c
+}
+
+func TestMasterAcceptsWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+
+	c1 := connect(t, ctx, m)
+	c2 := connect(t, ctx, m)
+	workers := m.Workers()
+	assert.Len(t, workers, 2)
+	assert.NotEqual(t, workers[0].ClusterIndex, workers[1].ClusterIndex)
+
+	w1, _ := m.owner(c1.ID())
+	assert.Eventually(t, func() bool {
+		return c1.ClusterIndex() == w1.ClusterIndex
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, c1.key.Public(), w1.Key)
+	assert.Equal(t, NewVersion(1, 0, 0), w1.Version)
+
+	// metrics of the worker are decrypted and tracked by the master
+	assert.NoError(t, c1.send(MetricsCmd{CpuUsage: 4200, MemTotal: 2048}))
+	assert.Eventually(t, func() bool {
+		w, _ := m.Worker(w1.ClusterIndex)
+		return w.Metrics.CpuUsage == 4200
+	}, time.Second, 5*time.Millisecond)
+	buf := bytes.Buffer{}
+	assert.NoError(t, metrics.Get().Write(&buf))
+	assert.Contains(t, buf.String(), `glink_host_cpu_usage_percent{node="`+c1.ID().String()+`"} 42`)
+
+	w2, _ := m.owner(c2.ID())
+	assert.Equal(t, uint64(0), uint64(w2.Metrics.CpuUsage))
+}
+
+func TestMasterSendsCommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+
+	started := make(chan string, 1)
+	c := Client(NewVersion(1, 0, 0), zap.NewNop())
+	c.handlers = make(map[uint16]handlerType)
+	c.registerHandler(func(cmd *StartCmd) {
+		started <- string(cmd.Config)
+	})
+	go func() {
+		_ = c.Connect(ctx, m.Addr().String(), m.ClusterID())
+	}()
+	assert.Eventually(t, func() bool {
+		w, ok := m.owner(c.ID())
+		return ok && c.ClusterIndex() == w.ClusterIndex
+	}, time.Second, 5*time.Millisecond)
+
+	w, _ := m.owner(c.ID())
+	assert.NoError(t, m.Start(w.ClusterIndex, []byte(`{"name":"job"}`)))
+	select {
+	case cfg := <-started:
+		assert.Equal(t, `{"name":"job"}`, cfg)
+	case <-time.After(time.Second):
+		t.Fatal("start command is not received")
+	}
+	assert.Error(t, m.Stop(999))
+}
+
+func TestMasterRejectsConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	other := Master(crypto.GeneratePrivateKey(), NewVersion(1, 0, 0), zap.NewNop())
+
+	c := Client(NewVersion(1, 0, 0), zap.NewNop())
+	connectCtx, connectCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer connectCancel()
+	_ = c.Connect(connectCtx, m.Addr().String(), other.ClusterID())
+	assert.Empty(t, m.Workers(), "connect to another cluster is ignored")
+	assert.Equal(t, uint16(0), c.ClusterIndex())
+
+	assert.Error(t, m.handlePacket([]byte{1, 1, 0, 0, 0, 9}, nil))
+}
+
+func TestMasterRejectsReplayedConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	local, _ := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	conn, err := net.ListenUDP("udp", local)
+	assert.NoError(t, err)
+	defer conn.Close()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	c := Client(NewVersion(1, 0, 0), zap.NewNop())
+	c.clusterId = m.key.Certificate()
+	first, _ := c.newConnect()
+	older, _ := c.newConnect()
+	second, _ := c.newConnect()
+	assert.NoError(t, m.handlePacket(first.packet[:], addr))
+	assert.NoError(t, m.handlePacket(first.packet[:], addr), "a repeated connect gets the same accept")
+	assert.NoError(t, m.handlePacket(second.packet[:], addr))
+	assert.Error(t, m.handlePacket(first.packet[:], addr), "the nonce is used")
+	assert.Error(t, m.handlePacket(older.packet[:], addr), "the connect is older than the session")
+
+	connectMaxAge = time.Nanosecond
+	assert.Error(t, m.handlePacket(older.packet[:], addr))
+	connectMaxAge = time.Minute
+
+	// the client accepts the answer to its pending connect only
+	var accepts [][]byte
+	for i := 0; i < 3; i++ {
+		packet := Packet{}
+		_, err = conn.Read(packet[:])
+		assert.NoError(t, err)
+		accepts = append(accepts, packet[:])
+	}
+	assert.Equal(t, accepts[0], accepts[1])
+	c.connecting = second
+	assert.Error(t, c.handleAccept(accepts[0]))
+	assert.Equal(t, uint16(0), c.ClusterIndex())
+	assert.NoError(t, c.handleAccept(accepts[2]))
+	w, _ := m.owner(c.ID())
+	assert.Equal(t, w.ClusterIndex, c.ClusterIndex())
+}
+
+func TestMasterNegotiatesProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := Master(crypto.GeneratePrivateKey(), NewVersion(1, 2, 5), zap.NewNop())
+	assert.NoError(t, m.Listen("127.0.0.1:0"))
+	go func() {
+		assert.NoError(t, m.Serve(ctx))
+	}()
+
+	// the older worker joins with its own protocol
+	c := connect(t, ctx, m)
+	w, _ := m.owner(c.ID())
+	assert.Equal(t, NewVersion(1, 0, 0), w.Protocol)
+	assert.Eventually(t, func() bool {
+		return c.Protocol() == NewVersion(1, 0, 0)
+	}, time.Second, 5*time.Millisecond)
+
+	// the worker of another major version is rejected
+	c2 := Client(NewVersion(2, 0, 0), zap.NewNop())
+	err := c2.Connect(ctx, m.Addr().String(), m.ClusterID())
+	var incompatible *IncompatibleVersionError
+	assert.True(t, errors.As(err, &incompatible))
+	assert.Equal(t, NewVersion(1, 2, 5), incompatible.Master)
+	_, ok := m.owner(c2.ID())
+	assert.False(t, ok)
+}
@@ -0,0 +1,426 @@
+package rdp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/utils/crypto"
+)
+
+// Frames carry fragments of protected commands between the master and workers:
+//
+//	kind (1) | channel (1) | sequence (4) | message (4) | fragment (2) | fragments (2) | payload length (2) | payload | mac (16)
+//
+// Frames of workers are prefixed with their cluster index. Acks carry the sequence of the frame only.
+// The mac authenticates the frame by the session, so forged frames and acks can't take sequence numbers
+const (
+	frameData       uint8 = 0xD1
+	frameAck        uint8 = 0xA1
+	frameHeaderSize       = 16
+	frameMacSize          = 16
+)
+
+// MaxMessageSize limits commands, which are split into fragments of a packet size
+const MaxMessageSize = 1 << 20
+
+// Channel of protected commands. Commands of the control channel are acknowledged, retransmitted and delivered
+// in order. Telemetry is sent once and commands older than the last delivered one are dropped
+type Channel uint8
+
+const (
+	ControlChannel Channel = iota
+	TelemetryChannel
+	channelCount
+)
+
+func (c Channel) reliable() bool {
+	return c == ControlChannel
+}
+
+// channelOf returns the channel of the command
+func channelOf(cmd interface{}) Channel {
+	switch cmd.(type) {
+	case MetricsCmd, *MetricsCmd, heartbeatCmd, *heartbeatCmd:
+		return TelemetryChannel
+	}
+	return ControlChannel
+}
+
+var (
+	// retransmitTimeout is doubled by every retransmit up to maxRetransmitTimeout
+	retransmitTimeout    = 100 * time.Millisecond
+	maxRetransmitTimeout = 5 * time.Second
+	// retransmitPeriod is how often links check frames due to retransmit
+	retransmitPeriod = 20 * time.Millisecond
+	maxRetransmits   = 10
+	// windowSize limits frames sent without acknowledgement as well as frames received ahead of the next one.
+	// Later frames wait for acks
+	windowSize = 256
+	// reassemblyTimeout drops messages, which fragments haven't arrived in time
+	reassemblyTimeout = 30 * time.Second
+)
+
+type frame struct {
+	kind     uint8
+	channel  Channel
+	seq      uint32
+	message  uint32
+	fragment uint16
+	count    uint16
+	payload  []byte
+}
+
+func (f frame) encode() []byte {
+	res := make([]byte, frameHeaderSize+len(f.payload))
+	res[0] = f.kind
+	res[1] = uint8(f.channel)
+	binary.BigEndian.PutUint32(res[2:6], f.seq)
+	binary.BigEndian.PutUint32(res[6:10], f.message)
+	binary.BigEndian.PutUint16(res[10:12], f.fragment)
+	binary.BigEndian.PutUint16(res[12:14], f.count)
+	binary.BigEndian.PutUint16(res[14:16], uint16(len(f.payload)))
+	copy(res[frameHeaderSize:], f.payload)
+	return res
+}
+
+func decodeFrame(data []byte) (res frame, err error) {
+	if len(data) < frameHeaderSize {
+		return res, errors.New("frame is too short")
+	}
+	res.kind = data[0]
+	res.channel = Channel(data[1])
+	res.seq = binary.BigEndian.Uint32(data[2:6])
+	res.message = binary.BigEndian.Uint32(data[6:10])
+	res.fragment = binary.BigEndian.Uint16(data[10:12])
+	res.count = binary.BigEndian.Uint16(data[12:14])
+	size := int(binary.BigEndian.Uint16(data[14:16]))
+	if res.kind != frameData && res.kind != frameAck {
+		return res, fmt.Errorf("unknown frame kind %d", res.kind)
+	}
+	if res.channel >= channelCount {
+		return res, fmt.Errorf("unknown channel %d", res.channel)
+	}
+	if res.seq == 0 || frameHeaderSize+size > len(data) {
+		return res, errors.New("malformed frame")
+	}
+	if res.kind == frameData && res.fragment >= res.count {
+		return res, fmt.Errorf("fragment %d of %d", res.fragment, res.count)
+	}
+	res.payload = data[frameHeaderSize : frameHeaderSize+size]
+	return
+}
+
+type pendingFrame struct {
+	seq      uint32
+	data     []byte
+	due      time.Time
+	timeout  time.Duration
+	attempts int
+}
+
+type sendState struct {
+	last    uint32
+	message uint32
+	pending map[uint32]*pendingFrame
+	// queue keeps frames beyond the window
+	queue []*pendingFrame
+}
+
+type partialMessage struct {
+	fragments [][]byte
+	received  int
+	started   time.Time
+}
+
+type receiveState struct {
+	next     uint32
+	buffered map[uint32]frame
+	partial  map[uint32]*partialMessage
+	// delivered is the last telemetry message
+	delivered uint32
+}
+
+// link is the delivery state of one side of a master and worker connection. It doesn't read the network:
+// the owner passes decoded frames to Receive and Ack and calls Retransmit periodically
+type link struct {
+	sync.Mutex
+	write      func(data []byte) error
+	maxPayload int
+	sending    [channelCount]sendState
+	receipts   [channelCount]receiveState
+	// macs of sent and received frames. They are set before the link is used
+	sendMac, receiveMac crypto.SessionKey
+}
+
+// newLink writes frames up to maxFrame bytes
+func newLink(maxFrame int, write func(data []byte) error) *link {
+	res := &link{
+		write:      write,
+		maxPayload: maxFrame - frameHeaderSize - frameMacSize,
+	}
+	for i := range res.sending {
+		res.sending[i].pending = make(map[uint32]*pendingFrame)
+		res.receipts[i].next = 1
+		res.receipts[i].buffered = make(map[uint32]frame)
+		res.receipts[i].partial = make(map[uint32]*partialMessage)
+	}
+	return res
+}
+
+// authenticated sets keys of frame macs derived from the session keys
+func (l *link) authenticated(send, receive crypto.SessionKey) *link {
+	l.sendMac, l.receiveMac = send.Derive("frames"), receive.Derive("frames")
+	return l
+}
+
+func frameMac(key crypto.SessionKey, data []byte) []byte {
+	h := hmac.New(sha256.New, key[:])
+	h.Write(data)
+	return h.Sum(nil)[:frameMacSize]
+}
+
+// seal appends the mac to the encoded frame
+func (l *link) seal(data []byte) []byte {
+	return append(data, frameMac(l.sendMac, data)...)
+}
+
+// decode decodes the frame and checks its mac. Data may be padded by the packet
+func (l *link) decode(data []byte) (res frame, err error) {
+	if res, err = decodeFrame(data); err != nil {
+		return
+	}
+	end := frameHeaderSize + len(res.payload)
+	if len(data) < end+frameMacSize || !hmac.Equal(frameMac(l.receiveMac, data[:end]), data[end:end+frameMacSize]) {
+		return res, errors.New("frame isn't authenticated")
+	}
+	return
+}
+
+// Send splits the message into frames. Frames of the control channel are kept until acknowledged,
+// so they are retransmitted even if the write fails
+func (l *link) Send(channel Channel, message []byte) (err error) {
+	if len(message) > MaxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds %d bytes", len(message), MaxMessageSize)
+	}
+	count := (len(message) + l.maxPayload - 1) / l.maxPayload
+	if count == 0 {
+		count = 1
+	}
+
+	var writes [][]byte
+	now := time.Now()
+	l.Lock()
+	s := &l.sending[channel]
+	s.message++
+	for i := 0; i < count; i++ {
+		end := (i + 1) * l.maxPayload
+		if end > len(message) {
+			end = len(message)
+		}
+		s.last++
+		data := l.seal(frame{
+			kind:     frameData,
+			channel:  channel,
+			seq:      s.last,
+			message:  s.message,
+			fragment: uint16(i),
+			count:    uint16(count),
+			payload:  message[i*l.maxPayload : end],
+		}.encode())
+		if !channel.reliable() {
+			writes = append(writes, data)
+			continue
+		}
+		p := &pendingFrame{seq: s.last, data: data}
+		if len(s.pending) >= windowSize {
+			s.queue = append(s.queue, p)
+			continue
+		}
+		s.start(p, now)
+		writes = append(writes, data)
+	}
+	l.Unlock()
+
+	for _, data := range writes {
+		if wErr := l.write(data); wErr != nil && err == nil {
+			err = wErr
+		}
+	}
+	return
+}
+
+func (s *sendState) start(p *pendingFrame, now time.Time) {
+	p.timeout = retransmitTimeout
+	p.due = now.Add(p.timeout)
+	s.pending[p.seq] = p
+}
+
+// Ack removes the acknowledged frame from retransmits and sends queued frames
+func (l *link) Ack(f frame) {
+	var writes [][]byte
+	l.Lock()
+	s := &l.sending[f.channel]
+	delete(s.pending, f.seq)
+	for len(s.queue) > 0 && len(s.pending) < windowSize {
+		s.start(s.queue[0], time.Now())
+		writes = append(writes, s.queue[0].data)
+		s.queue = s.queue[1:]
+	}
+	l.Unlock()
+	for _, data := range writes {
+		_ = l.write(data)
+	}
+}
+
+// Receive accepts the data frame and returns messages ready for delivery in order.
+// Duplicates are acknowledged again, since the previous ack may be lost
+func (l *link) Receive(f frame) (res [][]byte, err error) {
+	now := time.Now()
+	l.Lock()
+	r := &l.receipts[f.channel]
+	if !f.channel.reliable() {
+		if f.message > r.delivered {
+			if res, err = l.assemble(r, f, now); len(res) > 0 {
+				r.delivered = f.message
+				for id := range r.partial {
+					if id < f.message {
+						delete(r.partial, id)
+					}
+				}
+			}
+		}
+		l.Unlock()
+		return
+	}
+	if f.seq >= r.next+uint32(windowSize) {
+		l.Unlock()
+		return nil, fmt.Errorf("frame %d is ahead of the receive window", f.seq)
+	}
+	if _, ok := r.buffered[f.seq]; !ok && f.seq >= r.next {
+		r.buffered[f.seq] = f
+		for {
+			next, ok := r.buffered[r.next]
+			if !ok {
+				break
+			}
+			delete(r.buffered, r.next)
+			r.next++
+			messages, aErr := l.assemble(r, next, now)
+			if aErr != nil && err == nil {
+				err = aErr
+			}
+			res = append(res, messages...)
+		}
+	}
+	l.Unlock()
+	if wErr := l.write(l.seal(frame{kind: frameAck, channel: f.channel, seq: f.seq}.encode())); wErr != nil && err == nil {
+		err = wErr
+	}
+	return
+}
+
+// assemble is called locked and returns the message once all its fragments are received
+func (l *link) assemble(r *receiveState, f frame, now time.Time) ([][]byte, error) {
+	if f.count == 1 {
+		return [][]byte{append([]byte(nil), f.payload...)}, nil
+	}
+	if int(f.count)*l.maxPayload > MaxMessageSize+l.maxPayload {
+		return nil, fmt.Errorf("message %d of %d fragments exceeds %d bytes", f.message, f.count, MaxMessageSize)
+	}
+	p, ok := r.partial[f.message]
+	if !ok {
+		p = &partialMessage{fragments: make([][]byte, f.count), started: now}
+		r.partial[f.message] = p
+	}
+	if len(p.fragments) != int(f.count) {
+		delete(r.partial, f.message)
+		return nil, fmt.Errorf("fragments of message %d don't match", f.message)
+	}
+	if p.fragments[f.fragment] != nil {
+		return nil, nil
+	}
+	p.fragments[f.fragment] = append([]byte(nil), f.payload...)
+	if p.received++; p.received < len(p.fragments) {
+		return nil, nil
+	}
+	delete(r.partial, f.message)
+	size := 0
+	for _, fragment := range p.fragments {
+		size += len(fragment)
+	}
+	message := make([]byte, 0, size)
+	for _, fragment := range p.fragments {
+		message = append(message, fragment...)
+	}
+	return [][]byte{message}, nil
+}
+
+// Retransmit resends frames not acknowledged in time with exponential backoff and drops messages,
+// which haven't been reassembled within reassemblyTimeout. Frames are given up after maxRetransmits
+// and their number is returned. The receiver never delivers frames after a given up one, so the owner
+// must replace the link then
+func (l *link) Retransmit(now time.Time) (lost int) {
+	var resend [][]byte
+	l.Lock()
+	for i := range l.sending {
+		s := &l.sending[i]
+		for seq, p := range s.pending {
+			if now.Before(p.due) {
+				continue
+			}
+			if p.attempts >= maxRetransmits {
+				delete(s.pending, seq)
+				lost++
+				continue
+			}
+			p.attempts++
+			if p.timeout *= 2; p.timeout > maxRetransmitTimeout {
+				p.timeout = maxRetransmitTimeout
+			}
+			p.due = now.Add(p.timeout)
+			resend = append(resend, p.data)
+		}
+		for len(s.queue) > 0 && len(s.pending) < windowSize {
+			s.start(s.queue[0], now)
+			resend = append(resend, s.queue[0].data)
+			s.queue = s.queue[1:]
+		}
+	}
+	for i := range l.receipts {
+		for id, p := range l.receipts[i].partial {
+			if now.Sub(p.started) > reassemblyTimeout {
+				delete(l.receipts[i].partial, id)
+			}
+		}
+	}
+	l.Unlock()
+	for _, data := range resend {
+		_ = l.write(data)
+	}
+	return
+}
+
+// Pending is the number of frames waiting for acknowledgement
+func (l *link) Pending() (res int) {
+	l.Lock()
+	defer l.Unlock()
+	for i := range l.sending {
+		res += len(l.sending[i].pending) + len(l.sending[i].queue)
+	}
+	return
+}
+
+// partials is the number of messages being reassembled
+func (l *link) partials() (res int) {
+	l.Lock()
+	defer l.Unlock()
+	for i := range l.receipts {
+		res += len(l.receipts[i].partial)
+	}
+	return
+}
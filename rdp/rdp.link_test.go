@@ -0,0 +1,338 @@
+package rdp
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// pipe connects two links in memory. Frames are queued, so the test decides what's delivered
+type pipe struct {
+	sync.Mutex
+	frames [][]byte
+}
+
+func (p *pipe) write(data []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	p.frames = append(p.frames, data)
+	return nil
+}
+
+func (p *pipe) take() (res []frame) {
+	p.Lock()
+	defer p.Unlock()
+	for _, data := range p.frames {
+		f, _ := decodeFrame(data)
+		res = append(res, f)
+	}
+	p.frames = nil
+	return
+}
+
+func TestLinkOrdersFrames(t *testing.T) {
+	out, in := &pipe{}, &pipe{}
+	sender, receiver := newLink(len(Packet{}), out.write), newLink(len(Packet{}), in.write)
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, sender.Send(ControlChannel, []byte{byte(i)}))
+	}
+	frames := out.take()
+	assert.Len(t, frames, 3)
+
+	var delivered [][]byte
+	receive := func(f frame) {
+		messages, err := receiver.Receive(f)
+		assert.NoError(t, err)
+		delivered = append(delivered, messages...)
+	}
+	receive(frames[2])
+	receive(frames[0])
+	receive(frames[0])
+	assert.Equal(t, [][]byte{{1}}, delivered, "the third frame waits for the second one")
+	receive(frames[1])
+	assert.Equal(t, [][]byte{{1}, {2}, {3}}, delivered)
+
+	// acks of duplicates are sent again
+	acks := in.take()
+	assert.Len(t, acks, 4)
+	for _, ack := range acks {
+		sender.Ack(ack)
+	}
+	assert.Equal(t, 0, sender.Pending())
+}
+
+func TestLinkRetransmits(t *testing.T) {
+	out := &pipe{}
+	sender := newLink(len(Packet{}), out.write)
+	assert.NoError(t, sender.Send(ControlChannel, []byte{1}))
+	assert.NoError(t, sender.Send(TelemetryChannel, []byte{2}))
+	assert.Len(t, out.take(), 2)
+	assert.Equal(t, 1, sender.Pending(), "telemetry isn't acknowledged")
+
+	now := time.Now()
+	assert.Equal(t, 0, sender.Retransmit(now))
+	assert.Empty(t, out.take())
+
+	// the timeout doubles with every retransmit
+	timeout := retransmitTimeout
+	for i := 0; i < maxRetransmits; i++ {
+		now = now.Add(timeout)
+		assert.Equal(t, 0, sender.Retransmit(now))
+		assert.Len(t, out.take(), 1)
+		if timeout *= 2; timeout > maxRetransmitTimeout {
+			timeout = maxRetransmitTimeout
+		}
+	}
+	assert.Equal(t, 1, sender.Retransmit(now.Add(timeout)))
+	assert.Equal(t, 0, sender.Pending())
+}
+
+func TestLinkStartsQueuedFrames(t *testing.T) {
+	windowSize = 1
+	defer func() {
+		windowSize = 256
+	}()
+	out := &pipe{}
+	sender := newLink(len(Packet{}), out.write)
+	assert.NoError(t, sender.Send(ControlChannel, []byte{1}))
+	assert.NoError(t, sender.Send(ControlChannel, []byte{2}))
+	assert.Len(t, out.take(), 1)
+
+	// the queued frame is sent, once the pending one is given up
+	giveUp(sender)
+	assert.Equal(t, 1, sender.Retransmit(time.Now()))
+	frames := out.take()
+	assert.Len(t, frames, 1)
+	assert.Equal(t, []byte{2}, frames[0].payload)
+	assert.Equal(t, 1, sender.Pending())
+}
+
+// giveUp makes pending control frames of the link due and out of retransmits
+func giveUp(l *link) {
+	l.Lock()
+	defer l.Unlock()
+	for _, p := range l.sending[ControlChannel].pending {
+		p.attempts = maxRetransmits
+		p.due = time.Time{}
+	}
+}
+
+func TestLinkDropsStaleTelemetry(t *testing.T) {
+	out := &pipe{}
+	sender, receiver := newLink(len(Packet{}), out.write), newLink(len(Packet{}), (&pipe{}).write)
+	assert.NoError(t, sender.Send(TelemetryChannel, []byte{1}))
+	assert.NoError(t, sender.Send(TelemetryChannel, []byte{2}))
+	frames := out.take()
+
+	messages, err := receiver.Receive(frames[1])
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{2}}, messages)
+	messages, err = receiver.Receive(frames[0])
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestLinkFragments(t *testing.T) {
+	out, in := &pipe{}, &pipe{}
+	sender, receiver := newLink(80, out.write), newLink(80, in.write)
+	message := make([]byte, 1000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	assert.NoError(t, sender.Send(ControlChannel, message))
+	frames := out.take()
+	assert.Len(t, frames, 21)
+
+	var delivered [][]byte
+	for i := len(frames) - 1; i >= 0; i-- {
+		messages, err := receiver.Receive(frames[i])
+		assert.NoError(t, err)
+		delivered = append(delivered, messages...)
+	}
+	assert.Equal(t, [][]byte{message}, delivered)
+
+	// fragments beyond the window wait for acks
+	windowSize = 8
+	defer func() {
+		windowSize = 256
+	}()
+	assert.NoError(t, sender.Send(ControlChannel, message[:500]))
+	assert.Len(t, out.take(), 0)
+	// 5 of 21 frames stay unacknowledged
+	for _, f := range frames[:16] {
+		sender.Ack(f)
+	}
+	assert.Len(t, out.take(), 3)
+	assert.Error(t, sender.Send(ControlChannel, make([]byte, MaxMessageSize+1)))
+}
+
+func TestLinkAuthenticatesFrames(t *testing.T) {
+	var out, in, forged [][]byte
+	workerKey, masterKey := crypto.SessionKey{1}, crypto.SessionKey{2}
+	sender := newLink(len(Packet{}), func(data []byte) error {
+		out = append(out, data)
+		return nil
+	}).authenticated(workerKey, masterKey)
+	receiver := newLink(len(Packet{}), func(data []byte) error {
+		in = append(in, data)
+		return nil
+	}).authenticated(masterKey, workerKey)
+	attacker := newLink(len(Packet{}), func(data []byte) error {
+		forged = append(forged, data)
+		return nil
+	}).authenticated(crypto.SessionKey{3}, masterKey)
+
+	assert.NoError(t, attacker.Send(ControlChannel, []byte("forged")))
+	_, err := receiver.decode(forged[0])
+	assert.Error(t, err)
+	assert.Empty(t, in, "forged frames aren't acknowledged")
+
+	assert.NoError(t, sender.Send(ControlChannel, []byte("command")))
+	f, err := receiver.decode(out[0])
+	assert.NoError(t, err)
+	messages, err := receiver.Receive(f)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("command")}, messages, "the sequence isn't taken by the forged frame")
+
+	// acks are authenticated as well
+	_, err = receiver.decode(in[0])
+	assert.Error(t, err)
+	ack, err := sender.decode(in[0])
+	assert.NoError(t, err)
+	sender.Ack(ack)
+	assert.Equal(t, 0, sender.Pending())
+}
+
+func TestLinkReassemblyTimeout(t *testing.T) {
+	out := &pipe{}
+	sender, receiver := newLink(80, out.write), newLink(80, (&pipe{}).write)
+	assert.NoError(t, sender.Send(TelemetryChannel, make([]byte, 100)))
+	frames := out.take()
+	assert.Len(t, frames, 3)
+
+	messages, err := receiver.Receive(frames[0])
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+	assert.Equal(t, 1, receiver.partials())
+	receiver.Retransmit(time.Now().Add(reassemblyTimeout + time.Second))
+	assert.Equal(t, 0, receiver.partials(), "the lost fragment drops the message")
+}
+
+// lossyProxy forwards packets between a client and the master, dropping and delaying some of them
+type lossyProxy struct {
+	sync.Mutex
+	conn    *net.UDPConn
+	master  *net.UDPAddr
+	client  *net.UDPAddr
+	random  *rand.Rand
+	loss    float64
+	reorder float64
+}
+
+func proxy(t *testing.T, ctx context.Context, master net.Addr, loss, reorder float64) *lossyProxy {
+	local, _ := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	conn, err := net.ListenUDP("udp", local)
+	assert.NoError(t, err)
+	p := &lossyProxy{
+		conn:    conn,
+		master:  master.(*net.UDPAddr),
+		random:  rand.New(rand.NewSource(1)),
+		loss:    loss,
+		reorder: reorder,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	go p.run()
+	return p
+}
+
+func (p *lossyProxy) run() {
+	for {
+		packet := Packet{}
+		n, from, err := p.conn.ReadFromUDP(packet[:])
+		if err != nil {
+			return
+		}
+		p.Lock()
+		to := p.master
+		if from.String() == p.master.String() {
+			to = p.client
+		} else {
+			p.client = from
+		}
+		drop := p.random.Float64() < p.loss
+		delay := time.Duration(0)
+		if p.random.Float64() < p.reorder {
+			delay = time.Duration(5+p.random.Intn(30)) * time.Millisecond
+		}
+		p.Unlock()
+		if drop || to == nil {
+			continue
+		}
+		data := packet[:n]
+		time.AfterFunc(delay, func() {
+			_, _ = p.conn.WriteToUDP(data, to)
+		})
+	}
+}
+
+func TestDeliveryOverLossyNetwork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := listen(t, ctx)
+	p := proxy(t, ctx, m.Addr(), 0.3, 0.3)
+
+	var lock sync.Mutex
+	var started, responses []string
+	m.registerHandler(func(w Worker, cmd *assignQuantumResponseCmd) {
+		lock.Lock()
+		defer lock.Unlock()
+		responses = append(responses, cmd.ID)
+	})
+	c := Client(NewVersion(1, 0, 0), zap.NewNop())
+	c.registerHandler(func(cmd *StartCmd) {
+		lock.Lock()
+		defer lock.Unlock()
+		started = append(started, strings.TrimSpace(string(cmd.Config)))
+	})
+	go func() {
+		_ = c.Connect(ctx, p.conn.LocalAddr().String(), m.ClusterID())
+	}()
+	assert.Eventually(t, func() bool {
+		w, ok := m.owner(c.ID())
+		return ok && c.ClusterIndex() == w.ClusterIndex
+	}, 5*time.Second, 5*time.Millisecond)
+	w, _ := m.owner(c.ID())
+
+	var expected []string
+	for i := 0; i < 50; i++ {
+		expected = append(expected, strconv.Itoa(i))
+		config := expected[i]
+		if i%10 == 0 {
+			// job configs are kilobytes
+			config += strings.Repeat(" ", 5000)
+		}
+		assert.NoError(t, m.Start(w.ClusterIndex, []byte(config)))
+		assert.NoError(t, c.send(assignQuantumResponseCmd{ID: expected[i], OK: true}))
+	}
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(started) == len(expected) && len(responses) == len(expected)
+	}, 10*time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, expected, started, "commands are delivered once in order")
+	assert.Equal(t, expected, responses)
+	lock.Unlock()
+}
@@ -0,0 +1,168 @@
+package rdp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/discretemind/glink/utils/encoder"
+)
+
+var (
+	// Session keys are rotated after sessionKeyLifetime or sessionKeyMessages commands of their channel
+	sessionKeyLifetime        = 10 * time.Minute
+	sessionKeyMessages uint64 = 1 << 20
+	// maxEpochSkip limits rotations passed by lost telemetry
+	maxEpochSkip uint32 = 16
+	// connectMaxAge rejects connects signed earlier, so captured connects can't be replayed. Clocks of the
+	// master and workers are expected to be synchronized within it
+	connectMaxAge = time.Minute
+)
+
+type Nonce [16]byte
+
+func newNonce() (res Nonce, err error) {
+	_, err = rand.Read(res[:])
+	return
+}
+
+// sessionKeys derives keys of the worker and the master from ephemeral keys of the handshake. Both nonces
+// salt the keys, so every session has its own keys even if an ephemeral key is reused
+func sessionKeys(ephemeral crypto.PrivateKey, peer crypto.PublicKey, connect, challenge Nonce,
+	cluster, worker crypto.Certificate) (workerKey, masterKey crypto.SessionKey, err error) {
+	salt := append(connect[:], challenge[:]...)
+	info := append([]byte("glink rdp "), cluster[:]...)
+	return crypto.SessionKeys(ephemeral, peer, salt, append(info, worker[:]...))
+}
+
+// channelKey is the key of one direction of a channel. counter is the last command sealed or opened with it
+type channelKey struct {
+	key     crypto.SessionKey
+	epoch   uint32
+	counter uint64
+	sealed  uint64
+	started time.Time
+}
+
+func newChannelKey(key crypto.SessionKey, channel Channel, now time.Time) channelKey {
+	return channelKey{key: key.Derive(fmt.Sprintf("channel %d", channel)), started: now}
+}
+
+// rotate moves the key to the next epoch, when it's expired
+func (k *channelKey) rotate(now time.Time) {
+	if k.sealed < sessionKeyMessages && now.Sub(k.started) < sessionKeyLifetime {
+		return
+	}
+	k.key = k.key.Next()
+	k.epoch++
+	k.sealed = 0
+	k.started = now
+}
+
+// session encrypts commands of a connection with keys of the handshake. Every channel has its own keys and
+// counters. Commands of a channel are delivered in order, so a counter, which isn't greater than the last
+// one, is a replay. The protocol negotiated by the handshake gates commands of later versions
+type session struct {
+	sync.Mutex
+	protocol  Version
+	sending   [channelCount]channelKey
+	receiving [channelCount]channelKey
+	// keys of the handshake, which links of the session authenticate frames with
+	sendKey, receiveKey crypto.SessionKey
+}
+
+func newSession(send, receive crypto.SessionKey, protocol Version) *session {
+	res := &session{protocol: protocol, sendKey: send, receiveKey: receive}
+	now := time.Now()
+	for i := Channel(0); i < channelCount; i++ {
+		res.sending[i] = newChannelKey(send, i, now)
+		res.receiving[i] = newChannelKey(receive, i, now)
+	}
+	return res
+}
+
+// link creates the link of the session, which authenticates frames by its keys
+func (s *session) link(maxFrame int, write func(data []byte) error) *link {
+	return newLink(maxFrame, write).authenticated(s.sendKey, s.receiveKey)
+}
+
+// associated authenticates the channel and the command id along with the payload
+func associated(channel Channel, command uint16) []byte {
+	res := []byte{byte(channel), 0, 0}
+	binary.BigEndian.PutUint16(res[1:], command)
+	return res
+}
+
+// send encrypts the command with the next counter of its channel. It's locked until the link takes
+// the command, so counters follow the order of the link
+func (s *session) send(l *link, cmd interface{}) error {
+	id, ok := ProtectedCommands.GetId(cmd)
+	if !ok {
+		return fmt.Errorf("command not registered %v", reflect.TypeOf(cmd).String())
+	}
+	if err := ProtectedCommands.Supports(id, s.protocol); err != nil {
+		return err
+	}
+	channel := channelOf(cmd)
+
+	s.Lock()
+	defer s.Unlock()
+	k := &s.sending[channel]
+	k.rotate(time.Now())
+	k.counter++
+	k.sealed++
+	payload, err := k.key.Seal(k.counter, encoder.EncodeRaw(cmd), associated(channel, id))
+	if err != nil {
+		return err
+	}
+	return l.Send(channel, encoder.EncodeRaw(&ProtectedCommand{
+		Command: id,
+		Epoch:   k.epoch,
+		Counter: k.counter,
+		Payload: payload,
+	}))
+}
+
+// open decrypts the command received by the channel. Keys of later epochs are derived, since
+// the sender rotates them on its own
+func (s *session) open(channel Channel, data []byte) (id uint16, cmd reflect.Value, err error) {
+	msg := ProtectedCommand{}
+	if err = decodeRaw(data, &msg); err != nil {
+		return
+	}
+	cmd, ok := ProtectedCommands.GetCommand(msg.Command)
+	if !ok {
+		return 0, cmd, fmt.Errorf("command not found %d", msg.Command)
+	}
+	if err = ProtectedCommands.Supports(msg.Command, s.protocol); err != nil {
+		return 0, cmd, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	k := &s.receiving[channel]
+	if msg.Counter <= k.counter {
+		return 0, cmd, fmt.Errorf("replayed command %d of channel %d", msg.Counter, channel)
+	}
+	if msg.Epoch < k.epoch || msg.Epoch-k.epoch > maxEpochSkip {
+		return 0, cmd, fmt.Errorf("unexpected key epoch %d of channel %d", msg.Epoch, channel)
+	}
+	key := k.key
+	for epoch := k.epoch; epoch < msg.Epoch; epoch++ {
+		key = key.Next()
+	}
+	decoded, ok := key.Open(msg.Counter, msg.Payload, associated(channel, msg.Command))
+	if !ok {
+		return 0, cmd, errors.New("can't decrypt message")
+	}
+	k.key, k.epoch, k.counter = key, msg.Epoch, msg.Counter
+	if err = decodeRaw(decoded, cmd.Interface()); err != nil {
+		return
+	}
+	return msg.Command, cmd, nil
+}
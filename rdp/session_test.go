@@ -0,0 +1,115 @@
+package rdp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/discretemind/glink/utils/encoder"
+	"github.com/stretchr/testify/assert"
+)
+
+// sessionPair returns sessions of a worker and the master derived by a handshake
+func sessionPair(t *testing.T) (worker, master *session) {
+	workerKey, masterKey := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	connect, _ := newNonce()
+	challenge, _ := newNonce()
+	cluster, id := crypto.GeneratePrivateKey().Certificate(), workerKey.Certificate()
+
+	send, receive, err := sessionKeys(workerKey, masterKey.Public(), connect, challenge, cluster, id)
+	assert.NoError(t, err)
+	mReceive, mSend, err := sessionKeys(masterKey, workerKey.Public(), connect, challenge, cluster, id)
+	assert.NoError(t, err)
+	assert.Equal(t, send, mReceive)
+	assert.Equal(t, receive, mSend)
+	return newSession(send, receive, NewVersion(1, 0, 0)), newSession(mSend, mReceive, NewVersion(1, 0, 0))
+}
+
+// sent returns the commands the link has sent, assuming they fit a frame
+func sent(p *pipe) (res [][]byte) {
+	for _, f := range p.take() {
+		res = append(res, f.payload)
+	}
+	return
+}
+
+func TestSessionRejectsReplays(t *testing.T) {
+	worker, master := sessionPair(t)
+	out := &pipe{}
+	l := newLink(len(Packet{}), out.write)
+	assert.NoError(t, worker.send(l, assignQuantumResponseCmd{ID: "1", OK: true}))
+	assert.NoError(t, worker.send(l, assignQuantumResponseCmd{ID: "2", OK: true}))
+	commands := sent(out)
+
+	id, cmd, err := master.open(ControlChannel, commands[0])
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(5), id)
+	assert.Equal(t, "1", cmd.Interface().(*assignQuantumResponseCmd).ID)
+	_, _, err = master.open(ControlChannel, commands[0])
+	assert.Error(t, err, "the counter is used")
+
+	// the command id and the channel are authenticated
+	msg := ProtectedCommand{}
+	assert.NoError(t, decodeRaw(commands[1], &msg))
+	_, _, err = master.open(TelemetryChannel, commands[1])
+	assert.Error(t, err)
+	msg.Command = 10
+	_, _, err = master.open(ControlChannel, encoder.EncodeRaw(&msg))
+	assert.Error(t, err)
+	_, _, err = worker.open(ControlChannel, commands[1])
+	assert.Error(t, err, "keys of directions differ")
+
+	_, cmd, err = master.open(ControlChannel, commands[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "2", cmd.Interface().(*assignQuantumResponseCmd).ID)
+}
+
+func TestSessionRotatesKeys(t *testing.T) {
+	sessionKeyMessages = 2
+	defer func() {
+		sessionKeyMessages = 1 << 20
+	}()
+	worker, master := sessionPair(t)
+	out := &pipe{}
+	l := newLink(len(Packet{}), out.write)
+	for i := uint32(0); i < 8; i++ {
+		assert.NoError(t, worker.send(l, MetricsCmd{CpuUsage: i}))
+	}
+	commands := sent(out)
+	assert.Equal(t, uint32(3), worker.sending[TelemetryChannel].epoch)
+
+	// lost telemetry skips epochs
+	for _, i := range []int{0, 5, 7} {
+		_, cmd, err := master.open(TelemetryChannel, commands[i])
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(i), cmd.Interface().(*MetricsCmd).CpuUsage)
+	}
+	assert.Equal(t, uint32(3), master.receiving[TelemetryChannel].epoch)
+	_, _, err := master.open(TelemetryChannel, commands[6])
+	assert.Error(t, err)
+}
+
+// laterCmd is a command of a later protocol
+type laterCmd struct {
+	Value uint32
+}
+
+func TestSessionGatesCommands(t *testing.T) {
+	ProtectedCommands.registerSince(1000, laterCmd{}, NewVersion(1, 1, 0))
+	worker, master := sessionPair(t)
+	out := &pipe{}
+	l := newLink(len(Packet{}), out.write)
+	assert.True(t, errors.Is(worker.send(l, laterCmd{Value: 1}), ErrUnsupportedCommand))
+	assert.Empty(t, out.take())
+
+	worker.protocol = NewVersion(1, 1, 0)
+	assert.NoError(t, worker.send(l, laterCmd{Value: 1}))
+	_, _, err := master.open(ControlChannel, sent(out)[0])
+	assert.True(t, errors.Is(err, ErrUnsupportedCommand))
+
+	master.protocol = NewVersion(1, 1, 0)
+	assert.NoError(t, worker.send(l, laterCmd{Value: 2}))
+	_, cmd, err := master.open(ControlChannel, sent(out)[0])
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), cmd.Interface().(*laterCmd).Value)
+}
@@ -50,6 +50,33 @@ func (v Version) String() string {
 	return fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Build())
 }
 
+// Protocol is the version without the build, since builds of the same minor version speak the same protocol
+func (v Version) Protocol() Version {
+	return v &^ 0xffff
+}
+
+// IncompatibleVersionError is returned, when major versions of the master and the worker differ
+type IncompatibleVersionError struct {
+	Master Version
+	Worker Version
+}
+
+func (e *IncompatibleVersionError) Error() string {
+	return fmt.Sprintf("worker version %s is incompatible with master version %s", e.Worker, e.Master)
+}
+
+// Negotiate returns the common protocol of the master and the worker. It's the lower minor version,
+// so both of them know all commands of the protocol and workers are upgraded one by one
+func Negotiate(master, worker Version) (Version, error) {
+	if master.Major() != worker.Major() {
+		return ZeroVersion, &IncompatibleVersionError{Master: master, Worker: worker}
+	}
+	if master.CompareByMinor(worker) < 0 {
+		return master.Protocol(), nil
+	}
+	return worker.Protocol(), nil
+}
+
 func (v Version) CompareByMinor(v2 Version) int {
 	minor1 := v >> 16
 	minor2 := v2 >> 16
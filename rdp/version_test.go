@@ -1,8 +1,10 @@
 package rdp
 
 import (
-	"github.com/stretchr/testify/assert"
+	"errors"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestVersion(t *testing.T) {
@@ -16,3 +18,17 @@ func TestVersion(t *testing.T) {
 	assert.Equal(t, byte(10), v2.Minor())
 	assert.Equal(t, uint16(159), v2.Build())
 }
+
+func TestNegotiate(t *testing.T) {
+	v, err := Negotiate(NewVersion(1, 2, 7), NewVersion(1, 0, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, NewVersion(1, 0, 0), v)
+	v, err = Negotiate(NewVersion(1, 0, 7), NewVersion(1, 3, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, NewVersion(1, 0, 0), v)
+
+	_, err = Negotiate(NewVersion(1, 0, 0), NewVersion(2, 0, 0))
+	var incompatible *IncompatibleVersionError
+	assert.True(t, errors.As(err, &incompatible))
+	assert.Equal(t, NewVersion(2, 0, 0), incompatible.Worker)
+}
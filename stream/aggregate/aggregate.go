@@ -0,0 +1,305 @@
+// Package aggregate provides window aggregate functions. All aggregates are mergeable, so they work with
+// session windows and combine partial results of parallel instances. Fields are paths resolved by the field
+// package, an empty field aggregates the value itself
+package aggregate
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/stream/field"
+)
+
+// extractor resolves the field of values. An invalid path fails every Add, so events go to faults
+type extractor struct {
+	path *field.Path
+	err  error
+}
+
+func newExtractor(path string) extractor {
+	if path == "" {
+		return extractor{}
+	}
+	p, err := field.Compile(path)
+	return extractor{path: p, err: err}
+}
+
+func (e extractor) get(value interface{}) (interface{}, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.path == nil {
+		return value, nil
+	}
+	res, ok := e.path.Get(value)
+	if !ok {
+		return nil, fmt.Errorf("field %s not found in %T", e.path, value)
+	}
+	return res, nil
+}
+
+func (e extractor) number(value interface{}) (float64, error) {
+	v, err := e.get(value)
+	if err != nil {
+		return 0, err
+	}
+	res, ok := field.Number(v)
+	if !ok {
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+	return res, nil
+}
+
+// key identifies values by their JSON encoding, so restored values match the original ones
+func key(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// hash128 gives two independent hashes of the key for double hashing
+func hash128(key string) (h1, h2 uint64) {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum(nil)
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[8+i])
+	}
+	return mix(h1), mix(h2)
+}
+
+// mix is the finalizer of splitmix64, which spreads bits of fnv hashes
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func mergeError(agg, other stream.IAggregate) error {
+	return fmt.Errorf("can't merge %T into %T", other, agg)
+}
+
+type count struct {
+	N int64
+}
+
+// Count counts values
+func Count() stream.AggregateFactory {
+	return func() stream.IAggregate {
+		return &count{}
+	}
+}
+
+func (a *count) Add(interface{}) error {
+	a.N++
+	return nil
+}
+
+func (a *count) Merge(other stream.IAggregate) error {
+	o, ok := other.(*count)
+	if !ok {
+		return mergeError(a, other)
+	}
+	a.N += o.N
+	return nil
+}
+
+func (a *count) Result() interface{} {
+	return a.N
+}
+
+type sum struct {
+	field extractor
+	Sum   float64
+}
+
+func Sum(path string) stream.AggregateFactory {
+	f := newExtractor(path)
+	return func() stream.IAggregate {
+		return &sum{field: f}
+	}
+}
+
+func (a *sum) Add(value interface{}) error {
+	v, err := a.field.number(value)
+	a.Sum += v
+	return err
+}
+
+func (a *sum) Merge(other stream.IAggregate) error {
+	o, ok := other.(*sum)
+	if !ok {
+		return mergeError(a, other)
+	}
+	a.Sum += o.Sum
+	return nil
+}
+
+func (a *sum) Result() interface{} {
+	return a.Sum
+}
+
+// extremum is min or max. Result is nil for empty windows
+type extremum struct {
+	field extractor
+	max   bool
+	Value float64
+	Set   bool
+}
+
+func Min(path string) stream.AggregateFactory {
+	f := newExtractor(path)
+	return func() stream.IAggregate {
+		return &extremum{field: f}
+	}
+}
+
+func Max(path string) stream.AggregateFactory {
+	f := newExtractor(path)
+	return func() stream.IAggregate {
+		return &extremum{field: f, max: true}
+	}
+}
+
+func (a *extremum) Add(value interface{}) error {
+	v, err := a.field.number(value)
+	if err != nil {
+		return err
+	}
+	a.add(v)
+	return nil
+}
+
+func (a *extremum) add(v float64) {
+	if !a.Set || a.max && v > a.Value || !a.max && v < a.Value {
+		a.Value = v
+		a.Set = true
+	}
+}
+
+func (a *extremum) Merge(other stream.IAggregate) error {
+	o, ok := other.(*extremum)
+	if !ok || o.max != a.max {
+		return mergeError(a, other)
+	}
+	if o.Set {
+		a.add(o.Value)
+	}
+	return nil
+}
+
+func (a *extremum) Result() interface{} {
+	if !a.Set {
+		return nil
+	}
+	return a.Value
+}
+
+type avg struct {
+	field extractor
+	Sum   float64
+	Count int64
+}
+
+// Avg is the mean of values. Result is nil for empty windows
+func Avg(path string) stream.AggregateFactory {
+	f := newExtractor(path)
+	return func() stream.IAggregate {
+		return &avg{field: f}
+	}
+}
+
+func (a *avg) Add(value interface{}) error {
+	v, err := a.field.number(value)
+	if err != nil {
+		return err
+	}
+	a.Sum += v
+	a.Count++
+	return nil
+}
+
+func (a *avg) Merge(other stream.IAggregate) error {
+	o, ok := other.(*avg)
+	if !ok {
+		return mergeError(a, other)
+	}
+	a.Sum += o.Sum
+	a.Count += o.Count
+	return nil
+}
+
+func (a *avg) Result() interface{} {
+	if a.Count == 0 {
+		return nil
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// Ranked is a value with its score
+type Ranked struct {
+	Score float64
+	Value interface{}
+}
+
+type topN struct {
+	field extractor
+	n     int
+	Items []Ranked
+}
+
+// TopN keeps n values with the highest score field. Result is []Ranked ordered by score descending,
+// values of equal scores keep their arrival order. Values restored from a checkpoint are decoded JSON values
+func TopN(n int, path string) stream.AggregateFactory {
+	f := newExtractor(path)
+	return func() stream.IAggregate {
+		return &topN{field: f, n: n}
+	}
+}
+
+func (a *topN) Add(value interface{}) error {
+	score, err := a.field.number(value)
+	if err != nil {
+		return err
+	}
+	a.insert(Ranked{Score: score, Value: value})
+	return nil
+}
+
+func (a *topN) insert(r Ranked) {
+	i := sort.Search(len(a.Items), func(i int) bool {
+		return a.Items[i].Score < r.Score
+	})
+	if i >= a.n {
+		return
+	}
+	a.Items = append(a.Items, Ranked{})
+	copy(a.Items[i+1:], a.Items[i:])
+	a.Items[i] = r
+	if len(a.Items) > a.n {
+		a.Items = a.Items[:a.n]
+	}
+}
+
+func (a *topN) Merge(other stream.IAggregate) error {
+	o, ok := other.(*topN)
+	if !ok {
+		return mergeError(a, other)
+	}
+	for _, r := range o.Items {
+		a.insert(r)
+	}
+	return nil
+}
+
+func (a *topN) Result() interface{} {
+	res := make([]Ranked, len(a.Items))
+	copy(res, a.Items)
+	return res
+}
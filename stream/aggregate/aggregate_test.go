@@ -0,0 +1,133 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+type score struct {
+	Player string
+	Points int
+}
+
+func add(t *testing.T, agg stream.IAggregate, values ...interface{}) stream.IAggregate {
+	for _, v := range values {
+		assert.NoError(t, agg.Add(v))
+	}
+	return agg
+}
+
+func TestBasic(t *testing.T) {
+	scores := []interface{}{score{"a", 3}, score{"b", 10}, score{"c", -2}, score{"d", 5}}
+	for _, c := range []struct {
+		factory stream.AggregateFactory
+		result  interface{}
+	}{
+		{Count(), int64(4)},
+		{Sum("Points"), 16.0},
+		{Min("Points"), -2.0},
+		{Max("Points"), 10.0},
+		{Avg("Points"), 4.0},
+		{TopN(2, "Points"), []Ranked{{10, score{"b", 10}}, {5, score{"d", 5}}}},
+	} {
+		// merged halves give the same result as a single aggregate
+		whole := add(t, c.factory(), scores...)
+		assert.Equal(t, c.result, whole.Result())
+		left, right := add(t, c.factory(), scores[:1]...), add(t, c.factory(), scores[1:]...)
+		assert.NoError(t, left.Merge(right))
+		assert.Equal(t, c.result, left.Result())
+		assert.NoError(t, left.Merge(c.factory()))
+		assert.Equal(t, c.result, left.Result(), "merging empty aggregate")
+	}
+
+	assert.Nil(t, Min("Points")().Result())
+	assert.Nil(t, Avg("Points")().Result())
+	assert.Error(t, Sum("Points")().Add(score{}.Player))
+	assert.Error(t, Sum("Points[")().Add(score{}))
+	assert.Error(t, Sum("")().Merge(Count()()))
+	assert.Error(t, Min("")().Merge(Max("")()))
+}
+
+func TestDistinctCount(t *testing.T) {
+	left, right := DistinctCount("")(), DistinctCount("")()
+	for i := 0; i < 60000; i++ {
+		add(t, left, i%40000)
+		add(t, right, 20000+i%40000)
+	}
+	assert.InEpsilon(t, 40000, left.Result(), 0.03)
+	assert.NoError(t, left.Merge(right))
+	assert.InEpsilon(t, 60000, left.Result(), 0.03)
+
+	small := add(t, DistinctCount("Player", 10)(), score{Player: "a"}, score{Player: "b"}, score{Player: "a"})
+	assert.Equal(t, uint64(2), small.Result())
+	assert.Error(t, small.Merge(left), "precision mismatch")
+}
+
+func TestQuantiles(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var values []float64
+	parts := []stream.IAggregate{Quantiles("", 100, 0.01, 0.5, 0.99)(), Quantiles("", 100, 0.01, 0.5, 0.99)()}
+	for i := 0; i < 20000; i++ {
+		v := r.NormFloat64()*10 + 50
+		values = append(values, v)
+		add(t, parts[i%2], v)
+	}
+	sort.Float64s(values)
+	assert.NoError(t, parts[0].Merge(parts[1]))
+	res := parts[0].Result().([]float64)
+	for i, q := range []float64{0.01, 0.5, 0.99} {
+		assert.InDelta(t, values[int(q*float64(len(values)))], res[i], 0.5, "quantile %v", q)
+	}
+	assert.True(t, math.IsNaN(Quantiles("", 0, 0.5)().Result().([]float64)[0]))
+}
+
+func TestHeavyHitters(t *testing.T) {
+	parts := []stream.IAggregate{HeavyHitters("Player", 3, 0.001, 0.01)(), HeavyHitters("Player", 3, 0.001, 0.01)()}
+	i := 0
+	push := func(player string, n int) {
+		for ; n > 0; n-- {
+			add(t, parts[i%2], score{Player: player})
+			i++
+		}
+	}
+	push("x", 500)
+	push("y", 300)
+	for p := 0; p < 1000; p++ {
+		push(fmt.Sprintf("p%d", p), 1)
+	}
+	push("z", 200)
+
+	assert.NoError(t, parts[0].Merge(parts[1]))
+	res := parts[0].Result().([]HeavyHitter)
+	assert.Len(t, res, 3)
+	for n, expected := range []string{"x", "y", "z"} {
+		assert.Equal(t, expected, res[n].Value)
+		assert.True(t, res[n].Count >= []uint64{500, 300, 200}[n])
+	}
+
+	for _, invalid := range [][2]float64{{0, 0.01}, {-1, 0.01}, {0.01, 0}, {0.01, 1}} {
+		assert.Error(t, HeavyHitters("Player", 3, invalid[0], invalid[1])().Add(map[string]interface{}{"Player": "x"}), "%v", invalid)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, factory := range []stream.AggregateFactory{
+		Count(), Sum(""), Max(""), Avg(""), TopN(2, ""), DistinctCount("", 8), Quantiles("", 50, 0.5), HeavyHitters("", 2, 0.01, 0.01),
+	} {
+		agg := add(t, factory(), 1, 2, 3, 2)
+		data, err := json.Marshal(agg)
+		assert.NoError(t, err)
+		restored := factory()
+		assert.NoError(t, json.Unmarshal(data, restored))
+		expected, _ := json.Marshal(agg.Result())
+		actual, _ := json.Marshal(restored.Result())
+		assert.JSONEq(t, string(expected), string(actual), "%T", agg)
+	}
+}
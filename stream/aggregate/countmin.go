@@ -0,0 +1,147 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/discretemind/glink/stream"
+)
+
+// HeavyHitter is a frequent value with its estimated count
+type HeavyHitter struct {
+	Value interface{}
+	Count uint64
+}
+
+// countMin estimates value counts with a Count-Min sketch and tracks k values with the highest estimates
+type countMin struct {
+	field      extractor
+	k          int
+	Width      int
+	Depth      int
+	Table      [][]uint64
+	Candidates map[string]HeavyHitter
+}
+
+// HeavyHitters tracks k most frequent values. Counts are overestimated by at most epsilon times the total
+// count with the probability 1-delta. Result is []HeavyHitter ordered by count descending.
+// Epsilon and delta should be within (0, 1)
+func HeavyHitters(path string, k int, epsilon, delta float64) stream.AggregateFactory {
+	f := newExtractor(path)
+	if !(epsilon > 0 && epsilon < 1) {
+		f.err = fmt.Errorf("epsilon %v is out of (0, 1)", epsilon)
+		epsilon = 1
+	}
+	if !(delta > 0 && delta < 1) {
+		f.err = fmt.Errorf("delta %v is out of (0, 1)", delta)
+		delta = 1
+	}
+	width := int(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	if depth < 1 {
+		depth = 1
+	}
+	return func() stream.IAggregate {
+		table := make([][]uint64, depth)
+		for i := range table {
+			table[i] = make([]uint64, width)
+		}
+		return &countMin{
+			field:      f,
+			k:          k,
+			Width:      width,
+			Depth:      depth,
+			Table:      table,
+			Candidates: make(map[string]HeavyHitter),
+		}
+	}
+}
+
+func (a *countMin) Add(value interface{}) error {
+	v, err := a.field.get(value)
+	if err != nil {
+		return err
+	}
+	k, err := key(v)
+	if err != nil {
+		return err
+	}
+	h1, h2 := hash128(k)
+	for i, row := range a.Table {
+		row[(h1+uint64(i)*h2)%uint64(a.Width)]++
+	}
+	a.track(k, v)
+	return nil
+}
+
+func (a *countMin) estimate(k string) uint64 {
+	h1, h2 := hash128(k)
+	res := uint64(math.MaxUint64)
+	for i, row := range a.Table {
+		if c := row[(h1+uint64(i)*h2)%uint64(a.Width)]; c < res {
+			res = c
+		}
+	}
+	return res
+}
+
+// track updates the value estimate and replaces the least frequent candidate, when the value has overtaken it
+func (a *countMin) track(k string, value interface{}) {
+	count := a.estimate(k)
+	if _, ok := a.Candidates[k]; ok || len(a.Candidates) < a.k {
+		a.Candidates[k] = HeavyHitter{Value: value, Count: count}
+		return
+	}
+	minKey, minCount := "", uint64(math.MaxUint64)
+	for ck, c := range a.Candidates {
+		if c.Count < minCount || c.Count == minCount && ck < minKey {
+			minKey, minCount = ck, c.Count
+		}
+	}
+	if count > minCount {
+		delete(a.Candidates, minKey)
+		a.Candidates[k] = HeavyHitter{Value: value, Count: count}
+	}
+}
+
+func (a *countMin) Merge(other stream.IAggregate) error {
+	o, ok := other.(*countMin)
+	if !ok || o.Width != a.Width || o.Depth != a.Depth {
+		return mergeError(a, other)
+	}
+	for i, row := range o.Table {
+		for j, c := range row {
+			a.Table[i][j] += c
+		}
+	}
+	candidates := a.Candidates
+	a.Candidates = make(map[string]HeavyHitter, a.k)
+	for _, cs := range []map[string]HeavyHitter{candidates, o.Candidates} {
+		for k, c := range cs {
+			a.track(k, c.Value)
+		}
+	}
+	// estimates of candidates tracked before the others were merged are refreshed
+	for k, c := range a.Candidates {
+		c.Count = a.estimate(k)
+		a.Candidates[k] = c
+	}
+	return nil
+}
+
+func (a *countMin) Result() interface{} {
+	keys := make([]string, 0, len(a.Candidates))
+	for k := range a.Candidates {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := a.Candidates[keys[i]].Count, a.Candidates[keys[j]].Count
+		return ci > cj || ci == cj && keys[i] < keys[j]
+	})
+	res := make([]HeavyHitter, len(keys))
+	for i, k := range keys {
+		res[i] = a.Candidates[k]
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/discretemind/glink/stream"
+)
+
+const DefaultPrecision = 14
+
+type hyperLogLog struct {
+	field     extractor
+	Precision uint8
+	Registers []uint8
+}
+
+// DistinctCount estimates the number of distinct values with HyperLogLog. Precision from 4 to 16 gives
+// 2^precision registers and the standard error about 1.04/sqrt(2^precision), 0.8% with the default 14
+func DistinctCount(path string, precision ...uint8) stream.AggregateFactory {
+	f := newExtractor(path)
+	p := uint8(DefaultPrecision)
+	if len(precision) > 0 {
+		p = precision[0]
+	}
+	if p < 4 || p > 16 {
+		f.err = fmt.Errorf("precision %d is out of 4..16", p)
+		p = DefaultPrecision
+	}
+	return func() stream.IAggregate {
+		return &hyperLogLog{
+			field:     f,
+			Precision: p,
+			Registers: make([]uint8, 1<<p),
+		}
+	}
+}
+
+func (a *hyperLogLog) Add(value interface{}) error {
+	v, err := a.field.get(value)
+	if err != nil {
+		return err
+	}
+	k, err := key(v)
+	if err != nil {
+		return err
+	}
+	h, _ := hash128(k)
+	index := h >> (64 - a.Precision)
+	rank := uint8(bits.LeadingZeros64(h<<a.Precision|1<<(a.Precision-1))) + 1
+	if rank > a.Registers[index] {
+		a.Registers[index] = rank
+	}
+	return nil
+}
+
+func (a *hyperLogLog) Merge(other stream.IAggregate) error {
+	o, ok := other.(*hyperLogLog)
+	if !ok || o.Precision != a.Precision {
+		return mergeError(a, other)
+	}
+	for i, r := range o.Registers {
+		if r > a.Registers[i] {
+			a.Registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Result is the estimated count as uint64
+func (a *hyperLogLog) Result() interface{} {
+	m := float64(len(a.Registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range a.Registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	switch m {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	}
+	estimate := alpha * m * m / sum
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
@@ -0,0 +1,138 @@
+package aggregate
+
+import (
+	"math"
+	"sort"
+
+	"github.com/discretemind/glink/stream"
+)
+
+const DefaultCompression = 100
+
+type Centroid struct {
+	Mean  float64
+	Count float64
+}
+
+// tDigest clusters values into centroids, which are small near the tails, so extreme quantiles are accurate
+type tDigest struct {
+	field       extractor
+	compression float64
+	quantiles   []float64
+	Centroids   []Centroid
+	Count       float64
+	Min, Max    float64
+	unmerged    int
+}
+
+// Quantiles estimates the quantiles of values with t-digest. Result is []float64 in the order of quantiles,
+// NaN for empty windows. Higher compression gives more accuracy for more memory
+func Quantiles(path string, compression float64, quantiles ...float64) stream.AggregateFactory {
+	f := newExtractor(path)
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+	return func() stream.IAggregate {
+		return &tDigest{
+			field:       f,
+			compression: compression,
+			quantiles:   quantiles,
+		}
+	}
+}
+
+func (a *tDigest) Add(value interface{}) error {
+	v, err := a.field.number(value)
+	if err != nil {
+		return err
+	}
+	a.add(Centroid{Mean: v, Count: 1}, v, v)
+	return nil
+}
+
+func (a *tDigest) add(c Centroid, min, max float64) {
+	if a.Count == 0 || min < a.Min {
+		a.Min = min
+	}
+	if a.Count == 0 || max > a.Max {
+		a.Max = max
+	}
+	a.Centroids = append(a.Centroids, c)
+	a.Count += c.Count
+	a.unmerged++
+	if a.unmerged > int(5*a.compression) {
+		a.compress()
+	}
+}
+
+func (a *tDigest) compress() {
+	a.unmerged = 0
+	if len(a.Centroids) < 2 {
+		return
+	}
+	sort.Slice(a.Centroids, func(i, j int) bool {
+		return a.Centroids[i].Mean < a.Centroids[j].Mean
+	})
+	res := a.Centroids[:1]
+	before := 0.0
+	for _, c := range a.Centroids[1:] {
+		cur := &res[len(res)-1]
+		q := (before + (cur.Count+c.Count)/2) / a.Count
+		if cur.Count+c.Count <= 4*a.Count*q*(1-q)/a.compression {
+			cur.Mean += (c.Mean - cur.Mean) * c.Count / (cur.Count + c.Count)
+			cur.Count += c.Count
+			continue
+		}
+		before += cur.Count
+		res = append(res, c)
+	}
+	a.Centroids = res
+}
+
+func (a *tDigest) Merge(other stream.IAggregate) error {
+	o, ok := other.(*tDigest)
+	if !ok {
+		return mergeError(a, other)
+	}
+	for _, c := range o.Centroids {
+		a.add(c, o.Min, o.Max)
+	}
+	return nil
+}
+
+func (a *tDigest) Result() interface{} {
+	a.compress()
+	res := make([]float64, len(a.quantiles))
+	for i, q := range a.quantiles {
+		res[i] = a.quantile(q)
+	}
+	return res
+}
+
+// quantile interpolates between centroid centers, the tails are interpolated to min and max
+func (a *tDigest) quantile(q float64) float64 {
+	if a.Count == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return a.Min
+	}
+	if q >= 1 {
+		return a.Max
+	}
+	target := q * a.Count
+	prevCenter, prevMean := 0.0, a.Min
+	cumulative := 0.0
+	for _, c := range a.Centroids {
+		center := cumulative + c.Count/2
+		if target < center {
+			return prevMean + (c.Mean-prevMean)*(target-prevCenter)/(center-prevCenter)
+		}
+		prevCenter, prevMean = center, c.Mean
+		cumulative += c.Count
+	}
+	if a.Count == prevCenter {
+		return a.Max
+	}
+	return prevMean + (a.Max-prevMean)*(target-prevCenter)/(a.Count-prevCenter)
+}
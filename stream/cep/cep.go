@@ -0,0 +1,265 @@
+package cep
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/discretemind/glink/stream"
+)
+
+// Match is a matched sequence or, in timeouts, a partial one. Events are indexed by stage names.
+// Keys and payloads restored from a checkpoint are decoded JSON values
+type Match struct {
+	Key    interface{}
+	Events map[string]stream.Event
+}
+
+// PatternStream is the stream of matches. Partial matches timed out go to the Timeouts side output,
+// late events, which are behind the watermark, go to faults
+type PatternStream struct {
+	*stream.DataStream
+	timeouts *stream.DataStream
+}
+
+func (s *PatternStream) Timeouts() *stream.DataStream {
+	return s.timeouts
+}
+
+type partial struct {
+	Start  time.Time
+	Step   int
+	Events map[string]stream.Event
+}
+
+type keyState struct {
+	Key interface{}
+	// Buffer keeps events until the watermark passes them, so they are matched in event time order
+	Buffer   []stream.Event
+	Partials []*partial
+}
+
+type operator struct {
+	ctx         *stream.Context
+	steps       []step
+	within      time.Duration
+	maxPartials int
+	keys        map[string]*keyState
+	matches     *stream.DataStream
+	timeouts    *stream.DataStream
+}
+
+// Detect matches the pattern against events of every key. The pattern state is included in checkpoints
+// of the stream context as cep/<number>
+func Detect(s *stream.KeyedStream, p *Pattern) (*PatternStream, error) {
+	steps, err := p.compile()
+	if err != nil {
+		return nil, fmt.Errorf("pattern %s: %v", p.Name(), err)
+	}
+	ctx := s.Context()
+	o := &operator{
+		ctx:         ctx,
+		steps:       steps,
+		within:      p.within,
+		maxPartials: p.maxPartials,
+		keys:        make(map[string]*keyState),
+		matches:     stream.ContextStream(ctx).Name("CEP " + p.Name()),
+		timeouts:    stream.ContextStream(ctx).Name("CEP Timeouts " + p.Name()),
+	}
+	ctx.RegisterOperatorState("cep", o)
+
+	s.BindOut(func(event *stream.Event) {
+		o.matches.Metrics().In()
+		// events at the watermark are on time, as in windows
+		if event.Timestamp.Before(ctx.Watermark()) {
+			o.matches.EmitFault(event)
+			return
+		}
+		key := s.Key(event)
+		id, err := json.Marshal(key)
+		if err != nil {
+			o.matches.EmitFault(event)
+			return
+		}
+		state, ok := o.keys[string(id)]
+		if !ok {
+			state = &keyState{Key: key}
+			o.keys[string(id)] = state
+		}
+		state.Buffer = append(state.Buffer, *event)
+		o.schedule(string(id), event.Timestamp)
+	})
+
+	return &PatternStream{
+		DataStream: o.matches,
+		timeouts:   o.timeouts,
+	}, nil
+}
+
+func (o *operator) schedule(id string, at time.Time) {
+	o.ctx.OnEventTime(at, func(t time.Time) {
+		o.advance(id, t)
+	})
+}
+
+// advance matches buffered events up to the watermark and times out partial matches expired by it
+func (o *operator) advance(id string, watermark time.Time) {
+	state, ok := o.keys[id]
+	if !ok {
+		return
+	}
+	sort.SliceStable(state.Buffer, func(i, j int) bool {
+		return state.Buffer[i].Timestamp.Before(state.Buffer[j].Timestamp)
+	})
+	n := 0
+	for n < len(state.Buffer) && !state.Buffer[n].Timestamp.After(watermark) {
+		o.process(id, state, state.Buffer[n])
+		n++
+	}
+	state.Buffer = append(state.Buffer[:0], state.Buffer[n:]...)
+
+	state.Partials = o.expire(state, state.Partials, watermark)
+	if len(state.Buffer) == 0 && len(state.Partials) == 0 {
+		delete(o.keys, id)
+	}
+}
+
+func (o *operator) process(id string, state *keyState, event stream.Event) {
+	partials := o.expire(state, state.Partials, event.Timestamp)
+
+	next := partials[:0]
+	for _, p := range partials {
+		s := o.steps[p.Step]
+		if forbidden(s, event.Payload) {
+			continue
+		}
+		if !s.cond(event.Payload) {
+			if !s.strict {
+				next = append(next, p)
+			}
+			continue
+		}
+		p.Events[s.name] = event
+		p.Step++
+		if p.Step == len(o.steps) {
+			o.matches.Emit(&stream.Event{
+				Timestamp: event.Timestamp,
+				Payload:   Match{Key: state.Key, Events: p.Events},
+			})
+			continue
+		}
+		next = append(next, p)
+	}
+
+	first := o.steps[0]
+	if first.cond(event.Payload) {
+		p := &partial{
+			Start:  event.Timestamp,
+			Step:   1,
+			Events: map[string]stream.Event{first.name: event},
+		}
+		if len(o.steps) == 1 {
+			o.matches.Emit(&stream.Event{
+				Timestamp: event.Timestamp,
+				Payload:   Match{Key: state.Key, Events: p.Events},
+			})
+		} else {
+			next = append(next, p)
+			if o.within > 0 {
+				o.schedule(id, p.Start.Add(o.within))
+			}
+		}
+	}
+	// partials are ordered by start, so the oldest ones are dropped
+	if drop := len(next) - o.maxPartials; drop > 0 {
+		for i := 0; i < drop; i++ {
+			o.matches.Metrics().Drop()
+		}
+		next = append(next[:0], next[drop:]...)
+	}
+	state.Partials = next
+}
+
+func forbidden(s step, value interface{}) bool {
+	for _, cond := range s.forbidden {
+		if cond(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// expire emits partial matches, which can't complete by the time, as timeouts. The last event must come
+// before the first one plus the within duration
+func (o *operator) expire(state *keyState, partials []*partial, t time.Time) []*partial {
+	if o.within <= 0 {
+		return partials
+	}
+	res := partials[:0]
+	for _, p := range partials {
+		deadline := p.Start.Add(o.within)
+		if t.Before(deadline) {
+			res = append(res, p)
+			continue
+		}
+		o.timeouts.Emit(&stream.Event{
+			Timestamp: deadline,
+			Payload:   Match{Key: state.Key, Events: p.Events},
+		})
+	}
+	return res
+}
+
+func (o *operator) Snapshot() ([]byte, error) {
+	return json.Marshal(o.keys)
+}
+
+// Restore loads key states and schedules their buffered events and timeouts again
+func (o *operator) Restore(data []byte) error {
+	keys := make(map[string]*keyState)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	o.keys = make(map[string]*keyState, len(keys))
+	o.load(keys)
+	return nil
+}
+
+// RestoreKeys loads states of the keys moved to the worker
+func (o *operator) RestoreKeys(data []byte, keep func(key string) bool) error {
+	keys := make(map[string]*keyState)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for id := range keys {
+		if !keep(id) {
+			delete(keys, id)
+		}
+	}
+	o.load(keys)
+	return nil
+}
+
+// DropKeys removes states of the keys. Their timers find no state
+func (o *operator) DropKeys(drop func(key string) bool) {
+	for id := range o.keys {
+		if drop(id) {
+			delete(o.keys, id)
+		}
+	}
+}
+
+func (o *operator) load(keys map[string]*keyState) {
+	for id, state := range keys {
+		o.keys[id] = state
+		for _, e := range state.Buffer {
+			o.schedule(id, e.Timestamp)
+		}
+		if o.within > 0 {
+			for _, p := range state.Partials {
+				o.schedule(id, p.Start.Add(o.within))
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package cep
+
+import (
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/glinktest"
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+type action struct {
+	User string
+	Kind string
+}
+
+func kind(k string) Condition {
+	return func(value interface{}) bool {
+		return value.(action).Kind == k
+	}
+}
+
+func event(minutes int, user, kind string) stream.Event {
+	return stream.Event{
+		Timestamp: start.Add(time.Duration(minutes) * time.Minute),
+		Payload:   action{User: user, Kind: kind},
+	}
+}
+
+func loginTransfer() *Pattern {
+	return Begin("login", kind("login")).
+		NotFollowedBy("logout", kind("logout")).
+		FollowedBy("transfer", kind("transfer")).
+		Within(5 * time.Minute)
+}
+
+type detector struct {
+	push     func(events ...stream.Event)
+	matches  *glinktest.Sink
+	timeouts *glinktest.Sink
+	late     *glinktest.Sink
+}
+
+func detect(t *testing.T, h *glinktest.Harness, p *Pattern) *detector {
+	input := stream.ContextInputStream(h.Context())
+	ps, err := Detect(input.KeyBy(func(value interface{}) interface{} {
+		return value.(action).User
+	}), p)
+	assert.NoError(t, err)
+	return &detector{
+		push: func(events ...stream.Event) {
+			for i := range events {
+				input.PushEvent(&events[i])
+			}
+		},
+		matches:  glinktest.Collect(ps.DataStream),
+		timeouts: glinktest.Collect(ps.Timeouts()),
+		late:     glinktest.CollectFaults(ps.DataStream),
+	}
+}
+
+func matched(sink *glinktest.Sink) (res []string) {
+	for _, v := range sink.Values() {
+		m := v.(Match)
+		res = append(res, m.Key.(string))
+	}
+	return
+}
+
+func TestFollowedByWithin(t *testing.T) {
+	h := glinktest.New(start)
+	d := detect(t, h, loginTransfer())
+
+	d.push(
+		event(0, "alice", "login"),
+		event(1, "bob", "login"),
+		event(2, "alice", "browse"),
+		event(2, "bob", "logout"),
+		event(3, "alice", "transfer"),
+		event(4, "bob", "transfer"),
+		event(10, "carol", "login"),
+	)
+	h.AdvanceWatermark(start.Add(10 * time.Minute))
+
+	assert.Equal(t, []string{"alice"}, matched(d.matches))
+	m := d.matches.Values()[0].(Match)
+	assert.Equal(t, start, m.Events["login"].Timestamp)
+	assert.Equal(t, start.Add(3*time.Minute), m.Events["transfer"].Timestamp)
+	assert.Empty(t, d.timeouts.Values())
+
+	h.AdvanceWatermark(start.Add(15 * time.Minute))
+	assert.Equal(t, []string{"carol"}, matched(d.timeouts))
+	assert.Equal(t, start.Add(15*time.Minute), d.timeouts.Events()[0].Timestamp)
+}
+
+func TestEventTimeOrder(t *testing.T) {
+	h := glinktest.New(start)
+	d := detect(t, h, loginTransfer())
+
+	d.push(event(2, "alice", "transfer"), event(1, "alice", "login"))
+	h.AdvanceWatermark(start.Add(2 * time.Minute))
+	assert.Equal(t, []string{"alice"}, matched(d.matches))
+
+	d.push(event(1, "bob", "login"))
+	assert.Len(t, d.late.Values(), 1)
+	assert.Empty(t, d.timeouts.Values())
+}
+
+func TestStrictContiguity(t *testing.T) {
+	h := glinktest.New(start)
+	d := detect(t, h, Begin("a", kind("login")).Next("b", kind("transfer")))
+
+	d.push(
+		event(0, "alice", "login"),
+		event(1, "alice", "browse"),
+		event(2, "alice", "transfer"),
+		event(3, "alice", "login"),
+		event(4, "alice", "transfer"),
+	)
+	h.AdvanceWatermark(start.Add(time.Hour))
+	assert.Len(t, d.matches.Values(), 1)
+	assert.Equal(t, start.Add(4*time.Minute), d.matches.Events()[0].Timestamp)
+}
+
+func TestCheckpoint(t *testing.T) {
+	h := glinktest.New(start)
+	d := detect(t, h, loginTransfer())
+	d.push(event(0, "alice", "login"))
+	h.AdvanceWatermark(start)
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+
+	restored := glinktest.New(start)
+	rd := detect(t, restored, loginTransfer())
+	assert.NoError(t, restored.Context().Restore(snapshot))
+	restored.AdvanceWatermark(start)
+
+	rd.push(event(2, "alice", "transfer"))
+	restored.AdvanceWatermark(start.Add(10 * time.Minute))
+	assert.Equal(t, []string{"alice"}, matched(rd.matches))
+	assert.Empty(t, rd.timeouts.Values())
+}
+
+func TestInvalidPattern(t *testing.T) {
+	h := glinktest.New(start)
+	_, s := h.Input()
+	keyed := s.KeyBy(func(value interface{}) interface{} { return value })
+
+	_, err := Detect(keyed, Begin("a", kind("login")).NotFollowedBy("b", kind("logout")))
+	assert.Error(t, err)
+	_, err = Detect(keyed, Begin("a", kind("login")).FollowedBy("a", kind("logout")))
+	assert.Error(t, err)
+	_, err = Detect(keyed, loginTransfer().MaxPartials(0))
+	assert.Error(t, err)
+
+	// patterns of the same name have their own states
+	_, err = Detect(keyed, loginTransfer())
+	assert.NoError(t, err)
+	_, err = Detect(keyed, loginTransfer())
+	assert.NoError(t, err)
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+	assert.Contains(t, snapshot, "cep/1")
+	assert.Contains(t, snapshot, "cep/2")
+}
+
+func TestMaxPartials(t *testing.T) {
+	h := glinktest.New(start)
+	d := detect(t, h, Begin("login", kind("login")).FollowedBy("transfer", kind("transfer")).MaxPartials(2))
+	for i := 0; i < 5; i++ {
+		d.push(event(i, "alice", "login"))
+	}
+	h.AdvanceWatermark(start.Add(5 * time.Minute))
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"\"alice\"": {"Key": "alice", "Buffer": [], "Partials": [
+		{"Start": "2026-10-19T09:03:00Z", "Step": 1, "Events": {"login": {"tm": "2026-10-19T09:03:00Z", "Payload": {"User": "alice", "Kind": "login"}}}},
+		{"Start": "2026-10-19T09:04:00Z", "Step": 1, "Events": {"login": {"tm": "2026-10-19T09:04:00Z", "Payload": {"User": "alice", "Kind": "login"}}}}
+	]}}`, string(snapshot["cep/1"]), "the oldest partials are dropped")
+
+	d.push(event(6, "alice", "transfer"))
+	h.AdvanceWatermark(start.Add(10 * time.Minute))
+	assert.Equal(t, []string{"alice", "alice"}, matched(d.matches))
+}
+
+func TestAutomaticWatermark(t *testing.T) {
+	h := glinktest.New(start)
+	h.Context().SetManualWatermark(false)
+	d := detect(t, h, Begin("login", kind("login")).FollowedBy("transfer", kind("transfer")).Within(5*time.Minute))
+
+	// events of the same time as the previous one are on time
+	d.push(event(0, "a", "login"), event(0, "b", "login"), event(1, "a", "transfer"), event(1, "b", "transfer"))
+	d.push(event(2, "c", "logout"))
+	assert.ElementsMatch(t, []string{"a", "b"}, matched(d.matches))
+	assert.Empty(t, d.late.Values())
+
+	d.push(event(1, "c", "login"))
+	assert.Len(t, d.late.Values(), 1)
+}
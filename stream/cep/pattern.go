@@ -0,0 +1,133 @@
+// Package cep detects patterns of events in keyed streams. A pattern is a sequence of stages matched by
+// a nondeterministic automaton per key in event time order
+package cep
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Condition tells whether the event payload matches a stage
+type Condition func(value interface{}) bool
+
+type stage struct {
+	name    string
+	cond    Condition
+	strict  bool
+	negated bool
+}
+
+// Pattern is built starting with Begin, e.g. A followed by B within 5 minutes without C in between:
+//
+//	cep.Begin("a", isA).NotFollowedBy("c", isC).FollowedBy("b", isB).Within(5 * time.Minute)
+type Pattern struct {
+	stages      []stage
+	within      time.Duration
+	maxPartials int
+}
+
+// DefaultMaxPartials limits partial matches of a key
+const DefaultMaxPartials = 1000
+
+// step is a stage of the automaton. The forbidden conditions discard partial matches waiting for the step
+type step struct {
+	name      string
+	cond      Condition
+	strict    bool
+	forbidden []Condition
+}
+
+func Begin(name string, cond Condition) *Pattern {
+	return &Pattern{
+		stages:      []stage{{name: name, cond: cond}},
+		maxPartials: DefaultMaxPartials,
+	}
+}
+
+// Next requires the stage event to follow the previous one directly (strict contiguity)
+func (p *Pattern) Next(name string, cond Condition) *Pattern {
+	p.stages = append(p.stages, stage{name: name, cond: cond, strict: true})
+	return p
+}
+
+// FollowedBy skips non matching events between the previous stage and the next matching one (relaxed contiguity)
+func (p *Pattern) FollowedBy(name string, cond Condition) *Pattern {
+	p.stages = append(p.stages, stage{name: name, cond: cond})
+	return p
+}
+
+// NotFollowedBy discards the partial match when the event matches before the next stage
+func (p *Pattern) NotFollowedBy(name string, cond Condition) *Pattern {
+	p.stages = append(p.stages, stage{name: name, cond: cond, negated: true})
+	return p
+}
+
+// Within limits the time between the first event and the last one. Partial matches, which can't complete
+// in time, are emitted as timeouts. Partial matches of patterns without the limit are kept until they match
+// or are evicted by MaxPartials
+func (p *Pattern) Within(d time.Duration) *Pattern {
+	p.within = d
+	return p
+}
+
+// MaxPartials limits partial matches of a key, DefaultMaxPartials by default. The oldest ones are dropped
+// by new ones
+func (p *Pattern) MaxPartials(n int) *Pattern {
+	p.maxPartials = n
+	return p
+}
+
+// Name joins stage names, e.g. "a->!c->b"
+func (p *Pattern) Name() string {
+	names := make([]string, len(p.stages))
+	for i, s := range p.stages {
+		names[i] = s.name
+		if s.negated {
+			names[i] = "!" + s.name
+		}
+	}
+	return strings.Join(names, "->")
+}
+
+func (p *Pattern) compile() ([]step, error) {
+	var steps []step
+	var forbidden []Condition
+	names := make(map[string]bool, len(p.stages))
+	for i, s := range p.stages {
+		if s.name == "" {
+			return nil, fmt.Errorf("stage %d has no name", i)
+		}
+		if names[s.name] {
+			return nil, fmt.Errorf("stage %s is not unique", s.name)
+		}
+		names[s.name] = true
+		if s.cond == nil {
+			return nil, fmt.Errorf("stage %s has no condition", s.name)
+		}
+		if s.negated {
+			if i == 0 {
+				return nil, fmt.Errorf("pattern can't begin with the negated stage %s", s.name)
+			}
+			forbidden = append(forbidden, s.cond)
+			continue
+		}
+		steps = append(steps, step{
+			name:      s.name,
+			cond:      s.cond,
+			strict:    s.strict,
+			forbidden: forbidden,
+		})
+		forbidden = nil
+	}
+	if len(forbidden) > 0 {
+		return nil, fmt.Errorf("pattern can't end with a negated stage")
+	}
+	if p.within < 0 {
+		return nil, fmt.Errorf("negative within %s", p.within)
+	}
+	if p.maxPartials <= 0 {
+		return nil, fmt.Errorf("max partials %d should be positive", p.maxPartials)
+	}
+	return steps, nil
+}
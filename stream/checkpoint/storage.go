@@ -0,0 +1,244 @@
+// Package checkpoint stores state snapshots of jobs, so they can be restored after restart
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("checkpoint not found")
+
+type Checkpoint struct {
+	ID  uint64 `json:"id"`
+	Job string `json:"job"`
+	// Owner is the worker, which has saved the checkpoint into the storage shared by the cluster
+	Owner string    `json:"owner,omitempty"`
+	Time  time.Time `json:"time"`
+	// State snapshots by task and state names
+	Tasks map[string]map[string][]byte `json:"tasks"`
+}
+
+type Info struct {
+	ID    uint64
+	Owner string
+	Time  time.Time
+	Size  int64
+}
+
+type Storage interface {
+	// Save stores the checkpoint under a new id, which is greater than ids of saved checkpoints, and sets cp.ID.
+	// Jobs sharing the storage never get the same id
+	Save(cp *Checkpoint) error
+	Load(id uint64) (*Checkpoint, error)
+	// List returns checkpoints ordered by id
+	List() ([]Info, error)
+}
+
+const (
+	filePrefix    = "chk-"
+	fileSuffix    = ".json"
+	reservePrefix = ".id-"
+)
+
+// dirStorage keeps every checkpoint in a JSON file. Files are written atomically, so a crash never leaves
+// a partially written checkpoint. Ids are reserved by exclusively created files, so workers sharing
+// the directory don't overwrite checkpoints of each other. The owner is a part of the file name
+type dirStorage struct {
+	dir    string
+	retain int
+}
+
+// Dir stores checkpoints in the directory and keeps the last retain of them. 0 - keep all
+func Dir(dir string, retain int) (*dirStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &dirStorage{
+		dir:    dir,
+		retain: retain,
+	}, nil
+}
+
+func (s *dirStorage) path(id uint64, owner string) string {
+	name := fmt.Sprintf("%s%020d", filePrefix, id)
+	if owner != "" {
+		name += "-" + owner
+	}
+	return filepath.Join(s.dir, name+fileSuffix)
+}
+
+func (s *dirStorage) reservation(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d", reservePrefix, id))
+}
+
+// reserve allocates the id following the saved checkpoints. Ids reserved by other jobs are skipped
+func (s *dirStorage) reserve() (uint64, error) {
+	list, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	id := uint64(1)
+	if n := len(list); n > 0 {
+		id = list[n-1].ID + 1
+	}
+	for ; ; id++ {
+		f, err := os.OpenFile(s.reservation(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return id, f.Close()
+	}
+}
+
+// validOwner keeps owners safe for file names
+func validOwner(owner string) bool {
+	for _, r := range owner {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *dirStorage) Save(cp *Checkpoint) (err error) {
+	if !validOwner(cp.Owner) {
+		return fmt.Errorf("invalid checkpoint owner %q, letters and digits are expected", cp.Owner)
+	}
+	if cp.ID, err = s.reserve(); err != nil {
+		return
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(s.dir, ".chk-*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), s.path(cp.ID, cp.Owner)); err != nil {
+		return
+	}
+	return s.cleanup(cp.Owner)
+}
+
+// cleanup keeps the last retain checkpoints of the owner. Checkpoints of other owners are removed by them,
+// so the ones they release quanta with stay until the new owners restore them
+func (s *dirStorage) cleanup(owner string) error {
+	if s.retain <= 0 {
+		return nil
+	}
+	list, err := Owned(s, owner)
+	if err != nil {
+		return err
+	}
+	for len(list) > s.retain {
+		if err = os.Remove(s.path(list[0].ID, owner)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err = os.Remove(s.reservation(list[0].ID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		list = list[1:]
+	}
+	return nil
+}
+
+func (s *dirStorage) Load(id uint64) (res *Checkpoint, err error) {
+	list, err := s.List()
+	if err != nil {
+		return
+	}
+	i := sort.Search(len(list), func(i int) bool {
+		return list[i].ID >= id
+	})
+	if i == len(list) || list[i].ID != id {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(s.path(id, list[i].Owner))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return
+	}
+	res = &Checkpoint{}
+	if err = json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("checkpoint %d is corrupted: %v", id, err)
+	}
+	return
+}
+
+func (s *dirStorage) List() (res []Info, err error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), "-", 2)
+		id, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		info := Info{ID: id, Time: f.ModTime(), Size: f.Size()}
+		if len(parts) == 2 {
+			info.Owner = parts[1]
+		}
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return
+}
+
+// Owned lists checkpoints saved by the owner
+func Owned(s Storage, owner string) (res []Info, err error) {
+	list, err := s.List()
+	if err != nil {
+		return
+	}
+	for _, info := range list {
+		if info.Owner == owner {
+			res = append(res, info)
+		}
+	}
+	return
+}
+
+// Latest loads the checkpoint of the owner with the highest id. Standalone jobs have no owner
+func Latest(s Storage, owner string) (*Checkpoint, error) {
+	list, err := Owned(s, owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Load(list[len(list)-1].ID)
+}
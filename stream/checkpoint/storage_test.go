@@ -0,0 +1,84 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glink-checkpoint")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Dir(dir, 2)
+	assert.NoError(t, err)
+
+	_, err = Latest(s, "")
+	assert.Equal(t, ErrNotFound, err)
+
+	for id := uint64(1); id <= 3; id++ {
+		cp := &Checkpoint{
+			Job:   "job",
+			Time:  time.Now(),
+			Tasks: map[string]map[string][]byte{"task": {"state": []byte{byte(id)}}},
+		}
+		assert.NoError(t, s.Save(cp))
+		assert.Equal(t, id, cp.ID)
+	}
+
+	list, err := s.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, uint64(2), list[0].ID)
+
+	_, err = s.Load(1)
+	assert.Equal(t, ErrNotFound, err)
+
+	cp, err := Latest(s, "")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), cp.ID)
+	assert.Equal(t, []byte{3}, cp.Tasks["task"]["state"])
+}
+
+func TestSharedDirStorage(t *testing.T) {
+	s1, err := Dir(t.TempDir(), 1)
+	assert.NoError(t, err)
+	s2, err := Dir(s1.dir, 1)
+	assert.NoError(t, err)
+	save := func(s *dirStorage, owner string) uint64 {
+		cp := &Checkpoint{Job: "job", Owner: owner, Time: time.Now()}
+		assert.NoError(t, s.Save(cp))
+		return cp.ID
+	}
+
+	// workers get unique ids and keep the last checkpoints of their own
+	released := save(s1, "w1")
+	assert.Equal(t, released+1, save(s2, "w2"))
+	last := save(s2, "w2")
+	list, err := s1.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	cp, err := s2.Load(released)
+	assert.NoError(t, err)
+	assert.Equal(t, "w1", cp.Owner)
+
+	cp, err = Latest(s1, "w1")
+	assert.NoError(t, err)
+	assert.Equal(t, released, cp.ID)
+	cp, err = Latest(s1, "w2")
+	assert.NoError(t, err)
+	assert.Equal(t, last, cp.ID)
+	_, err = Latest(s1, "")
+	assert.Equal(t, ErrNotFound, err)
+
+	// a reserved id isn't used again
+	f, err := os.Create(s1.reservation(last + 1))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.Equal(t, last+2, save(s1, "w1"))
+	assert.Error(t, s1.Save(&Checkpoint{Owner: "../w"}))
+}
@@ -0,0 +1,232 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/discretemind/glink/stream/field"
+)
+
+type node interface {
+	eval(vars Vars) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(Vars) (interface{}, error) {
+	return n.value, nil
+}
+
+type variable struct {
+	name string
+	path *field.Path
+}
+
+// eval returns nil for missing fields, so `payload.x == null` can test them
+func (n *variable) eval(vars Vars) (interface{}, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %s", n.name)
+	}
+	if n.path == nil {
+		return value, nil
+	}
+	res, _ := n.path.Get(value)
+	return res, nil
+}
+
+type list struct {
+	items []node
+}
+
+func (n *list) eval(vars Vars) (interface{}, error) {
+	res := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = value
+	}
+	return res, nil
+}
+
+type not struct {
+	x node
+}
+
+func (n *not) eval(vars Vars) (interface{}, error) {
+	value, err := evalBool(n.x, vars)
+	return !value, err
+}
+
+type logical struct {
+	or          bool
+	left, right node
+}
+
+func (n *logical) eval(vars Vars) (interface{}, error) {
+	left, err := evalBool(n.left, vars)
+	if err != nil || left == n.or {
+		return left, err
+	}
+	return evalBool(n.right, vars)
+}
+
+func evalBool(n node, vars Vars) (bool, error) {
+	value, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	res, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("boolean expected, got %T", value)
+	}
+	return res, nil
+}
+
+type comparison struct {
+	op          field.Operator
+	left, right node
+	regexp      *regexp.Regexp
+}
+
+func (n *comparison) eval(vars Vars) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.regexp != nil {
+		s, ok := left.(string)
+		return ok && n.regexp.MatchString(s), nil
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case field.Lt, field.Le, field.Gt, field.Ge, field.Contains, field.Matches:
+		// ordering with missing values is false like in SQL
+		if left == nil || right == nil {
+			return false, nil
+		}
+	case field.In:
+		if left == nil {
+			return false, nil
+		}
+	}
+	return field.Compare(n.op, left, right)
+}
+
+type arithmetic struct {
+	op          string
+	left, right node
+}
+
+func (n *arithmetic) eval(vars Vars) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "+" {
+		if l, ok := left.(string); ok {
+			return l + fmt.Sprint(right), nil
+		}
+	}
+
+	l, lOk := field.Number(left)
+	r, rOk := field.Number(right)
+	if !lOk || !rOk {
+		return nil, fmt.Errorf("can't apply %s to %T and %T", n.op, left, right)
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	}
+	if r == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return math.Mod(l, r), nil
+}
+
+type function struct {
+	// -1 for variadic functions
+	args int
+	call func(args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"len": {1, func(args []interface{}) (interface{}, error) {
+		v := reflect.ValueOf(args[0])
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			return float64(v.Len()), nil
+		case reflect.Invalid:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("len of %T", args[0])
+	}},
+	"lower": {1, func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("lower expects a string, got %T", args[0])
+		}
+		return strings.ToLower(s), nil
+	}},
+	"upper": {1, func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("upper expects a string, got %T", args[0])
+		}
+		return strings.ToUpper(s), nil
+	}},
+	"coalesce": {-1, func(args []interface{}) (interface{}, error) {
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}},
+}
+
+type call struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n *call) eval(vars Vars) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		value, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	res, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return res, nil
+}
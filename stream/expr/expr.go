@@ -0,0 +1,100 @@
+// Package expr evaluates small expressions like `payload.amount > 100 && payload.currency == "EUR"` over
+// struct and map payloads, so filters and projections can be defined in configuration.
+//
+// Supported are number, string, boolean, null and list literals, field paths of variables, arithmetic,
+// comparison (==, !=, <, <=, >, >=, in, contains, matches), logical operators (&&, ||, !, and, or, not)
+// and the functions len, lower, upper and coalesce
+package expr
+
+import (
+	"fmt"
+)
+
+// Vars are the variables available to an expression by name
+type Vars map[string]interface{}
+
+// Payload is the variable name of the event payload
+const Payload = "payload"
+
+type Program struct {
+	source string
+	root   node
+}
+
+func Compile(src string) (res *Program, err error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return &Program{source: src, root: root}, nil
+}
+
+func MustCompile(src string) *Program {
+	p, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+func (p *Program) Eval(vars Vars) (interface{}, error) {
+	res, err := p.root.eval(vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p.source, err)
+	}
+	return res, nil
+}
+
+// EvalPayload evaluates the expression with the payload variable
+func (p *Program) EvalPayload(payload interface{}) (interface{}, error) {
+	return p.Eval(Vars{Payload: payload})
+}
+
+// Match evaluates a boolean expression with the payload variable
+func (p *Program) Match(payload interface{}) (bool, error) {
+	res, err := p.EvalPayload(payload)
+	if err != nil {
+		return false, err
+	}
+	b, ok := res.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s: boolean expected, got %T", p.source, res)
+	}
+	return b, nil
+}
+
+// Projection evaluates expressions into a map by output field names
+type Projection map[string]*Program
+
+func CompileProjection(fields map[string]string) (res Projection, err error) {
+	res = make(Projection, len(fields))
+	for name, src := range fields {
+		if res[name], err = Compile(src); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return
+}
+
+func (p Projection) EvalPayload(payload interface{}) (res map[string]interface{}, err error) {
+	res = make(map[string]interface{}, len(p))
+	vars := Vars{Payload: payload}
+	for name, program := range p {
+		if res[name], err = program.Eval(vars); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
@@ -0,0 +1,81 @@
+package expr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type payment struct {
+	Amount   int
+	Currency string
+	Tags     []string
+}
+
+func TestEval(t *testing.T) {
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"Amount":150,"Currency":"EUR","Tags":["card"]}`), &decoded))
+	typed := &payment{Amount: 150, Currency: "EUR", Tags: []string{"card"}}
+
+	cases := map[string]interface{}{
+		`payload.Amount > 100 && payload.Currency == "EUR"`:        true,
+		`payload.Amount > 100 and not (payload.Currency == 'EUR')`: false,
+		`payload.Currency in ["USD", "EUR"]`:                       true,
+		`payload.Tags contains "card" || payload.Amount < 0`:       true,
+		`payload.Tags[0] matches "^ca"`:                            true,
+		`payload.Missing == null`:                                  true,
+		`payload.Missing > 1`:                                      false,
+		`payload.Amount * 2 + 1`:                                   float64(301),
+		`-payload.Amount % 100`:                                    float64(-50),
+		`upper(payload.Currency) + "/" + len(payload.Tags)`:        "EUR/1",
+		`coalesce(payload.Missing, "default")`:                     "default",
+	}
+	for src, expected := range cases {
+		program, err := Compile(src)
+		assert.NoError(t, err, src)
+		for _, payload := range []interface{}{decoded, typed} {
+			res, err := program.EvalPayload(payload)
+			assert.NoError(t, err, src)
+			assert.Equal(t, expected, res, src)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, src := range []string{`payload.`, `1 +`, `(1`, `"open`, `payload.a matches "("`, `unknown(1)`, `len(1, 2)`, `1 2`, `payload.a[x]`} {
+		_, err := Compile(src)
+		assert.Error(t, err, src)
+	}
+
+	_, err := MustCompile(`payload.Amount && true`).Match(payment{})
+	assert.Error(t, err)
+	_, err = MustCompile(`1 / 0`).EvalPayload(nil)
+	assert.Error(t, err)
+	_, err = MustCompile(`other.a`).EvalPayload(nil)
+	assert.Error(t, err)
+}
+
+func TestUnicode(t *testing.T) {
+	payload := map[string]interface{}{"город": "Zürich", "Größe": 3}
+	res, err := MustCompile(`payload.город == "Zürich" && payload.Größe > 2`).EvalPayload(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, true, res)
+	res, err = MustCompile(`len('日本') + payload.Größe`).EvalPayload(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(9), res, "len counts bytes of strings")
+
+	_, err = Compile("payload.a == \xff")
+	assert.Error(t, err)
+}
+
+func TestProjection(t *testing.T) {
+	p, err := CompileProjection(map[string]string{
+		"amount": "payload.Amount / 100",
+		"eur":    `payload.Currency == "EUR"`,
+	})
+	assert.NoError(t, err)
+	res, err := p.EvalPayload(payment{Amount: 250, Currency: "EUR"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"amount": 2.5, "eur": true}, res)
+}
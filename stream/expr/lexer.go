@@ -0,0 +1,101 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "."}
+
+// tokenize steps through runes of the source. Positions of tokens are byte offsets
+func tokenize(src string) (res []token, err error) {
+	pos := 0
+	for pos < len(src) {
+		c, size := utf8.DecodeRuneInString(src[pos:])
+		switch {
+		case c == utf8.RuneError && size == 1:
+			return nil, fmt.Errorf("invalid UTF-8 at %d", pos)
+		case unicode.IsSpace(c):
+			pos += size
+		case c >= '0' && c <= '9':
+			end := pos
+			for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.' || src[end] == 'e' || src[end] == 'E' ||
+				(src[end] == '-' || src[end] == '+') && (src[end-1] == 'e' || src[end-1] == 'E')) {
+				end++
+			}
+			value, err := strconv.ParseFloat(src[pos:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[pos:end], pos)
+			}
+			res = append(res, token{kind: tokenNumber, text: src[pos:end], value: value, pos: pos})
+			pos = end
+		case c == '"' || c == '\'':
+			end := pos + 1
+			for end < len(src) && src[end] != byte(c) {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", pos)
+			}
+			quoted := src[pos : end+1]
+			if c == '\'' {
+				quoted = "\"" + strings.ReplaceAll(strings.ReplaceAll(src[pos+1:end], "\\'", "'"), "\"", "\\\"") + "\""
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", pos)
+			}
+			res = append(res, token{kind: tokenString, text: src[pos : end+1], value: value, pos: pos})
+			pos = end + 1
+		case c == '_' || unicode.IsLetter(c):
+			end := pos
+			for end < len(src) {
+				r, n := utf8.DecodeRuneInString(src[end:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += n
+			}
+			res = append(res, token{kind: tokenIdent, text: src[pos:end], pos: pos})
+			pos = end
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[pos:], op) {
+					res = append(res, token{kind: tokenOperator, text: op, pos: pos})
+					pos += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, pos)
+			}
+		}
+	}
+	res = append(res, token{kind: tokenEOF, pos: pos})
+	return
+}
@@ -0,0 +1,293 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/discretemind/glink/stream/field"
+)
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it's one of the operators or keywords
+func (p *parser) accept(texts ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return "", false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		return p.errorf("'%s' expected", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := t.text
+	if t.kind == tokenEOF {
+		found = "end of expression"
+	}
+	return fmt.Errorf("%s at %d near %q in %q", fmt.Sprintf(format, args...), t.pos, found, p.src)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{or: true, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept("!", "not"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "in", "contains", "matches")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	c := &comparison{op: field.Operator(op), left: left, right: right}
+	if l, ok := right.(*literal); ok && c.op == field.Matches {
+		pattern, ok := l.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("matches expects a string pattern in %q", p.src)
+		}
+		if c.regexp, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern in %q: %v", p.src, err)
+		}
+	}
+	return c, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmetic{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmetic{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.accept("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &arithmetic{op: "-", left: &literal{value: float64(0)}, right: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		p.next()
+		return &literal{value: t.value}, nil
+	case tokenIdent:
+		p.next()
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null", "nil":
+			return &literal{}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t.text)
+		}
+		return p.parseVariable(t.text)
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			p.next()
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			p.next()
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &list{items: items}, nil
+		}
+	}
+	return nil, p.errorf("unexpected token")
+}
+
+func (p *parser) parseList(end string) (items []node, err error) {
+	if _, ok := p.accept(end); ok {
+		return
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if _, ok := p.accept(end); ok {
+			return items, nil
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s in %q", name, p.src)
+	}
+	args, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+	if fn.args >= 0 && len(args) != fn.args {
+		return nil, fmt.Errorf("%s expects %d arguments in %q", name, fn.args, p.src)
+	}
+	return &call{name: name, fn: fn.call, args: args}, nil
+}
+
+func (p *parser) parseVariable(name string) (node, error) {
+	path := strings.Builder{}
+	for {
+		if _, ok := p.accept("."); ok {
+			t := p.next()
+			if t.kind != tokenIdent {
+				p.pos--
+				return nil, p.errorf("field name expected")
+			}
+			if path.Len() > 0 {
+				path.WriteByte('.')
+			}
+			path.WriteString(t.text)
+			continue
+		}
+		if _, ok := p.accept("["); ok {
+			t := p.next()
+			if t.kind != tokenNumber {
+				p.pos--
+				return nil, p.errorf("index expected")
+			}
+			path.WriteString("[" + strconv.Itoa(int(t.value.(float64))) + "]")
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		break
+	}
+
+	v := &variable{name: name}
+	if path.Len() > 0 {
+		compiled, err := field.Compile(path.String())
+		if err != nil {
+			return nil, err
+		}
+		v.path = compiled
+	}
+	return v, nil
+}
@@ -0,0 +1,212 @@
+package field
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Operator string
+
+const (
+	Eq       Operator = "=="
+	Ne       Operator = "!="
+	Lt       Operator = "<"
+	Le       Operator = "<="
+	Gt       Operator = ">"
+	Ge       Operator = ">="
+	In       Operator = "in"
+	Contains Operator = "contains"
+	Matches  Operator = "matches"
+)
+
+// Condition compares the value of a path with the operand
+type Condition struct {
+	path    *Path
+	op      Operator
+	operand interface{}
+	regexp  *regexp.Regexp
+}
+
+func NewCondition(path string, op Operator, operand interface{}) (res *Condition, err error) {
+	p, err := Compile(path)
+	if err != nil {
+		return
+	}
+	res = &Condition{
+		path:    p,
+		op:      op,
+		operand: operand,
+	}
+
+	switch op {
+	case Eq, Ne, Lt, Le, Gt, Ge, Contains:
+	case In:
+		if k := reflect.ValueOf(operand).Kind(); k != reflect.Slice && k != reflect.Array {
+			return nil, fmt.Errorf("%s: operand of 'in' should be a slice, got %T", path, operand)
+		}
+	case Matches:
+		switch r := operand.(type) {
+		case *regexp.Regexp:
+			res.regexp = r
+		case string:
+			if res.regexp, err = regexp.Compile(r); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: operand of 'matches' should be a regular expression, got %T", path, operand)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown operator %q", path, op)
+	}
+	return
+}
+
+// Match resolves the path on the value and compares it with the operand. Missing fields never match
+func (c *Condition) Match(value interface{}) bool {
+	v, ok := c.path.Get(value)
+	if !ok {
+		return false
+	}
+	if c.regexp != nil {
+		s, ok := v.(string)
+		return ok && c.regexp.MatchString(s)
+	}
+	res, _ := Compare(c.op, v, c.operand)
+	return res
+}
+
+// Compare applies the operator. Numbers of different types are compared by value,
+// so the condition works for both struct payloads and decoded JSON
+func Compare(op Operator, left, right interface{}) (bool, error) {
+	switch op {
+	case Eq:
+		return Equal(left, right), nil
+	case Ne:
+		return !Equal(left, right), nil
+	case Lt, Le, Gt, Ge:
+		c, ok := Order(left, right)
+		if !ok {
+			return false, fmt.Errorf("can't compare %T and %T", left, right)
+		}
+		switch op {
+		case Lt:
+			return c < 0, nil
+		case Le:
+			return c <= 0, nil
+		case Gt:
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case In:
+		return contains(right, left)
+	case Contains:
+		return contains(left, right)
+	case Matches:
+		s, ok := left.(string)
+		pattern, pOk := right.(string)
+		if !ok || !pOk {
+			return false, fmt.Errorf("matches expects strings, got %T and %T", left, right)
+		}
+		return regexp.MatchString(pattern, s)
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+func Equal(left, right interface{}) bool {
+	if l, ok := number(left); ok {
+		if r, ok := number(right); ok {
+			return l == r
+		}
+		return false
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// Order returns -1, 0 or 1 for numbers, strings and times
+func Order(left, right interface{}) (int, bool) {
+	if l, ok := number(left); ok {
+		r, ok := number(right)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l, r), true
+	case time.Time:
+		r, ok := right.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l.Before(r):
+			return -1, true
+		case l.After(r):
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Number converts integers and floats to float64
+func Number(value interface{}) (float64, bool) {
+	return number(value)
+}
+
+func number(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// contains checks substring of a string, element of a slice or key of a map
+func contains(container, element interface{}) (bool, error) {
+	if s, ok := container.(string); ok {
+		sub, ok := element.(string)
+		if !ok {
+			return false, fmt.Errorf("can't search %T in string", element)
+		}
+		return strings.Contains(s, sub), nil
+	}
+
+	v := indirect(reflect.ValueOf(container))
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if Equal(v.Index(i).Interface(), element) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if Equal(k.Interface(), element) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("%T is not a container", container)
+}
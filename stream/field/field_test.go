@@ -0,0 +1,95 @@
+package field
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type address struct {
+	Country string
+}
+
+type user struct {
+	Name    string
+	Address *address
+	Tags    []string
+	Age     int
+	secret  string
+}
+
+type event struct {
+	User user
+}
+
+func TestPath(t *testing.T) {
+	e := &event{User: user{Name: "a", Address: &address{"DE"}, Tags: []string{"x", "y"}, secret: "s"}}
+
+	value, ok := MustCompile("User.Address.Country").Get(e)
+	assert.True(t, ok)
+	assert.Equal(t, "DE", value)
+
+	value, ok = MustCompile("User.Tags[1]").Get(e)
+	assert.True(t, ok)
+	assert.Equal(t, "y", value)
+
+	for _, path := range []string{"User.secret", "User.Missing", "User.Tags[5]", "User.Name.Length"} {
+		_, ok = MustCompile(path).Get(e)
+		assert.False(t, ok, path)
+	}
+
+	_, ok = MustCompile("User.Address.Country").Get(event{})
+	assert.False(t, ok)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"user":{"tags":["a","b"],"age":30}}`), &decoded))
+	value, ok = MustCompile("user.tags[0]").Get(decoded)
+	assert.True(t, ok)
+	assert.Equal(t, "a", value)
+
+	for _, path := range []string{"", ".a", "a..b", "a[", "a[-1]", "a[x]"} {
+		_, err := Compile(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestCondition(t *testing.T) {
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"user":{"name":"bob","tags":["vip"],"age":30}}`), &decoded))
+	typed := user{Name: "bob", Tags: []string{"vip"}, Age: 30}
+
+	cases := []struct {
+		path    string
+		op      Operator
+		operand interface{}
+		match   bool
+	}{
+		{"age", Eq, 30, true},
+		{"age", Ne, 30, false},
+		{"age", Lt, 31, true},
+		{"age", Ge, 30.5, false},
+		{"name", In, []string{"alice", "bob"}, true},
+		{"tags", Contains, "vip", true},
+		{"name", Contains, "ob", true},
+		{"name", Matches, "^b.b$", true},
+		{"missing", Ne, 1, false},
+	}
+	for _, c := range cases {
+		cond, err := NewCondition("user."+c.path, c.op, c.operand)
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, cond.Match(decoded), "map %s %s %v", c.path, c.op, c.operand)
+
+		structPath := map[string]string{"age": "Age", "name": "Name", "tags": "Tags", "missing": "Missing"}[c.path]
+		cond, err = NewCondition(structPath, c.op, c.operand)
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, cond.Match(typed), "struct %s %s %v", c.path, c.op, c.operand)
+	}
+
+	_, err := NewCondition("a", "~", 1)
+	assert.Error(t, err)
+	_, err = NewCondition("a", Matches, "(")
+	assert.Error(t, err)
+	_, err = NewCondition("a", In, 1)
+	assert.Error(t, err)
+}
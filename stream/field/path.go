@@ -0,0 +1,148 @@
+// Package field resolves path expressions like `User.Address.Country` or `tags[0]` on struct and map payloads
+package field
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type segment struct {
+	name  string
+	index int
+	// struct field indexes by the struct type
+	fields sync.Map
+}
+
+// Path is a compiled path expression. Struct field lookups are cached per payload type, so it's cheap to
+// resolve the same path on many values. Path is safe for concurrent use
+type Path struct {
+	source   string
+	segments []*segment
+}
+
+func Compile(path string) (res *Path, err error) {
+	res = &Path{source: path}
+	rest := path
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			if len(res.segments) == 0 {
+				return nil, fmt.Errorf("invalid path %q: unexpected '.'", path)
+			}
+			rest = rest[1:]
+			if len(rest) == 0 || rest[0] == '.' || rest[0] == '[' {
+				return nil, fmt.Errorf("invalid path %q: field name expected", path)
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: ']' expected", path)
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path %q: invalid index %q", path, rest[1:end])
+			}
+			res.segments = append(res.segments, &segment{index: index})
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			res.segments = append(res.segments, &segment{name: rest[:end], index: -1})
+			rest = rest[end:]
+		}
+	}
+	if len(res.segments) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return
+}
+
+func MustCompile(path string) *Path {
+	p, err := Compile(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *Path) String() string {
+	return p.source
+}
+
+// Get resolves the path on the value. ok is false when any part of the path is missing, nil or not exported
+func (p *Path) Get(value interface{}) (res interface{}, ok bool) {
+	v := reflect.ValueOf(value)
+	for _, s := range p.segments {
+		if v, ok = s.get(v); !ok {
+			return nil, false
+		}
+	}
+	v = indirect(v)
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func (s *segment) get(v reflect.Value) (reflect.Value, bool) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return v, false
+	}
+
+	if s.index >= 0 {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array, reflect.String:
+			if s.index >= v.Len() {
+				return reflect.Value{}, false
+			}
+			return v.Index(s.index), true
+		}
+		return reflect.Value{}, false
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		index, ok := s.fieldIndex(v.Type())
+		if !ok {
+			return reflect.Value{}, false
+		}
+		res, err := v.FieldByIndexErr(index)
+		return res, err == nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+		res := v.MapIndex(reflect.ValueOf(s.name).Convert(v.Type().Key()))
+		return res, res.IsValid()
+	}
+	return reflect.Value{}, false
+}
+
+func (s *segment) fieldIndex(t reflect.Type) ([]int, bool) {
+	if cached, ok := s.fields.Load(t); ok {
+		index := cached.([]int)
+		return index, index != nil
+	}
+	var index []int
+	if f, ok := t.FieldByName(s.name); ok && f.PkgPath == "" {
+		index = f.Index
+	}
+	s.fields.Store(t, index)
+	return index, index != nil
+}
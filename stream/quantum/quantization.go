@@ -1,55 +1,214 @@
+// Package quantum splits the key hash space into quanta. The master assigns quanta to workers, and
+// every worker processes keys of its quanta only
 package quantum
 
 import (
-	"github.com/discretemind/glink/utils/crypto"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
 	"sync"
+
+	"github.com/discretemind/glink/utils/crypto"
 )
 
 type Quantum struct {
 	Index uint32
-	Space  uint32 //Quantum space
+	Space uint32 //Quantum space
+}
+
+// Of maps the key to the quantum of the space by the hash of its JSON encoding
+func Of(key interface{}, space uint32) (res Quantum, err error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return
+	}
+	return OfEncoded(data, space), nil
+}
+
+// OfEncoded maps the JSON encoded key, which keyed states are indexed by
+func OfEncoded(key []byte, space uint32) Quantum {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return Quantum{Index: uint32(h.Sum64() % uint64(space)), Space: space}
+}
+
+// Contains returns the filter of JSON encoded keys of the quanta
+func Contains(quanta []Quantum) func(key string) bool {
+	indexes := make(map[uint32]bool, len(quanta))
+	var space uint32
+	for _, q := range quanta {
+		indexes[q.Index] = true
+		space = q.Space
+	}
+	return func(key string) bool {
+		return space > 0 && indexes[OfEncoded([]byte(key), space).Index]
+	}
+}
+
+// IPool tracks owners of quanta on the master
+type IPool interface {
+	Size() uint32
+	Resize(size uint32) error
+	Assign(owner crypto.Certificate, n int) []Quantum
+	AssignQuantum(owner crypto.Certificate, q Quantum) error
+	Release(owner crypto.Certificate, q Quantum) error
+	ReleaseAll(owner crypto.Certificate) []Quantum
+	Owner(index uint32) (crypto.Certificate, bool)
+	Assigned(owner crypto.Certificate) []Quantum
+	Free() int
 }
 
 type quantumPool struct {
 	sync.RWMutex
 	size     uint32
 	free     []*Quantum
-	assigned map[crypto.Certificate]*Quantum
+	assigned map[crypto.Certificate][]*Quantum
 }
 
 func Pool(size uint32) (res *quantumPool) {
 	res = &quantumPool{
-		size: size,
+		assigned: make(map[crypto.Certificate][]*Quantum),
 	}
+	_ = res.Resize(size)
 	return res
 }
 
-func (p *quantumPool) releaseQuantum(q *Quantum) {
+func (p *quantumPool) Size() uint32 {
+	p.RLock()
+	defer p.RUnlock()
+	return p.size
+}
 
+// releaseQuantum returns the quantum to the free ones
+func (p *quantumPool) releaseQuantum(q *Quantum) {
+	i := sort.Search(len(p.free), func(i int) bool {
+		return p.free[i].Index >= q.Index
+	})
+	p.free = append(p.free, nil)
+	copy(p.free[i+1:], p.free[i:])
+	p.free[i] = q
 }
 
 func (p *quantumPool) issueQuantum(index uint32, size uint32) *Quantum {
 	return &Quantum{
 		Index: index,
-		Space:  size,
+		Space: size,
 	}
 }
 
-func (p *quantumPool) Resize(size uint32) {
+// Resize splits the key space into another number of quanta. Keys map to other quanta then,
+// so all quanta must be released before
+func (p *quantumPool) Resize(size uint32) error {
+	p.Lock()
+	defer p.Unlock()
 	if p.size == size {
-		return
+		return nil
 	}
-	p.Lock()
-	for _, q := range p.free {
-		p.releaseQuantum(q)
+	if len(p.assigned) > 0 {
+		return fmt.Errorf("can't resize the space with assigned quanta")
 	}
 
+	p.size = size
+	p.free = make([]*Quantum, 0, size)
 	var i uint32
 	for i < size {
-		q := p.issueQuantum(i, size)
-		p.free = append(p.free, q)
+		p.free = append(p.free, p.issueQuantum(i, size))
 		i++
 	}
+	return nil
+}
+
+// Assign gives up to n free quanta with the lowest indexes to the owner
+func (p *quantumPool) Assign(owner crypto.Certificate, n int) (res []Quantum) {
+	p.Lock()
+	defer p.Unlock()
+	if n > len(p.free) {
+		n = len(p.free)
+	}
+	for _, q := range p.free[:n] {
+		res = append(res, *q)
+	}
+	p.assigned[owner] = append(p.assigned[owner], p.free[:n]...)
+	p.free = p.free[n:]
+	return
+}
+
+// AssignQuantum gives the free quantum to the owner
+func (p *quantumPool) AssignQuantum(owner crypto.Certificate, q Quantum) error {
+	p.Lock()
+	defer p.Unlock()
+	for i, f := range p.free {
+		if *f == q {
+			p.assigned[owner] = append(p.assigned[owner], f)
+			p.free = append(p.free[:i], p.free[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("quantum %d is not free", q.Index)
+}
+
+// Release returns the quantum of the owner to the free ones
+func (p *quantumPool) Release(owner crypto.Certificate, q Quantum) error {
+	p.Lock()
+	defer p.Unlock()
+	owned := p.assigned[owner]
+	for i, o := range owned {
+		if *o == q {
+			p.releaseQuantum(o)
+			owned = append(owned[:i], owned[i+1:]...)
+			if len(owned) == 0 {
+				delete(p.assigned, owner)
+			} else {
+				p.assigned[owner] = owned
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("quantum %d is not assigned to %s", q.Index, owner)
+}
+
+// ReleaseAll returns all quanta of the owner, e.g. when it has left the cluster
+func (p *quantumPool) ReleaseAll(owner crypto.Certificate) (res []Quantum) {
+	p.Lock()
+	defer p.Unlock()
+	for _, q := range p.assigned[owner] {
+		res = append(res, *q)
+		p.releaseQuantum(q)
+	}
+	delete(p.assigned, owner)
+	return
+}
+
+// Owner of the quantum. ok is false for free quanta
+func (p *quantumPool) Owner(index uint32) (owner crypto.Certificate, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+	for o, owned := range p.assigned {
+		for _, q := range owned {
+			if q.Index == index {
+				return o, true
+			}
+		}
+	}
+	return
+}
+
+// Assigned returns quanta of the owner ordered by index
+func (p *quantumPool) Assigned(owner crypto.Certificate) (res []Quantum) {
+	p.RLock()
+	defer p.RUnlock()
+	for _, q := range p.assigned[owner] {
+		res = append(res, *q)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Index < res[j].Index
+	})
+	return
+}
 
-	p.Unlock()
+func (p *quantumPool) Free() int {
+	p.RLock()
+	defer p.RUnlock()
+	return len(p.free)
 }
@@ -0,0 +1,122 @@
+package quantum
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/discretemind/glink/utils/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	p := Pool(8)
+	a, b := crypto.GeneratePrivateKey().Certificate(), crypto.GeneratePrivateKey().Certificate()
+
+	assert.Equal(t, []Quantum{{0, 8}, {1, 8}, {2, 8}}, p.Assign(a, 3))
+	assert.Len(t, p.Assign(b, 10), 5)
+	assert.Equal(t, 0, p.Free())
+
+	owner, ok := p.Owner(1)
+	assert.True(t, ok)
+	assert.Equal(t, a, owner)
+
+	assert.NoError(t, p.Release(a, Quantum{1, 8}))
+	assert.Error(t, p.Release(a, Quantum{1, 8}))
+	assert.Equal(t, []Quantum{{0, 8}, {2, 8}}, p.Assigned(a))
+	assert.Equal(t, []Quantum{{1, 8}}, p.Assign(b, 1))
+	assert.Error(t, p.AssignQuantum(a, Quantum{1, 8}), "quantum is not free")
+	assert.NoError(t, p.Release(b, Quantum{1, 8}))
+	assert.NoError(t, p.AssignQuantum(a, Quantum{1, 8}))
+	assert.Equal(t, []Quantum{{0, 8}, {1, 8}, {2, 8}}, p.Assigned(a))
+
+	assert.Error(t, p.Resize(4), "quanta are assigned")
+	p.ReleaseAll(a)
+	p.ReleaseAll(b)
+	assert.Equal(t, 8, p.Free())
+	assert.NoError(t, p.Resize(4))
+	assert.Equal(t, 4, p.Free())
+	assert.Equal(t, []Quantum{{0, 4}}, p.Assign(a, 1))
+}
+
+func TestKeys(t *testing.T) {
+	counts := make([]int, 16)
+	for i := 0; i < 16000; i++ {
+		q, err := Of(fmt.Sprintf("key-%d", i), 16)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(16), q.Space)
+		counts[q.Index]++
+	}
+	for _, c := range counts {
+		assert.InDelta(t, 1000, c, 150)
+	}
+	q1, _ := Of("same", 16)
+	q2, _ := Of("same", 16)
+	assert.Equal(t, q1, q2)
+}
+
+func TestSetRelease(t *testing.T) {
+	s := NewSet()
+	var released []Quantum
+	s.OnRelease(func(q Quantum) {
+		released = append(released, q)
+	})
+	assert.NoError(t, s.Assign(Quantum{0, 4}, Quantum{1, 4}))
+	assert.Error(t, s.Assign(Quantum{0, 8}))
+
+	q, ok := s.Owns("a")
+	expected, _ := Of("a", 4)
+	assert.Equal(t, expected.Index < 2, ok)
+	assert.Equal(t, expected, q)
+
+	// a quantum with an open window is released after the window closes
+	s.Hold(Quantum{0, 4})
+	s.Release(Quantum{0, 4})
+	s.Release(Quantum{1, 4})
+	assert.Equal(t, []Quantum{{1, 4}}, released)
+	assert.Equal(t, map[uint32]uint32{0: 1}, s.Status())
+
+	s.Done(Quantum{0, 4})
+	assert.Equal(t, []Quantum{{1, 4}, {0, 4}}, released)
+	assert.Empty(t, s.Quanta())
+}
+
+func TestSetPrepare(t *testing.T) {
+	s := NewSet()
+	var assigned []Quantum
+	s.OnAssign(func(quanta []Quantum) {
+		assigned = append(assigned, quanta...)
+	})
+	assert.NoError(t, s.Prepare(Quantum{1, 4}))
+	key := "a"
+	for q, _ := Of(key, 4); q.Index != 1; q, _ = Of(key, 4) {
+		key += "a"
+	}
+	_, ownership := s.Lookup(key)
+	assert.Equal(t, Pending, ownership)
+
+	// state restored before the assignment holds the quantum
+	s.Hold(Quantum{1, 4})
+	assert.NoError(t, s.Assign(Quantum{1, 4}))
+	_, ownership = s.Lookup(key)
+	assert.Equal(t, Owned, ownership)
+	assert.Equal(t, []Quantum{{1, 4}}, assigned)
+	assert.Equal(t, map[uint32]uint32{1: 1}, s.Status())
+
+	assert.True(t, Contains([]Quantum{{1, 4}})(`"`+key+`"`))
+	assert.False(t, Contains([]Quantum{{0, 4}})(`"`+key+`"`))
+}
+
+func TestSetDrop(t *testing.T) {
+	s := NewSet()
+	released := 0
+	s.OnRelease(func(q Quantum) {
+		released++
+	})
+	assert.NoError(t, s.Assign(Quantum{0, 4}, Quantum{1, 4}))
+	assert.NoError(t, s.Prepare(Quantum{2, 4}))
+	s.Hold(Quantum{0, 4})
+	s.Drop(Quantum{0, 4}, Quantum{2, 4})
+	assert.Equal(t, []Quantum{{1, 4}}, s.Quanta())
+	s.Done(Quantum{0, 4})
+	assert.Equal(t, 0, released, "dropped quanta aren't released")
+}
@@ -0,0 +1,239 @@
+package quantum
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Ownership of a key by the worker
+type Ownership uint8
+
+const (
+	NotOwned Ownership = iota
+	// Pending quanta are moving to the worker. Their keys are paused until the quanta are assigned
+	Pending
+	Owned
+	// Unassigned keys come before the first assignment, while the space of quanta isn't known.
+	// They wait for it as pending ones
+	Unassigned
+)
+
+type quantumState struct {
+	holds     uint32
+	releasing bool
+}
+
+// Set is quanta assigned to a worker. Operators hold quanta with open windows, so a quantum is released
+// only after its windows are closed
+type Set struct {
+	sync.Mutex
+	space     uint32
+	owned     map[uint32]*quantumState
+	pending   map[uint32]*quantumState
+	onRelease []func(q Quantum)
+	onAssign  []func(quanta []Quantum)
+}
+
+func NewSet() *Set {
+	return &Set{
+		owned:   make(map[uint32]*quantumState),
+		pending: make(map[uint32]*quantumState),
+	}
+}
+
+// OnRelease adds the listener called, when a quantum requested to release has no holds
+func (s *Set) OnRelease(f func(q Quantum)) {
+	s.Lock()
+	defer s.Unlock()
+	s.onRelease = append(s.onRelease, f)
+}
+
+// OnAssign adds the listener called, when quanta are assigned
+func (s *Set) OnAssign(f func(quanta []Quantum)) {
+	s.Lock()
+	defer s.Unlock()
+	s.onAssign = append(s.onAssign, f)
+}
+
+func (s *Set) Space() uint32 {
+	s.Lock()
+	defer s.Unlock()
+	return s.space
+}
+
+// checkSpace is called locked. An empty set takes the space of the quantum
+func (s *Set) checkSpace(q Quantum) error {
+	if len(s.owned) == 0 && len(s.pending) == 0 {
+		s.space = q.Space
+	}
+	if q.Space != s.space || q.Index >= q.Space {
+		return fmt.Errorf("quantum %d of space %d doesn't match space %d", q.Index, q.Space, s.space)
+	}
+	return nil
+}
+
+// Prepare pauses keys of quanta moving to the worker. Their state restored meanwhile may hold them
+func (s *Set) Prepare(quanta ...Quantum) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, q := range quanta {
+		if err := s.checkSpace(q); err != nil {
+			return err
+		}
+		_, owned := s.owned[q.Index]
+		if _, ok := s.pending[q.Index]; !ok && !owned {
+			s.pending[q.Index] = &quantumState{}
+		}
+	}
+	return nil
+}
+
+// Assign adds quanta of the same space and resumes their paused keys
+func (s *Set) Assign(quanta ...Quantum) error {
+	s.Lock()
+	for _, q := range quanta {
+		if err := s.checkSpace(q); err != nil {
+			s.Unlock()
+			return err
+		}
+		if st, ok := s.owned[q.Index]; ok {
+			st.releasing = false
+			continue
+		}
+		st, ok := s.pending[q.Index]
+		if !ok {
+			st = &quantumState{}
+		}
+		delete(s.pending, q.Index)
+		s.owned[q.Index] = st
+	}
+	listeners := append([]func(quanta []Quantum){}, s.onAssign...)
+	s.Unlock()
+
+	for _, f := range listeners {
+		f(quanta)
+	}
+	return nil
+}
+
+// Lookup finds the quantum of the key and whether it's owned by the worker.
+// Keys of quanta being released are still owned
+func (s *Set) Lookup(key interface{}) (q Quantum, ownership Ownership) {
+	space := s.Space()
+	if space == 0 {
+		return q, Unassigned
+	}
+	q, err := Of(key, space)
+	if err != nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.owned[q.Index]; ok {
+		return q, Owned
+	}
+	if _, ok := s.pending[q.Index]; ok {
+		return q, Pending
+	}
+	return q, NotOwned
+}
+
+// Owns tells whether the key belongs to the set
+func (s *Set) Owns(key interface{}) (q Quantum, ok bool) {
+	q, ownership := s.Lookup(key)
+	return q, ownership == Owned
+}
+
+// Hold delays the release of the owned or pending quantum until Done
+func (s *Set) Hold(q Quantum) {
+	s.Lock()
+	defer s.Unlock()
+	if q.Space != s.space {
+		return
+	}
+	if st, ok := s.owned[q.Index]; ok {
+		st.holds++
+	} else if st, ok = s.pending[q.Index]; ok {
+		st.holds++
+	}
+}
+
+func (s *Set) Done(q Quantum) {
+	s.Lock()
+	if st, ok := s.pending[q.Index]; ok && q.Space == s.space && st.holds > 0 {
+		st.holds--
+		s.Unlock()
+		return
+	}
+	st, ok := s.owned[q.Index]
+	if !ok || q.Space != s.space || st.holds == 0 {
+		s.Unlock()
+		return
+	}
+	st.holds--
+	s.releaseIfFree(q.Index, st)
+}
+
+// Release removes the quantum once it has no holds
+func (s *Set) Release(q Quantum) {
+	s.Lock()
+	st, ok := s.owned[q.Index]
+	if !ok || q.Space != s.space {
+		s.Unlock()
+		return
+	}
+	st.releasing = true
+	s.releaseIfFree(q.Index, st)
+}
+
+// Drop removes owned and pending quanta at once without release listeners, since other workers own them
+func (s *Set) Drop(quanta ...Quantum) {
+	s.Lock()
+	defer s.Unlock()
+	for _, q := range quanta {
+		if q.Space == s.space {
+			delete(s.owned, q.Index)
+			delete(s.pending, q.Index)
+		}
+	}
+}
+
+// releaseIfFree is called locked and unlocks the set
+func (s *Set) releaseIfFree(index uint32, st *quantumState) {
+	if !st.releasing || st.holds > 0 {
+		s.Unlock()
+		return
+	}
+	delete(s.owned, index)
+	q := Quantum{Index: index, Space: s.space}
+	listeners := append([]func(q Quantum){}, s.onRelease...)
+	s.Unlock()
+	for _, f := range listeners {
+		f(q)
+	}
+}
+
+// Quanta returns owned quanta ordered by index
+func (s *Set) Quanta() (res []Quantum) {
+	s.Lock()
+	defer s.Unlock()
+	for index := range s.owned {
+		res = append(res, Quantum{Index: index, Space: s.space})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Index < res[j].Index
+	})
+	return
+}
+
+// Status returns holds of owned quanta by their index
+func (s *Set) Status() map[uint32]uint32 {
+	s.Lock()
+	defer s.Unlock()
+	res := make(map[uint32]uint32, len(s.owned))
+	for index, st := range s.owned {
+		res[index] = st.holds
+	}
+	return res
+}
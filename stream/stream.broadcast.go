@@ -0,0 +1,235 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// BroadcastStateDescriptor names the broadcast state. Value is a sample of the state values type,
+// so they are restored from checkpoints with their own type instead of decoded JSON
+type BroadcastStateDescriptor struct {
+	Name  string
+	Value interface{}
+}
+
+// BroadcastStream delivers every element to all operators connected to it
+type BroadcastStream struct {
+	source     *DataStream
+	descriptor BroadcastStateDescriptor
+}
+
+type IReadOnlyBroadcastState interface {
+	Get(key string) (interface{}, bool)
+	// Range iterates the state until f returns false
+	Range(f func(key string, value interface{}) bool)
+}
+
+type IBroadcastState interface {
+	IReadOnlyBroadcastState
+	Put(key string, value interface{})
+	Remove(key string)
+}
+
+// ReadOnlyBroadcastContext is given to data elements, which can only read the broadcast state
+type ReadOnlyBroadcastContext struct {
+	Timestamp time.Time
+	// Key of the element on keyed streams
+	Key   interface{}
+	State IReadOnlyBroadcastState
+}
+
+// BroadcastContext is given to broadcast elements, which update the broadcast state
+type BroadcastContext struct {
+	Timestamp time.Time
+	State     IBroadcastState
+}
+
+type BroadcastProcessFunction struct {
+	ProcessElement          func(value interface{}, ctx ReadOnlyBroadcastContext, out func(value interface{})) error
+	ProcessBroadcastElement func(value interface{}, ctx BroadcastContext, out func(value interface{})) error
+}
+
+type BroadcastConnectedStream struct {
+	data      *DataStream
+	keyed     *KeyedStream
+	broadcast *BroadcastStream
+}
+
+func (s *DataStream) Broadcast(descriptor BroadcastStateDescriptor) *BroadcastStream {
+	return &BroadcastStream{
+		source:     s,
+		descriptor: descriptor,
+	}
+}
+
+func (s *DataStream) Connect(b *BroadcastStream) *BroadcastConnectedStream {
+	return &BroadcastConnectedStream{
+		data:      s,
+		broadcast: b,
+	}
+}
+
+func (s *KeyedStream) Connect(b *BroadcastStream) *BroadcastConnectedStream {
+	return &BroadcastConnectedStream{
+		data:      s.DataStream,
+		keyed:     s,
+		broadcast: b,
+	}
+}
+
+// Process creates the operator. Every operator has its own copy of the broadcast state, which is
+// included in checkpoints of the data stream context as broadcast/<descriptor name>/<number>
+func (c *BroadcastConnectedStream) Process(f BroadcastProcessFunction) *DataStream {
+	result := newStream(c.data.Context())
+	state := &broadcastState{
+		values:    make(map[string]interface{}),
+		valueType: reflect.TypeOf(c.broadcast.descriptor.Value),
+	}
+	result.ctx.RegisterOperatorState("broadcast/"+c.broadcast.descriptor.Name, state)
+
+	process := func(event *Event, handler func(out func(value interface{})) error) {
+		m := result.Metrics()
+		m.In()
+		start := time.Now()
+		err := handler(func(value interface{}) {
+			m.Out()
+			out := &Event{Timestamp: event.Timestamp, Payload: value}
+			for _, o := range result.outs {
+				o(out)
+			}
+		})
+		m.Latency(time.Since(start))
+		if err != nil {
+			m.Error()
+			for _, o := range result.faults {
+				o(event)
+			}
+		}
+	}
+
+	c.data.BindOut(func(event *Event) {
+		if f.ProcessElement == nil {
+			return
+		}
+		ctx := ReadOnlyBroadcastContext{
+			Timestamp: event.Timestamp,
+			State:     readOnlyState{state},
+		}
+		if c.keyed != nil {
+			ctx.Key = c.keyed.Key(event)
+		}
+		process(event, func(out func(value interface{})) error {
+			return f.ProcessElement(event.Payload, ctx, out)
+		})
+	})
+
+	c.broadcast.source.BindOut(func(event *Event) {
+		if f.ProcessBroadcastElement == nil {
+			return
+		}
+		ctx := BroadcastContext{
+			Timestamp: event.Timestamp,
+			State:     state,
+		}
+		// broadcast elements of another task are serialized with events and snapshots of the data context
+		if c.broadcast.source.Context() != result.ctx {
+			result.ctx.processing.Lock()
+			defer result.ctx.processing.Unlock()
+		}
+		process(event, func(out func(value interface{})) error {
+			return f.ProcessBroadcastElement(event.Payload, ctx, out)
+		})
+	})
+	return result
+}
+
+type broadcastState struct {
+	sync.RWMutex
+	values    map[string]interface{}
+	valueType reflect.Type
+}
+
+func (s *broadcastState) Get(key string) (value interface{}, ok bool) {
+	s.RLock()
+	defer s.RUnlock()
+	value, ok = s.values[key]
+	return
+}
+
+func (s *broadcastState) Range(f func(key string, value interface{}) bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for k, v := range s.values {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+func (s *broadcastState) Put(key string, value interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	s.values[key] = value
+}
+
+func (s *broadcastState) Remove(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.values, key)
+}
+
+func (s *broadcastState) Snapshot() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return json.Marshal(s.values)
+}
+
+func (s *broadcastState) Restore(data []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	values := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		value, err := decodeValue(v, s.valueType)
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+		values[k] = value
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.values = values
+	return nil
+}
+
+// decodeValue decodes JSON into a value of the type or into interface{} when the type is unknown
+func decodeValue(data []byte, t reflect.Type) (interface{}, error) {
+	if t == nil {
+		var value interface{}
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+	ptr := reflect.New(t)
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// readOnlyState hides the write methods from data elements
+type readOnlyState struct {
+	state *broadcastState
+}
+
+func (s readOnlyState) Get(key string) (interface{}, bool) {
+	return s.state.Get(key)
+}
+
+func (s readOnlyState) Range(f func(key string, value interface{}) bool) {
+	s.state.Range(f)
+}
@@ -0,0 +1,131 @@
+package stream_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/glinktest"
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+type threshold struct {
+	Limit int
+}
+
+func rules() stream.BroadcastProcessFunction {
+	return stream.BroadcastProcessFunction{
+		ProcessBroadcastElement: func(value interface{}, ctx stream.BroadcastContext, out func(value interface{})) error {
+			r := value.([2]interface{})
+			ctx.State.Put(r[0].(string), threshold{Limit: r[1].(int)})
+			return nil
+		},
+		ProcessElement: func(value interface{}, ctx stream.ReadOnlyBroadcastContext, out func(value interface{})) error {
+			m := value.(map[string]interface{})
+			rule, ok := ctx.State.Get(ctx.Key.(string))
+			if ok && m["value"].(int) > rule.(threshold).Limit {
+				out(m["value"])
+			}
+			return nil
+		},
+	}
+}
+
+func TestBroadcastState(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	h := glinktest.New(start)
+	rulesInput, rulesStream := h.Input()
+	dataInput, dataStream := h.Input()
+
+	descriptor := stream.BroadcastStateDescriptor{Name: "rules", Value: threshold{}}
+	out := glinktest.Collect(dataStream.KeyBy(func(value interface{}) interface{} {
+		return value.(map[string]interface{})["sensor"]
+	}).Connect(rulesStream.Broadcast(descriptor)).Process(rules()))
+
+	dataInput.Push(map[string]interface{}{"sensor": "a", "value": 10})
+	rulesInput.Push([2]interface{}{"a", 5})
+	dataInput.Push(map[string]interface{}{"sensor": "a", "value": 10})
+	dataInput.Push(map[string]interface{}{"sensor": "a", "value": 3})
+	dataInput.Push(map[string]interface{}{"sensor": "b", "value": 10})
+	out.AssertValues(t, 10)
+
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":{"Limit":5}}`, string(snapshot["broadcast/rules/1"]))
+
+	// restored state keeps the descriptor value type
+	restored := glinktest.New(start)
+	_, restoredRules := restored.Input()
+	restoredInput, restoredData := restored.Input()
+	restoredOut := glinktest.Collect(restoredData.KeyBy(func(value interface{}) interface{} {
+		return value.(map[string]interface{})["sensor"]
+	}).Connect(restoredRules.Broadcast(descriptor)).Process(rules()))
+	assert.NoError(t, restored.Context().Restore(snapshot))
+
+	restoredInput.Push(map[string]interface{}{"sensor": "a", "value": 7})
+	restoredOut.AssertValues(t, 7)
+}
+
+func TestBroadcastErrorsGoToFaults(t *testing.T) {
+	h := glinktest.New(time.Now())
+	rulesInput, rulesStream := h.Input()
+	processed := rulesStream.Broadcast(stream.BroadcastStateDescriptor{Name: "rules"})
+	_, dataStream := h.Input()
+	result := dataStream.Connect(processed).Process(stream.BroadcastProcessFunction{
+		ProcessBroadcastElement: func(value interface{}, ctx stream.BroadcastContext, out func(value interface{})) error {
+			return assert.AnError
+		},
+	})
+	faults := glinktest.CollectFaults(result)
+	errors := result.Metrics().Errors()
+	rulesInput.Push("bad rule")
+	faults.AssertValues(t, "bad rule")
+	assert.Equal(t, errors+1, result.Metrics().Errors())
+}
+
+func TestBroadcastStatesOfSameName(t *testing.T) {
+	h := glinktest.New(time.Now())
+	_, dataStream := h.Input()
+	descriptor := stream.BroadcastStateDescriptor{Name: "rules", Value: threshold{}}
+	_, first := h.Input()
+	_, second := h.Input()
+	dataStream.Connect(first.Broadcast(descriptor)).Process(rules())
+	dataStream.Connect(second.Broadcast(descriptor)).Process(rules())
+
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+	assert.Contains(t, snapshot, "broadcast/rules/1")
+	assert.Contains(t, snapshot, "broadcast/rules/2")
+}
+
+func TestBroadcastFromAnotherContext(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	h := glinktest.New(start)
+	rulesTask := glinktest.New(start)
+	rulesInput, rulesStream := rulesTask.Input()
+	dataInput, dataStream := h.Input()
+	out := glinktest.Collect(dataStream.KeyBy(func(value interface{}) interface{} {
+		return value.(map[string]interface{})["sensor"]
+	}).Connect(rulesStream.Broadcast(stream.BroadcastStateDescriptor{Name: "rules"})).Process(rules()))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rulesInput.Push([2]interface{}{"a", 5})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			dataInput.Push(map[string]interface{}{"sensor": "b", "value": 1})
+			_, err := h.Checkpoint()
+			assert.NoError(t, err)
+		}
+	}()
+	wg.Wait()
+	dataInput.Push(map[string]interface{}{"sensor": "a", "value": 10})
+	out.AssertValues(t, 10)
+}
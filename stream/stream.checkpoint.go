@@ -0,0 +1,202 @@
+package stream
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/discretemind/glink/stream/quantum"
+)
+
+// ICheckpointListener is notified when a checkpoint of the stream context is completed.
+// Sinks use it to make the data written since the previous checkpoint visible.
+type ICheckpointListener interface {
+	CheckpointComplete(id uint64) error
+}
+
+// IStateful is operator state included in checkpoints
+type IStateful interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+func (c *Context) OnCheckpoint(l ICheckpointListener) {
+	c.Lock()
+	defer c.Unlock()
+	c.listeners = append(c.listeners, l)
+}
+
+// RegisterState includes the state into checkpoints by the unique name
+func (c *Context) RegisterState(name string, state IStateful) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.states == nil {
+		c.states = make(map[string]IStateful)
+	}
+	if _, ok := c.states[name]; ok {
+		return fmt.Errorf("state %s is already registered", name)
+	}
+	c.states[name] = state
+	return nil
+}
+
+// IKeyedState is operator state partitioned by JSON encoded keys, so keys of quanta moved between workers
+// migrate with them
+type IKeyedState interface {
+	IStateful
+	// RestoreKeys loads keys of the snapshot accepted by the filter. Other keys keep their current value
+	RestoreKeys(data []byte, keep func(key string) bool) error
+	// DropKeys removes keys accepted by the filter
+	DropKeys(drop func(key string) bool)
+}
+
+// Snapshot returns snapshots of all registered states by their names. Events are not processed meanwhile,
+// so the snapshot is consistent
+func (c *Context) Snapshot() (res map[string][]byte, err error) {
+	c.processing.Lock()
+	defer c.processing.Unlock()
+	return c.snapshot()
+}
+
+func (c *Context) snapshot() (res map[string][]byte, err error) {
+	states := c.registeredStates()
+	res = make(map[string][]byte, len(states))
+	for name, state := range states {
+		if res[name], err = state.Snapshot(); err != nil {
+			return nil, fmt.Errorf("state %s: %v", name, err)
+		}
+	}
+	return
+}
+
+func (c *Context) registeredStates() map[string]IStateful {
+	c.Lock()
+	defer c.Unlock()
+	res := make(map[string]IStateful, len(c.states))
+	for name, state := range c.states {
+		res[name] = state
+	}
+	return res
+}
+
+// Restore loads state snapshots. States missing in the snapshot keep their current value
+func (c *Context) Restore(snapshot map[string][]byte) error {
+	c.processing.Lock()
+	defer c.processing.Unlock()
+
+	states := c.registeredStates()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state, ok := states[name]
+		if !ok {
+			return fmt.Errorf("state %s is not registered", name)
+		}
+		if err := state.Restore(snapshot[name]); err != nil {
+			return fmt.Errorf("state %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ReleaseQuanta snapshots all states for the checkpoint of quanta, which are moving to another worker. Events
+// wait until release is called. Once the checkpoint is saved, release drops keys of the quanta and releases
+// them from the context set, so later events of their keys are dropped by keyed streams. The quanta are kept,
+// when the checkpoint isn't saved
+func (c *Context) ReleaseQuanta(quanta []quantum.Quantum) (res map[string][]byte, release func(saved bool), err error) {
+	c.processing.Lock()
+	if res, err = c.snapshot(); err != nil {
+		c.processing.Unlock()
+		return
+	}
+	release = func(saved bool) {
+		defer c.processing.Unlock()
+		if !saved {
+			return
+		}
+		drop := quantum.Contains(quanta)
+		for _, state := range c.registeredStates() {
+			if keyed, ok := state.(IKeyedState); ok {
+				keyed.DropKeys(drop)
+			}
+		}
+		if set := c.Quanta(); set != nil {
+			for _, q := range quanta {
+				set.Release(q)
+			}
+		}
+	}
+	return
+}
+
+// RestoreQuanta loads keys of the quanta from state snapshots, when the quanta are moved to the worker.
+// States, which aren't keyed, keep their current value
+func (c *Context) RestoreQuanta(snapshot map[string][]byte, quanta []quantum.Quantum) error {
+	c.processing.Lock()
+	defer c.processing.Unlock()
+
+	states := c.registeredStates()
+	keep := quantum.Contains(quanta)
+	for name, data := range snapshot {
+		state, ok := states[name]
+		if !ok {
+			return fmt.Errorf("state %s is not registered", name)
+		}
+		keyed, ok := state.(IKeyedState)
+		if !ok {
+			continue
+		}
+		if err := keyed.RestoreKeys(data, keep); err != nil {
+			return fmt.Errorf("state %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Checkpoint completes a new checkpoint and notifies all listeners. The first listener error is returned,
+// but every listener is notified regardless
+func (c *Context) Checkpoint() (id uint64, err error) {
+	c.Lock()
+	c.checkpointId++
+	id = c.checkpointId
+	c.Unlock()
+	return id, c.CheckpointComplete(id)
+}
+
+// CheckpointComplete notifies listeners about the checkpoint completed by the job
+func (c *Context) CheckpointComplete(id uint64) (err error) {
+	c.Lock()
+	if id > c.checkpointId {
+		c.checkpointId = id
+	}
+	listeners := make([]ICheckpointListener, len(c.listeners))
+	copy(listeners, c.listeners)
+	c.Unlock()
+
+	for _, l := range listeners {
+		if lErr := l.CheckpointComplete(id); lErr != nil && err == nil {
+			err = lErr
+		}
+	}
+	return
+}
+
+// RegisterOperatorState registers the state by the prefix and the first free number, e.g. dedup/1, and returns
+// the name. Numbers are stable as long as the job graph is built in the same order, so operators of the same
+// kind don't need unique names
+func (c *Context) RegisterOperatorState(prefix string, state IStateful) string {
+	c.Lock()
+	defer c.Unlock()
+	if c.states == nil {
+		c.states = make(map[string]IStateful)
+	}
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s/%d", prefix, i)
+		if _, ok := c.states[name]; !ok {
+			c.states[name] = state
+			return name
+		}
+	}
+}
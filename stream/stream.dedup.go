@@ -0,0 +1,392 @@
+package stream
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+const (
+	DefaultDedupMaxKeys           = 1000000
+	DefaultDedupFalsePositiveRate = 0.01
+	// dedupEntryBytes estimates memory used by an exact mode entry besides its key
+	dedupEntryBytes = 64
+)
+
+// DedupOptions tune deduplication. The exact mode keeps up to MaxKeys keys, evicting ones expiring first
+// when it's full. The Bloom mode keeps two Bloom filters sized for ExpectedKeys per ttl, so its memory is fixed,
+// but about FalsePositiveRate of unique records are dropped and repeats are dropped for ttl to 2*ttl
+type DedupOptions struct {
+	MaxKeys           int
+	Bloom             bool
+	ExpectedKeys      int
+	FalsePositiveRate float64
+}
+
+// Distinct drops events, which key has been seen within the ttl in event time
+func (s *KeyedStream) Distinct(ttl time.Duration, options ...DedupOptions) *DataStream {
+	return s.DataStream.dedupBy(s.selector, ttl, true, options...)
+}
+
+// DedupBy drops events, which key has been seen within the ttl in event time. Keys are compared by their JSON encoding.
+// Dropped records and the state size are reported by the operator metrics
+func (s *DataStream) DedupBy(key func(value interface{}) interface{}, ttl time.Duration, options ...DedupOptions) *DataStream {
+	return s.dedupBy(key, ttl, false, options...)
+}
+
+// dedupBy migrates keys of the exact state with quanta, when they are the keys of the keyed stream
+func (s *DataStream) dedupBy(key func(value interface{}) interface{}, ttl time.Duration, keyed bool, options ...DedupOptions) (result *DataStream) {
+	opts := DedupOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = DefaultDedupMaxKeys
+	}
+	if opts.ExpectedKeys <= 0 {
+		opts.ExpectedKeys = opts.MaxKeys
+	}
+	if opts.FalsePositiveRate <= 0 || opts.FalsePositiveRate >= 1 {
+		opts.FalsePositiveRate = DefaultDedupFalsePositiveRate
+	}
+
+	var state dedupState
+	if opts.Bloom {
+		state = newBloomDedup(ttl, opts.ExpectedKeys, opts.FalsePositiveRate)
+	} else {
+		state = newExactDedup(s.Context(), ttl, opts.MaxKeys, keyed, func(entries, bytes int64) {
+			result.Metrics().State(entries, bytes)
+		})
+	}
+	ctx := s.Context()
+	ctx.RegisterOperatorState("dedup", state)
+
+	result = Stream(s, func(event *Event) (*Event, error) {
+		id, err := json.Marshal(key(event.Payload))
+		if err != nil {
+			return nil, err
+		}
+		seen := state.Seen(string(id), event.Timestamp, ctx.Watermark())
+		m := result.Metrics()
+		m.State(state.Size())
+		if seen {
+			m.Drop()
+			return nil, nil
+		}
+		return event, nil
+	}).Name("Dedup")
+	return
+}
+
+type dedupState interface {
+	IStateful
+	// Seen tells whether the key is a repeat and remembers it otherwise
+	Seen(key string, t time.Time, watermark time.Time) bool
+	Size() (entries, bytes int64)
+}
+
+// exactDedup expires keys by an event time timer set to the earliest expiry, so idle keys don't stay in the state
+type exactDedup struct {
+	ctx      *Context
+	ttl      time.Duration
+	maxKeys  int
+	keyed    bool
+	keys     map[string]time.Time
+	expiry   dedupQueue
+	keyBytes int64
+	timerAt  time.Time
+	cancel   func()
+	report   func(entries, bytes int64)
+}
+
+func newExactDedup(ctx *Context, ttl time.Duration, maxKeys int, keyed bool, report func(entries, bytes int64)) *exactDedup {
+	return &exactDedup{
+		ctx:     ctx,
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		keyed:   keyed,
+		keys:    make(map[string]time.Time),
+		report:  report,
+	}
+}
+
+func (d *exactDedup) Seen(key string, t time.Time, watermark time.Time) bool {
+	d.expire(func(expires time.Time) bool {
+		return !expires.After(watermark)
+	})
+	if expires, ok := d.keys[key]; ok && t.Before(expires) {
+		return true
+	}
+	d.put(key, t.Add(d.ttl))
+	if len(d.keys) > d.maxKeys {
+		evicted := false
+		d.expire(func(time.Time) bool {
+			if evicted {
+				return false
+			}
+			evicted = true
+			return true
+		})
+	}
+	d.schedule()
+	return false
+}
+
+func (d *exactDedup) schedule() {
+	if d.expiry.Len() == 0 {
+		if d.cancel != nil {
+			d.cancel()
+			d.cancel = nil
+		}
+		return
+	}
+	at := d.expiry[0].expires
+	if d.cancel != nil {
+		if d.timerAt.Equal(at) {
+			return
+		}
+		d.cancel()
+	}
+	d.timerAt = at
+	d.cancel = d.ctx.OnEventTime(at, func(t time.Time) {
+		d.cancel = nil
+		d.expire(func(expires time.Time) bool {
+			return !expires.After(t)
+		})
+		d.schedule()
+		d.report(d.Size())
+	})
+}
+
+func (d *exactDedup) put(key string, expires time.Time) {
+	if _, ok := d.keys[key]; !ok {
+		d.keyBytes += int64(len(key))
+	}
+	d.keys[key] = expires
+	heap.Push(&d.expiry, dedupEntry{key: key, expires: expires})
+}
+
+// expire removes keys from the front of the expiry queue while the condition holds. Queue entries
+// of keys, which were seen again, are stale and skipped
+func (d *exactDedup) expire(cond func(expires time.Time) bool) {
+	for d.expiry.Len() > 0 {
+		e := d.expiry[0]
+		if expires, ok := d.keys[e.key]; !ok || !expires.Equal(e.expires) {
+			heap.Pop(&d.expiry)
+			continue
+		}
+		if !cond(e.expires) {
+			return
+		}
+		heap.Pop(&d.expiry)
+		delete(d.keys, e.key)
+		d.keyBytes -= int64(len(e.key))
+	}
+}
+
+func (d *exactDedup) Size() (entries, bytes int64) {
+	entries = int64(len(d.keys))
+	return entries, d.keyBytes + entries*dedupEntryBytes
+}
+
+func (d *exactDedup) Snapshot() ([]byte, error) {
+	return json.Marshal(d.keys)
+}
+
+func (d *exactDedup) Restore(data []byte) error {
+	keys := make(map[string]time.Time)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	d.keys = make(map[string]time.Time, len(keys))
+	d.expiry = nil
+	d.keyBytes = 0
+	for key, expires := range keys {
+		d.put(key, expires)
+	}
+	d.schedule()
+	return nil
+}
+
+// RestoreKeys loads keys moved to the worker
+func (d *exactDedup) RestoreKeys(data []byte, keep func(key string) bool) error {
+	if !d.keyed {
+		return nil
+	}
+	keys := make(map[string]time.Time)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for key, expires := range keys {
+		if keep(key) {
+			d.put(key, expires)
+		}
+	}
+	d.schedule()
+	d.report(d.Size())
+	return nil
+}
+
+// DropKeys removes keys. Their expiry queue entries become stale. Keys of DedupBy aren't partitioned by quanta and stay
+func (d *exactDedup) DropKeys(drop func(key string) bool) {
+	if !d.keyed {
+		return
+	}
+	for key := range d.keys {
+		if drop(key) {
+			delete(d.keys, key)
+			d.keyBytes -= int64(len(key))
+		}
+	}
+	d.report(d.Size())
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+type dedupQueue []dedupEntry
+
+func (q dedupQueue) Len() int {
+	return len(q)
+}
+
+func (q dedupQueue) Less(i, j int) bool {
+	return q[i].expires.Before(q[j].expires)
+}
+
+func (q dedupQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *dedupQueue) Push(x interface{}) {
+	*q = append(*q, x.(dedupEntry))
+}
+
+func (q *dedupQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
+
+// bloomDedup rotates two Bloom filters every ttl. Keys are looked up in both, so a key is remembered
+// for ttl at least
+type bloomDedup struct {
+	ttl      time.Duration
+	hashes   int
+	Start    time.Time
+	Current  *bloomFilter
+	Previous *bloomFilter
+}
+
+type bloomFilter struct {
+	Bits  []uint64
+	Count int64
+}
+
+func newBloomDedup(ttl time.Duration, expectedKeys int, falsePositiveRate float64) *bloomDedup {
+	n := float64(expectedKeys)
+	bits := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(bits / n * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	words := int(bits+63) / 64
+	return &bloomDedup{
+		ttl:      ttl,
+		hashes:   hashes,
+		Current:  &bloomFilter{Bits: make([]uint64, words)},
+		Previous: &bloomFilter{Bits: make([]uint64, words)},
+	}
+}
+
+func (d *bloomDedup) Seen(key string, t time.Time, _ time.Time) bool {
+	d.rotate(t)
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum(nil)
+	h1, h2 := uint64(0), uint64(0)
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[8+i])
+	}
+	// double hashing gives the positions of all hash functions
+	size := uint64(len(d.Current.Bits) * 64)
+	positions := make([]uint64, d.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % size
+	}
+	if d.Current.has(positions) || d.Previous.has(positions) {
+		return true
+	}
+	d.Current.add(positions)
+	return false
+}
+
+func (d *bloomDedup) rotate(t time.Time) {
+	if d.Start.IsZero() {
+		d.Start = t
+		return
+	}
+	for !t.Before(d.Start.Add(d.ttl)) {
+		d.Previous, d.Current = d.Current, d.Previous
+		d.Current.clear()
+		d.Start = d.Start.Add(d.ttl)
+		if t.Sub(d.Start) >= 2*d.ttl {
+			// both generations are outdated
+			d.Previous.clear()
+			d.Start = t
+		}
+	}
+}
+
+func (d *bloomDedup) Size() (entries, bytes int64) {
+	return d.Current.Count + d.Previous.Count, int64(len(d.Current.Bits)+len(d.Previous.Bits)) * 8
+}
+
+func (d *bloomDedup) Snapshot() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *bloomDedup) Restore(data []byte) error {
+	restored := *d
+	restored.Current, restored.Previous = nil, nil
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return err
+	}
+	if restored.Current == nil || restored.Previous == nil ||
+		len(restored.Current.Bits) != len(d.Current.Bits) || len(restored.Previous.Bits) != len(d.Current.Bits) {
+		return fmt.Errorf("bloom filter size doesn't match the options")
+	}
+	*d = restored
+	return nil
+}
+
+func (f *bloomFilter) has(positions []uint64) bool {
+	for _, p := range positions {
+		if f.Bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(positions []uint64) {
+	for _, p := range positions {
+		f.Bits[p/64] |= 1 << (p % 64)
+	}
+	f.Count++
+}
+
+func (f *bloomFilter) clear() {
+	for i := range f.Bits {
+		f.Bits[i] = 0
+	}
+	f.Count = 0
+}
@@ -0,0 +1,120 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/glinktest"
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+type delivery struct {
+	ID  string
+	Seq int
+}
+
+func deliveries(start time.Time, ids ...string) (res []stream.Event) {
+	for i, id := range ids {
+		res = append(res, stream.Event{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Payload:   delivery{ID: id, Seq: i},
+		})
+	}
+	return
+}
+
+func byID(value interface{}) interface{} {
+	return value.(delivery).ID
+}
+
+func seqs(sink *glinktest.Sink) (res []int) {
+	for _, v := range sink.Values() {
+		res = append(res, v.(delivery).Seq)
+	}
+	return
+}
+
+func TestDistinct(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	h := glinktest.New(start)
+	s := h.FromEvents(deliveries(start, "a", "b", "a", "c", "b", "a", "a")...)
+	distinct := s.KeyBy(byID).Distinct(5 * time.Second).ID("distinct-test")
+	out := glinktest.Collect(distinct)
+	// metrics are kept by the global registry across runs of the test
+	m := distinct.Metrics()
+	dropped := m.Dropped()
+	h.Run()
+
+	// a is seen again at 5s, when the first one has expired
+	assert.Equal(t, []int{0, 1, 3, 5}, seqs(out))
+	assert.Equal(t, uint64(3), m.Dropped()-dropped)
+	assert.Equal(t, int64(3), m.StateEntries())
+	assert.True(t, m.StateBytes() > 0)
+
+	h.AdvanceWatermark(start.Add(time.Minute))
+	assert.Equal(t, int64(0), m.StateEntries())
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(snapshot["dedup/1"]), "keys expired by the watermark")
+}
+
+func TestDedupMaxKeys(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	h := glinktest.New(start)
+	s := h.FromEvents(deliveries(start, "a", "b", "c", "a", "c")...)
+	dedup := s.DedupBy(byID, time.Hour, stream.DedupOptions{MaxKeys: 2}).ID("dedup-max-keys")
+	out := glinktest.Collect(dedup)
+	h.Run()
+
+	// a is evicted by c as it expires first
+	assert.Equal(t, []int{0, 1, 2, 3}, seqs(out))
+	assert.Equal(t, int64(2), dedup.Metrics().StateEntries())
+}
+
+func TestDedupCheckpoint(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for _, bloom := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bloom %v", bloom), func(t *testing.T) {
+			options := stream.DedupOptions{Bloom: bloom, ExpectedKeys: 1000}
+			h := glinktest.New(start)
+			glinktest.Collect(h.FromEvents(deliveries(start, "a", "b")...).DedupBy(byID, time.Hour, options))
+			h.Run()
+			snapshot, err := h.Checkpoint()
+			assert.NoError(t, err)
+
+			restored := glinktest.New(start)
+			out := glinktest.Collect(restored.FromEvents(deliveries(start.Add(time.Minute), "b", "c", "a")...).DedupBy(byID, time.Hour, options))
+			assert.NoError(t, restored.Context().Restore(snapshot))
+			restored.Run()
+			assert.Equal(t, []int{1}, seqs(out))
+		})
+	}
+}
+
+func TestBloomDedup(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	h := glinktest.New(start)
+	input, s := h.Input()
+	dedup := s.DedupBy(func(value interface{}) interface{} {
+		return value
+	}, time.Minute, stream.DedupOptions{Bloom: true, ExpectedKeys: 10000, FalsePositiveRate: 0.001}).ID("bloom-test")
+	out := glinktest.Collect(dedup)
+	dropped := dedup.Metrics().Dropped()
+
+	for i := 0; i < 5000; i++ {
+		input.Push(i)
+		input.Push(i)
+	}
+	// a few unique values may be false positives
+	assert.InDelta(t, 5000, len(out.Values()), 10)
+	assert.True(t, dedup.Metrics().Dropped()-dropped >= 5000)
+	assert.True(t, dedup.Metrics().StateBytes() > 0)
+
+	// repeats are forgotten two generations later
+	h.AdvanceTime(3 * time.Minute)
+	out.Reset()
+	input.Push(1)
+	assert.Equal(t, []interface{}{1}, out.Values())
+}
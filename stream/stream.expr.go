@@ -0,0 +1,32 @@
+package stream
+
+import (
+	"github.com/discretemind/glink/stream/expr"
+)
+
+// FilterExpr keeps events matching the boolean expression, e.g. `payload.amount > 100 && payload.currency == "EUR"`.
+// Events failed to evaluate are sent to the stream faults
+func (s *DataStream) FilterExpr(src string) (*DataStream, error) {
+	program, err := expr.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return Stream(s, func(event *Event) (*Event, error) {
+		ok, err := program.Match(event.Payload)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return event, nil
+	}), nil
+}
+
+// MapExpr projects payloads into map[string]interface{} with expressions by the output field names
+func (s *DataStream) MapExpr(fields map[string]string) (*DataStream, error) {
+	projection, err := expr.CompileProjection(fields)
+	if err != nil {
+		return nil, err
+	}
+	return s.Map(func(value interface{}) (interface{}, error) {
+		return projection.EvalPayload(value)
+	}), nil
+}
@@ -8,9 +8,7 @@ type FaultStream struct {
 
 func (s *DataStream) Fault() *FaultStream {
 	result := &FaultStream{
-		DataStream: DataStream{
-			ctx: s.Context(),
-		},
+		DataStream: *newStream(s.Context()),
 	}
 	s.BindOut(func(event *Event) {
 		fmt.Println("FaultOut ", event)
@@ -1,25 +1,26 @@
 package stream
 
 import (
-	"errors"
-	"reflect"
+	"github.com/discretemind/glink/stream/field"
 )
 
-func (s *DataStream) FilterByField(fieldName string, fieldValue interface{}) *DataStream {
+// FilterByField keeps events, which field matches the value. The field is a path like `User.Address.Country`
+// or `tags[0]` over struct and map payloads. Equality is used unless another operator is passed.
+// Events are sent to the stream faults when the path or the operator is invalid
+func (s *DataStream) FilterByField(fieldName string, fieldValue interface{}, op ...field.Operator) *DataStream {
+	operator := field.Eq
+	if len(op) > 0 {
+		operator = op[0]
+	}
+	condition, err := field.NewCondition(fieldName, operator, fieldValue)
 	return Stream(s, func(event *Event) (*Event, error) {
-		v := reflect.ValueOf(event.Payload)
-		if v.Kind() == reflect.Ptr {
-			v = v.Elem()
+		if err != nil {
+			return nil, err
 		}
-		if v.Kind() == reflect.Ptr {
-			return nil, errors.New("Invalid type")
-		}
-		field := v.FieldByName(fieldName)
-		if field.Interface() == fieldValue {
+		if condition.Match(event.Payload) {
 			return event, nil
-		} else {
-			return nil, nil
 		}
+		return nil, nil
 	})
 }
 
@@ -1,7 +1,13 @@
 package stream
 
 import (
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/discretemind/glink/utils/metrics"
 )
 
 type Event struct {
@@ -13,6 +19,61 @@ type FilterHandler func(event *Event) (*Event, error)
 type PushHandler func(event *Event)
 
 type Context struct {
+	sync.Mutex
+	checkpointId uint64
+	listeners    []ICheckpointListener
+	states       map[string]IStateful
+	// processing serializes events, timers and snapshots of the context
+	processing      sync.Mutex
+	clock           Clock
+	watermark       time.Time
+	watermarkDelay  time.Duration
+	manualWatermark bool
+	eventTimers     timerQueue
+	timerSeq        uint64
+	quanta          *quantum.Set
+	// resumers replay events of keyed streams paused until their quanta are assigned
+	resumers []func(quanta []quantum.Quantum)
+}
+
+func NewContext() *Context {
+	return &Context{}
+}
+
+// SetQuanta limits keyed streams of the context to keys of the quanta assigned to the worker
+func (c *Context) SetQuanta(set *quantum.Set) {
+	c.Lock()
+	c.quanta = set
+	c.Unlock()
+	set.OnAssign(func(quanta []quantum.Quantum) {
+		if c.Quanta() == set {
+			c.resume(quanta)
+		}
+	})
+}
+
+func (c *Context) onResume(f func(quanta []quantum.Quantum)) {
+	c.Lock()
+	defer c.Unlock()
+	c.resumers = append(c.resumers, f)
+}
+
+// resume replays events paused by keyed streams of the context, once their quanta are assigned
+func (c *Context) resume(quanta []quantum.Quantum) {
+	c.processing.Lock()
+	defer c.processing.Unlock()
+	c.Lock()
+	resumers := append([]func(quanta []quantum.Quantum){}, c.resumers...)
+	c.Unlock()
+	for _, f := range resumers {
+		f(quanta)
+	}
+}
+
+func (c *Context) Quanta() *quantum.Set {
+	c.Lock()
+	defer c.Unlock()
+	return c.quanta
 }
 
 type IStreamSource interface {
@@ -26,21 +87,41 @@ type DataStream struct {
 	id     string
 	outs   []PushHandler
 	faults []PushHandler
+	// defaultID is unique to the stream, so operators without ids have their own metrics
+	defaultID string
+	metrics   *metrics.OperatorMetrics
 }
 
-func Stream(from *DataStream, handler FilterHandler) (result *DataStream) {
-	result = &DataStream{
-		ctx: from.Context(),
+var operatorSeq uint64
+
+// newStream creates an operator stream of the context with metrics registered by its default id
+func newStream(ctx *Context) *DataStream {
+	id := strconv.FormatUint(atomic.AddUint64(&operatorSeq, 1), 10)
+	return &DataStream{
+		ctx:       ctx,
+		id:        id,
+		defaultID: id,
+		metrics:   metrics.Operator("", id),
 	}
+}
+
+func Stream(from *DataStream, handler FilterHandler) (result *DataStream) {
+	result = newStream(from.Context())
 	from.BindOut(func(event *Event) {
+		m := result.Metrics()
+		m.In()
+		start := time.Now()
 		outEvent, err := handler(event)
+		m.Latency(time.Since(start))
 		if err != nil {
+			m.Error()
 			for _, out := range result.faults {
-				out(outEvent)
+				out(event)
 			}
 			return
 		}
 		if outEvent != nil {
+			m.Out()
 			for _, out := range result.outs {
 				out(outEvent)
 			}
@@ -53,16 +134,34 @@ func (s *DataStream) Context() *Context {
 	return s.ctx
 }
 
+// Name of the operator metrics. It's set while the job is built
 func (s *DataStream) Name(name string) *DataStream {
 	s.name = name
+	s.registerMetrics()
 	return s
 }
 
+// ID of the operator metrics. Operators without ids have unique default ones
 func (s *DataStream) ID(id string) *DataStream {
 	s.id = id
+	s.registerMetrics()
 	return s
 }
 
+// registerMetrics replaces metrics by ones of the current name and id. Metrics of the default id belong
+// only to the stream, so they are removed
+func (s *DataStream) registerMetrics() {
+	if s.metrics != nil && s.metrics.ID() == s.defaultID {
+		metrics.RemoveOperator(s.metrics.Name(), s.defaultID)
+	}
+	s.metrics = metrics.Operator(s.name, s.id)
+}
+
+// Metrics of the operator, registered by its name and id
+func (s *DataStream) Metrics() *metrics.OperatorMetrics {
+	return s.metrics
+}
+
 func (s *DataStream) BindOut(f PushHandler) {
 	s.outs = append(s.outs, f)
 }
@@ -70,3 +169,25 @@ func (s *DataStream) BindOut(f PushHandler) {
 func (s *DataStream) BindFault(f PushHandler) {
 	s.faults = append(s.faults, f)
 }
+
+// ContextStream creates an operator stream, which emits events by Emit. Operators implemented outside
+// of the package use it to emit from timers as well as from their input handlers
+func ContextStream(ctx *Context) *DataStream {
+	return newStream(ctx)
+}
+
+// Emit pushes the event to the stream outputs. It must be called while the context processes an event or a timer
+func (s *DataStream) Emit(event *Event) {
+	s.Metrics().Out()
+	for _, out := range s.outs {
+		out(event)
+	}
+}
+
+// EmitFault pushes the event to the stream faults
+func (s *DataStream) EmitFault(event *Event) {
+	s.Metrics().Error()
+	for _, out := range s.faults {
+		out(event)
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 type IInputStream interface {
 	Push(event interface{})
+	// PushEvent pushes the event with its own timestamp
+	PushEvent(evt *Event)
 	//Run()
 }
 
@@ -15,10 +17,13 @@ type inputStream struct {
 }
 
 func InputStream() (result *inputStream) {
+	return ContextInputStream(NewContext())
+}
+
+// ContextInputStream creates an input sharing the context with other inputs, so they have common time and checkpoints
+func ContextInputStream(ctx *Context) (result *inputStream) {
 	result = &inputStream{
-		DataStream: &DataStream{
-			ctx: &Context{},
-		},
+		DataStream: newStream(ctx),
 	}
 	return
 }
@@ -35,10 +40,23 @@ func (s *inputStream) Push(msg interface{}) {
 	if s.watermark != nil {
 		evt.Timestamp = s.watermark(msg)
 	} else {
-		evt.Timestamp = time.Now()
+		evt.Timestamp = s.ctx.Now()
 	}
+	s.PushEvent(evt)
+}
+
+// PushEvent pushes the event with its own timestamp. The context watermark follows event time
+// unless it's advanced manually
+func (s *inputStream) PushEvent(evt *Event) {
+	s.ctx.processing.Lock()
+	defer s.ctx.processing.Unlock()
+
 	//fmt.Println("Push ", evt.Payload)
+	s.Metrics().Out()
 	for _, out := range s.outs {
 		out(evt)
 	}
+	if !s.ctx.manualWatermark {
+		s.ctx.advanceWatermark(evt.Timestamp.Add(-s.ctx.watermarkDelay))
+	}
 }
@@ -4,37 +4,110 @@ import (
 	"fmt"
 	"golang.org/x/crypto/blake2b"
 	"reflect"
+
+	"github.com/discretemind/glink/stream/quantum"
 )
 
+// MaxPausedEvents limits events a keyed stream buffers for quanta moving to the worker and before the first
+// assignment. Later events are dropped
+var MaxPausedEvents = 100000
+
 type KeyedEvent struct {
 	Key   interface{}
 	Event Event
 }
 
+// KeyedStream is a stream partitioned by the key of its events
 type KeyedStream struct {
-	Sync chan chan KeyedEvent
-}
-
-//
-//
-//func (s *DataStream) KeyBy(f func (interface{}) bool) *DataStream {
-//	return Stream(s, func(event *Event) (*Event, error) {
-//
-//		if f(event.Payload){
-//			return e
-//		}
-//
-//		v := reflect.ValueOf(event.Payload)
-//
-//
-//		field := v.FieldByName(fieldName)
-//		if field.Interface() == fieldValue {
-//			return event, nil
-//		} else {
-//			return nil, nil
-//		}
-//	})
-//}
+	*DataStream
+	selector func(value interface{}) interface{}
+	// paused events by quanta, which are moving to the worker
+	paused map[uint32][]*Event
+	// unassigned events come before the first assignment of quanta
+	unassigned []*Event
+	npaused    int
+}
+
+// KeyBy partitions the stream by the key. When the context has quanta assigned, events of keys owned
+// by other workers are dropped. Events of quanta moving to the worker are paused until their state is
+// restored and the quanta are assigned
+func (s *DataStream) KeyBy(f func(value interface{}) interface{}) (res *KeyedStream) {
+	res = &KeyedStream{
+		selector: f,
+		paused:   make(map[uint32][]*Event),
+	}
+	res.DataStream = Stream(s, func(event *Event) (*Event, error) {
+		quanta := s.ctx.Quanta()
+		if quanta == nil {
+			return event, nil
+		}
+		switch q, ownership := quanta.Lookup(f(event.Payload)); ownership {
+		case quantum.Pending:
+			res.pause(q, event)
+			return nil, nil
+		case quantum.Unassigned:
+			res.pauseUnassigned(event)
+			return nil, nil
+		case quantum.NotOwned:
+			res.Metrics().Drop()
+			return nil, nil
+		}
+		return event, nil
+	}).Name("Key By")
+	s.ctx.onResume(res.resume)
+	return
+}
+
+func (s *KeyedStream) pause(q quantum.Quantum, event *Event) {
+	if s.npaused >= MaxPausedEvents {
+		s.Metrics().Drop()
+		return
+	}
+	s.paused[q.Index] = append(s.paused[q.Index], event)
+	s.npaused++
+}
+
+func (s *KeyedStream) pauseUnassigned(event *Event) {
+	if s.npaused >= MaxPausedEvents {
+		s.Metrics().Drop()
+		return
+	}
+	s.unassigned = append(s.unassigned, event)
+	s.npaused++
+}
+
+// resume emits paused events of the assigned quanta in their arrival order. Events before the first assignment
+// are emitted, paused or dropped by the ownership of their keys then
+func (s *KeyedStream) resume(quanta []quantum.Quantum) {
+	unassigned := s.unassigned
+	s.unassigned = nil
+	s.npaused -= len(unassigned)
+	for _, event := range unassigned {
+		switch q, ownership := s.ctx.Quanta().Lookup(s.Key(event)); ownership {
+		case quantum.Owned:
+			s.Emit(event)
+		case quantum.Pending:
+			s.pause(q, event)
+		case quantum.Unassigned:
+			s.pauseUnassigned(event)
+		default:
+			s.Metrics().Drop()
+		}
+	}
+	for _, q := range quanta {
+		events := s.paused[q.Index]
+		delete(s.paused, q.Index)
+		s.npaused -= len(events)
+		for _, event := range events {
+			s.Emit(event)
+		}
+	}
+}
+
+// Key of the event
+func (s *KeyedStream) Key(event *Event) interface{} {
+	return s.selector(event.Payload)
+}
 
 func (s *DataStream) KeyByFieldSync(fields ...string) *DataStream {
 	return Stream(s, func(event *Event) (*Event, error) {
@@ -0,0 +1,125 @@
+package stream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/glinktest"
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/stream/aggregate"
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyOutside finds a user key of another quantum
+func keyOutside(q quantum.Quantum) string {
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("b%d", i)
+		if kq, _ := quantum.Of(key, q.Space); kq != q {
+			return key
+		}
+	}
+}
+
+func TestKeyByPausesMovingQuanta(t *testing.T) {
+	h := glinktest.New(windowStart)
+	qa, _ := quantum.Of("a", 2)
+	other := keyOutside(qa)
+	qb, _ := quantum.Of(other, 2)
+	set := quantum.NewSet()
+	assert.NoError(t, set.Assign(qa))
+	h.Context().SetQuanta(set)
+
+	in, s := h.Input()
+	out := glinktest.Collect(s.KeyBy(byUser).DataStream)
+	for _, e := range append(clicks("a", 1), clicks(other, 2, 3)...) {
+		e := e
+		in.PushEvent(&e)
+	}
+	assert.Len(t, out.Values(), 1, "keys of other workers are dropped")
+
+	assert.NoError(t, set.Prepare(qb))
+	for _, e := range append(clicks(other, 4, 5), clicks("a", 6)...) {
+		e := e
+		in.PushEvent(&e)
+	}
+	assert.Len(t, out.Values(), 2, "keys of the moving quantum are paused")
+
+	assert.NoError(t, set.Assign(qb))
+	events := out.Events()
+	assert.Len(t, events, 4)
+	assert.Equal(t, windowStart.Add(4*time.Second), events[2].Timestamp)
+	assert.Equal(t, windowStart.Add(5*time.Second), events[3].Timestamp)
+}
+
+func TestKeyByWaitsForFirstAssignment(t *testing.T) {
+	h := glinktest.New(windowStart)
+	qa, _ := quantum.Of("a", 2)
+	other := keyOutside(qa)
+	set := quantum.NewSet()
+	h.Context().SetQuanta(set)
+
+	in, s := h.Input()
+	out := glinktest.Collect(s.KeyBy(byUser).DataStream)
+	for _, e := range append(clicks("a", 1, 2), clicks(other, 3)...) {
+		e := e
+		in.PushEvent(&e)
+	}
+	assert.Empty(t, out.Values(), "keys wait for the space of quanta")
+
+	assert.NoError(t, set.Assign(qa))
+	events := out.Events()
+	assert.Len(t, events, 2, "keys of other workers are dropped")
+	assert.Equal(t, windowStart.Add(1*time.Second), events[0].Timestamp)
+	assert.Equal(t, windowStart.Add(2*time.Second), events[1].Timestamp)
+}
+
+func TestMigrateKeyedState(t *testing.T) {
+	qa, _ := quantum.Of("a", 2)
+	other := keyOutside(qa)
+	qb, _ := quantum.Of(other, 2)
+
+	worker := func(quanta ...quantum.Quantum) (*glinktest.Harness, *quantum.Set, stream.IInputStream, *glinktest.Sink) {
+		h := glinktest.New(windowStart)
+		set := quantum.NewSet()
+		assert.NoError(t, set.Assign(quanta...))
+		h.Context().SetQuanta(set)
+		in, s := h.Input()
+		out := glinktest.Collect(s.KeyBy(byUser).Window(stream.Tumbling(10 * time.Second)).Aggregate(aggregate.Count()))
+		return h, set, in, out
+	}
+	push := func(in stream.IInputStream, events ...stream.Event) {
+		for i := range events {
+			in.PushEvent(&events[i])
+		}
+	}
+
+	h1, set1, in1, out1 := worker(qa, qb)
+	push(in1, append(clicks("a", 1), clicks(other, 2, 3)...)...)
+
+	var released []quantum.Quantum
+	set1.OnRelease(func(q quantum.Quantum) {
+		released = append(released, q)
+	})
+	snapshot, release, err := h1.Context().ReleaseQuanta([]quantum.Quantum{qb})
+	assert.NoError(t, err)
+	release(false)
+	assert.Empty(t, released, "the quantum is kept, when the checkpoint isn't saved")
+	snapshot, release, err = h1.Context().ReleaseQuanta([]quantum.Quantum{qb})
+	assert.NoError(t, err)
+	release(true)
+	assert.Equal(t, []quantum.Quantum{qb}, released, "dropped windows don't hold the quantum")
+	push(in1, clicks(other, 4)...)
+
+	h2, set2, in2, out2 := worker()
+	assert.NoError(t, set2.Prepare(qb))
+	push(in2, clicks(other, 5)...)
+	assert.NoError(t, h2.Context().RestoreQuanta(snapshot, []quantum.Quantum{qb}))
+	assert.NoError(t, set2.Assign(qb))
+
+	h1.AdvanceWatermark(windowStart.Add(10 * time.Second))
+	h2.AdvanceWatermark(windowStart.Add(10 * time.Second))
+	assert.Equal(t, []string{"a 0s-10s 1"}, windowResults(out1))
+	assert.Equal(t, []string{other + " 0s-10s 3"}, windowResults(out2))
+}
@@ -2,8 +2,27 @@ package stream
 
 import "fmt"
 
+type ISink interface {
+	Push(event *Event)
+}
+
 func (s *DataStream) Print() {
 	s.BindOut(func(event *Event) {
 		fmt.Printf("Print %s, %+v %v\n", s.name, event.Payload, event.Timestamp)
 	})
 }
+
+// To binds the sink to the stream output. Sinks implementing ICheckpointListener are subscribed to checkpoints,
+// and ones implementing IStateful are snapshotted by them, e.g. sink/1
+func (s *DataStream) To(sink ISink) {
+	s.BindOut(sink.Push)
+	if s.ctx == nil {
+		return
+	}
+	if l, ok := sink.(ICheckpointListener); ok {
+		s.ctx.OnCheckpoint(l)
+	}
+	if state, ok := sink.(IStateful); ok {
+		s.ctx.RegisterOperatorState("sink", state)
+	}
+}
@@ -0,0 +1,156 @@
+package stream
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Clock is the processing time source of the context. Tests replace it with a manual clock
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f after the duration. The returned function cancels the call
+	AfterFunc(d time.Duration, f func()) (cancel func())
+}
+
+type realClock struct {
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() {
+	t := time.AfterFunc(d, f)
+	return func() {
+		t.Stop()
+	}
+}
+
+func (c *Context) SetClock(clock Clock) {
+	c.Lock()
+	defer c.Unlock()
+	c.clock = clock
+}
+
+func (c *Context) getClock() Clock {
+	c.Lock()
+	defer c.Unlock()
+	if c.clock == nil {
+		c.clock = realClock{}
+	}
+	return c.clock
+}
+
+// Now is the processing time of the context
+func (c *Context) Now() time.Time {
+	return c.getClock().Now()
+}
+
+// SetWatermarkDelay lets events be late by the delay. The watermark trails the latest event time by it
+func (c *Context) SetWatermarkDelay(d time.Duration) {
+	c.watermarkDelay = d
+}
+
+// SetManualWatermark stops the watermark following event time. It's advanced by AdvanceWatermark only
+func (c *Context) SetManualWatermark(manual bool) {
+	c.manualWatermark = manual
+}
+
+// Watermark is the event time, which all events before are considered arrived
+func (c *Context) Watermark() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.watermark
+}
+
+// AdvanceWatermark moves the watermark forward and fires event time timers due by it.
+// It must not be called while an event is being processed
+func (c *Context) AdvanceWatermark(t time.Time) {
+	c.processing.Lock()
+	defer c.processing.Unlock()
+	c.advanceWatermark(t)
+}
+
+func (c *Context) advanceWatermark(t time.Time) {
+	c.Lock()
+	if !t.After(c.watermark) {
+		c.Unlock()
+		return
+	}
+	c.watermark = t
+	c.Unlock()
+
+	for {
+		c.Lock()
+		if c.eventTimers.Len() == 0 || c.eventTimers[0].at.After(t) {
+			c.Unlock()
+			return
+		}
+		timer := heap.Pop(&c.eventTimers).(*eventTimer)
+		c.Unlock()
+		if !timer.cancelled {
+			timer.f(timer.at)
+		}
+	}
+}
+
+// OnEventTime calls f once the watermark passes the time. Timers of the same time fire in registration order
+func (c *Context) OnEventTime(at time.Time, f func(t time.Time)) (cancel func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.timerSeq++
+	timer := &eventTimer{at: at, seq: c.timerSeq, f: f}
+	heap.Push(&c.eventTimers, timer)
+	return func() {
+		c.Lock()
+		defer c.Unlock()
+		timer.cancelled = true
+	}
+}
+
+// OnProcessingTime calls f at the processing time. The call is serialized with event processing
+func (c *Context) OnProcessingTime(at time.Time, f func(t time.Time)) (cancel func()) {
+	clock := c.getClock()
+	return clock.AfterFunc(at.Sub(clock.Now()), func() {
+		c.processing.Lock()
+		defer c.processing.Unlock()
+		f(at)
+	})
+}
+
+type eventTimer struct {
+	at        time.Time
+	seq       uint64
+	f         func(t time.Time)
+	cancelled bool
+}
+
+type timerQueue []*eventTimer
+
+func (q timerQueue) Len() int {
+	return len(q)
+}
+
+func (q timerQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q timerQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *timerQueue) Push(x interface{}) {
+	*q = append(*q, x.(*eventTimer))
+}
+
+func (q *timerQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
@@ -1,3 +1,432 @@
 package stream
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
 
+	"github.com/discretemind/glink/stream/quantum"
+)
+
+// Window is the event time range [Start, End)
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// MaxTimestamp is the last time within the window, used as the timestamp of window results
+func (w Window) MaxTimestamp() time.Time {
+	return w.End.Add(-time.Nanosecond)
+}
+
+func (w Window) intersects(other Window) bool {
+	return w.Start.Before(other.End) && other.Start.Before(w.End)
+}
+
+// WindowAssigner assigns windows to event times
+type WindowAssigner interface {
+	Assign(t time.Time) []Window
+	// Merging assigners, like sessions, merge intersecting windows of the key into one
+	Merging() bool
+}
+
+type tumbling struct {
+	size time.Duration
+}
+
+// Tumbling assigns events to consecutive windows of the size, e.g. hourly windows start on the hour.
+// It panics, unless the size is positive
+func Tumbling(size time.Duration) WindowAssigner {
+	if size <= 0 {
+		panic(fmt.Sprintf("tumbling window size %s should be positive", size))
+	}
+	return tumbling{size: size}
+}
+
+func (a tumbling) Assign(t time.Time) []Window {
+	start := t.Truncate(a.size)
+	return []Window{{Start: start, End: start.Add(a.size)}}
+}
+
+func (a tumbling) Merging() bool {
+	return false
+}
+
+type sliding struct {
+	size, slide time.Duration
+}
+
+// Sliding assigns events to all windows of the size starting every slide. It panics, unless the size and the slide
+// are positive and the slide doesn't exceed the size, since events between windows would belong to none
+func Sliding(size, slide time.Duration) WindowAssigner {
+	if size <= 0 || slide <= 0 {
+		panic(fmt.Sprintf("sliding window size %s and slide %s should be positive", size, slide))
+	}
+	if slide > size {
+		panic(fmt.Sprintf("sliding window slide %s exceeds the size %s", slide, size))
+	}
+	return sliding{size: size, slide: slide}
+}
+
+func (a sliding) Assign(t time.Time) (res []Window) {
+	for start := t.Truncate(a.slide); start.Add(a.size).After(t); start = start.Add(-a.slide) {
+		res = append(res, Window{Start: start, End: start.Add(a.size)})
+	}
+	return
+}
+
+func (a sliding) Merging() bool {
+	return false
+}
+
+type session struct {
+	gap time.Duration
+}
+
+// Session groups events of the key until there are no events for the gap. It panics, unless the gap is positive
+func Session(gap time.Duration) WindowAssigner {
+	if gap <= 0 {
+		panic(fmt.Sprintf("session gap %s should be positive", gap))
+	}
+	return session{gap: gap}
+}
+
+func (a session) Assign(t time.Time) []Window {
+	return []Window{{Start: t, End: t.Add(a.gap)}}
+}
+
+func (a session) Merging() bool {
+	return true
+}
+
+// IAggregate accumulates values of a window. Aggregates are merged when session windows are merged
+// or partial results of parallel instances are combined. They are saved in checkpoints as JSON, so
+// their state has to be exported
+type IAggregate interface {
+	// Add should fail depending on the value only, e.g. a missing field, and leave the aggregate unchanged then.
+	// So an event is added either to all its sliding windows or to none
+	Add(value interface{}) error
+	// Merge adds the other aggregate of the same kind
+	Merge(other IAggregate) error
+	Result() interface{}
+}
+
+// AggregateFactory creates an empty aggregate for a new window
+type AggregateFactory func() IAggregate
+
+// WindowResult is emitted, when the watermark passes the end of the window
+type WindowResult struct {
+	Key    interface{}
+	Window Window
+	Value  interface{}
+}
+
+type WindowedStream struct {
+	source   *DataStream
+	key      func(value interface{}) interface{}
+	assigner WindowAssigner
+}
+
+func (s *KeyedStream) Window(assigner WindowAssigner) *WindowedStream {
+	return &WindowedStream{
+		source:   s.DataStream,
+		key:      s.selector,
+		assigner: assigner,
+	}
+}
+
+// WindowAll windows the whole stream as a single key
+func (s *DataStream) WindowAll(assigner WindowAssigner) *WindowedStream {
+	return &WindowedStream{
+		source:   s,
+		assigner: assigner,
+	}
+}
+
+// Aggregate emits WindowResult of every window once the watermark passes its end. Events behind
+// the watermark, which fall into closed windows only, go to faults
+func (w *WindowedStream) Aggregate(factory AggregateFactory) (result *DataStream) {
+	ctx := w.source.Context()
+	result = ContextStream(ctx).Name("Window")
+	op := &windowOperator{
+		ctx:     ctx,
+		factory: factory,
+		merging: w.assigner.Merging(),
+		keyed:   w.key != nil,
+		keys:    make(map[string]*windowKey),
+		result:  result,
+	}
+	ctx.RegisterOperatorState("window", op)
+
+	w.source.BindOut(func(event *Event) {
+		m := result.Metrics()
+		m.In()
+		var key interface{}
+		if w.key != nil {
+			key = w.key(event.Payload)
+		}
+		if err := op.add(key, event, w.assigner.Assign(event.Timestamp)); err != nil {
+			result.EmitFault(event)
+		}
+	})
+	return
+}
+
+type windowOperator struct {
+	ctx     *Context
+	factory AggregateFactory
+	merging bool
+	keyed   bool
+	keys    map[string]*windowKey
+	result  *DataStream
+}
+
+type windowKey struct {
+	Key     interface{}
+	Windows []*windowAggregate
+}
+
+type windowAggregate struct {
+	Window
+	Aggregate IAggregate
+}
+
+func (o *windowOperator) add(key interface{}, event *Event, windows []Window) error {
+	id, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	watermark := o.ctx.Watermark()
+	state, ok := o.keys[string(id)]
+	if !ok {
+		state = &windowKey{Key: key}
+	}
+
+	var open []Window
+	for _, window := range windows {
+		if window.End.After(watermark) {
+			open = append(open, window)
+		}
+	}
+	if len(open) == 0 {
+		return fmt.Errorf("late event %s behind the watermark %s", event.Timestamp, watermark)
+	}
+	if o.merging {
+		for _, window := range open {
+			if err = o.window(string(id), state, window, event.Payload); err != nil {
+				return err
+			}
+		}
+	} else if err = o.slide(string(id), state, open, event.Payload); err != nil {
+		return err
+	}
+	// the key is stored with its first window, so holds of its windows are released when they fire
+	o.keys[string(id)] = state
+	return nil
+}
+
+// slide adds the payload to all windows of non-merging assigners. New windows are opened only once the payload
+// is added to every window, so a failed add leaves the key state as is
+func (o *windowOperator) slide(id string, state *windowKey, windows []Window, payload interface{}) error {
+	var opened []*windowAggregate
+	for _, window := range windows {
+		var acc *windowAggregate
+		for _, w := range state.Windows {
+			if w.Window == window {
+				acc = w
+				break
+			}
+		}
+		if acc == nil {
+			acc = &windowAggregate{Window: window, Aggregate: o.factory()}
+			opened = append(opened, acc)
+		}
+		if err := acc.Aggregate.Add(payload); err != nil {
+			return err
+		}
+	}
+	for _, acc := range opened {
+		state.Windows = append(state.Windows, acc)
+		o.hold(state.Key, true)
+		o.schedule(id, acc.End)
+	}
+	return nil
+}
+
+// window merges the window with intersecting windows of the key and adds the payload to it. The merged window
+// is opened only once the payload is added, so a failed add leaves the key state as is
+func (o *windowOperator) window(id string, state *windowKey, window Window, payload interface{}) error {
+	acc := &windowAggregate{Window: window, Aggregate: o.factory()}
+	var rest []*windowAggregate
+	for _, w := range state.Windows {
+		if !w.intersects(acc.Window) {
+			rest = append(rest, w)
+			continue
+		}
+		if w.Start.Before(acc.Start) {
+			acc.Start = w.Start
+		}
+		if w.End.After(acc.End) {
+			acc.End = w.End
+		}
+		if err := acc.Aggregate.Merge(w.Aggregate); err != nil {
+			return err
+		}
+	}
+	if err := acc.Aggregate.Add(payload); err != nil {
+		return err
+	}
+	o.hold(state.Key, true)
+	for merged := len(state.Windows) - len(rest); merged > 0; merged-- {
+		o.hold(state.Key, false)
+	}
+	state.Windows = append(rest, acc)
+	o.schedule(id, acc.End)
+	return nil
+}
+
+// hold keeps the quantum of the key assigned to the worker while the key has open windows
+func (o *windowOperator) hold(key interface{}, open bool) {
+	quanta := o.ctx.Quanta()
+	if !o.keyed || quanta == nil || quanta.Space() == 0 {
+		return
+	}
+	q, err := quantum.Of(key, quanta.Space())
+	if err != nil {
+		return
+	}
+	if open {
+		quanta.Hold(q)
+	} else {
+		quanta.Done(q)
+	}
+}
+
+func (o *windowOperator) schedule(id string, at time.Time) {
+	o.ctx.OnEventTime(at, func(t time.Time) {
+		o.fire(id, t)
+	})
+}
+
+// fire emits results of the key windows ended by the watermark in the order of their ends
+func (o *windowOperator) fire(id string, watermark time.Time) {
+	state, ok := o.keys[id]
+	if !ok {
+		return
+	}
+	sort.SliceStable(state.Windows, func(i, j int) bool {
+		return state.Windows[i].End.Before(state.Windows[j].End)
+	})
+	n := 0
+	for n < len(state.Windows) && !state.Windows[n].End.After(watermark) {
+		w := state.Windows[n]
+		o.result.Emit(&Event{
+			Timestamp: w.MaxTimestamp(),
+			Payload: WindowResult{
+				Key:    state.Key,
+				Window: w.Window,
+				Value:  w.Aggregate.Result(),
+			},
+		})
+		o.hold(state.Key, false)
+		n++
+	}
+	state.Windows = state.Windows[n:]
+	if len(state.Windows) == 0 {
+		delete(o.keys, id)
+	}
+}
+
+type windowSnapshot struct {
+	Window
+	Aggregate json.RawMessage
+}
+
+func (o *windowOperator) Snapshot() ([]byte, error) {
+	return json.Marshal(o.keys)
+}
+
+// Restore decodes aggregates into ones created by the factory and schedules windows again
+func (o *windowOperator) Restore(data []byte) error {
+	keys, err := o.decode(data)
+	if err != nil {
+		return err
+	}
+	o.keys = make(map[string]*windowKey, len(keys))
+	o.load(keys)
+	return nil
+}
+
+// RestoreKeys loads windows of the keys moved to the worker
+func (o *windowOperator) RestoreKeys(data []byte, keep func(key string) bool) error {
+	if !o.keyed {
+		return nil
+	}
+	keys, err := o.decode(data)
+	if err != nil {
+		return err
+	}
+	for id := range keys {
+		if !keep(id) {
+			delete(keys, id)
+		}
+	}
+	o.DropKeys(func(id string) bool {
+		_, ok := keys[id]
+		return ok
+	})
+	o.load(keys)
+	return nil
+}
+
+// DropKeys removes windows of the keys without emitting them. Timers of the keys find no windows.
+// Windows of unkeyed streams aren't partitioned by quanta and stay
+func (o *windowOperator) DropKeys(drop func(key string) bool) {
+	if !o.keyed {
+		return
+	}
+	for id, state := range o.keys {
+		if !drop(id) {
+			continue
+		}
+		for range state.Windows {
+			o.hold(state.Key, false)
+		}
+		delete(o.keys, id)
+	}
+}
+
+func (o *windowOperator) decode(data []byte) (map[string]*windowKey, error) {
+	raw := make(map[string]struct {
+		Key     interface{}
+		Windows []windowSnapshot
+	})
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*windowKey, len(raw))
+	for id, k := range raw {
+		state := &windowKey{Key: k.Key}
+		for _, w := range k.Windows {
+			agg := o.factory()
+			if err := json.Unmarshal(w.Aggregate, agg); err != nil {
+				return nil, fmt.Errorf("window %s: %v", w.Window.Start, err)
+			}
+			state.Windows = append(state.Windows, &windowAggregate{Window: w.Window, Aggregate: agg})
+		}
+		keys[id] = state
+	}
+	return keys, nil
+}
+
+// load adds decoded keys, holds their quanta and schedules their windows
+func (o *windowOperator) load(keys map[string]*windowKey) {
+	for id, state := range keys {
+		o.keys[id] = state
+		for _, w := range state.Windows {
+			o.hold(state.Key, true)
+			o.schedule(id, w.End)
+		}
+	}
+}
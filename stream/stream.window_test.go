@@ -0,0 +1,211 @@
+package stream_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/glinktest"
+	"github.com/discretemind/glink/stream"
+	"github.com/discretemind/glink/stream/aggregate"
+	"github.com/discretemind/glink/stream/quantum"
+	"github.com/stretchr/testify/assert"
+)
+
+var windowStart = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func clicks(user string, seconds ...int) (res []stream.Event) {
+	for _, s := range seconds {
+		res = append(res, stream.Event{
+			Timestamp: windowStart.Add(time.Duration(s) * time.Second),
+			Payload:   map[string]interface{}{"user": user, "ms": s * 10},
+		})
+	}
+	return
+}
+
+func byUser(value interface{}) interface{} {
+	return value.(map[string]interface{})["user"]
+}
+
+// windowResults formats results as "key start-end value" sorted
+func windowResults(sink *glinktest.Sink) (res []string) {
+	for _, v := range sink.Values() {
+		r := v.(stream.WindowResult)
+		res = append(res, fmt.Sprintf("%v %s-%s %v", r.Key, r.Window.Start.Sub(windowStart), r.Window.End.Sub(windowStart), r.Value))
+	}
+	sort.Strings(res)
+	return
+}
+
+func TestTumblingWindow(t *testing.T) {
+	h := glinktest.New(windowStart)
+	input, s := h.Input()
+	windowed := s.KeyBy(byUser).Window(stream.Tumbling(10 * time.Second)).Aggregate(aggregate.Count())
+	out := glinktest.Collect(windowed)
+	late := glinktest.CollectFaults(windowed)
+
+	push := func(events []stream.Event) {
+		for i := range events {
+			input.PushEvent(&events[i])
+		}
+	}
+	push(append(clicks("a", 1, 5, 12), clicks("b", 3)...))
+	assert.Empty(t, out.Values())
+
+	h.AdvanceWatermark(windowStart.Add(10 * time.Second))
+	assert.Equal(t, []string{"a 0s-10s 2", "b 0s-10s 1"}, windowResults(out))
+	assert.Equal(t, windowStart.Add(10*time.Second-time.Nanosecond), out.Events()[0].Timestamp)
+
+	push(clicks("b", 4))
+	assert.Len(t, late.Values(), 1)
+
+	out.Reset()
+	h.AdvanceWatermark(windowStart.Add(time.Minute))
+	assert.Equal(t, []string{"a 10s-20s 1"}, windowResults(out))
+}
+
+func TestSlidingWindow(t *testing.T) {
+	h := glinktest.New(windowStart)
+	s := h.FromEvents(clicks("a", 1, 7, 12)...)
+	out := glinktest.Collect(s.WindowAll(stream.Sliding(10*time.Second, 5*time.Second)).Aggregate(aggregate.Max("ms")))
+	h.Run()
+	h.AdvanceWatermark(windowStart.Add(time.Minute))
+	assert.Equal(t, []string{"<nil> -5s-5s 10", "<nil> 0s-10s 70", "<nil> 10s-20s 120", "<nil> 5s-15s 120"}, windowResults(out))
+}
+
+func TestSlidingWindowFailedAdd(t *testing.T) {
+	h := glinktest.New(windowStart)
+	input, s := h.Input()
+	windowed := s.WindowAll(stream.Sliding(10*time.Second, 5*time.Second)).Aggregate(aggregate.Max("ms"))
+	out := glinktest.Collect(windowed)
+	faults := glinktest.CollectFaults(windowed)
+	e := clicks("a", 7)[0]
+	input.PushEvent(&e)
+	input.PushEvent(&stream.Event{Timestamp: windowStart.Add(8 * time.Second), Payload: "no ms"})
+	assert.Equal(t, []interface{}{"no ms"}, faults.Values())
+
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(snapshot["window/1"]), `"Start"`), "no windows are opened by the failed event")
+	h.AdvanceWatermark(windowStart.Add(time.Minute))
+	assert.Equal(t, []string{"<nil> 0s-10s 70", "<nil> 5s-15s 70"}, windowResults(out))
+}
+
+func TestWindowAssignerArguments(t *testing.T) {
+	assert.Panics(t, func() { stream.Tumbling(0) })
+	assert.Panics(t, func() { stream.Sliding(10*time.Second, 0) })
+	assert.Panics(t, func() { stream.Sliding(-time.Second, time.Second) })
+	assert.Panics(t, func() { stream.Sliding(5*time.Second, 10*time.Second) })
+	assert.Panics(t, func() { stream.Session(0) })
+	assert.NotPanics(t, func() { stream.Sliding(10*time.Second, 10*time.Second) })
+}
+
+func TestSessionWindow(t *testing.T) {
+	h := glinktest.New(windowStart)
+	s := h.FromEvents(append(clicks("a", 1, 20, 3, 26, 5), clicks("b", 2)...)...)
+	windowed := s.KeyBy(byUser).Window(stream.Session(5 * time.Second))
+	out := glinktest.Collect(windowed.Aggregate(aggregate.Sum("ms")))
+	h.Run()
+	// the session of 1s and 5s is merged by the event at 3s
+	h.AdvanceWatermark(windowStart.Add(time.Minute))
+	assert.Equal(t, []string{"a 1s-10s 90", "a 20s-25s 200", "a 26s-31s 260", "b 2s-7s 20"}, windowResults(out))
+}
+
+func TestWindowCheckpoint(t *testing.T) {
+	build := func() (*glinktest.Harness, stream.IInputStream, *glinktest.Sink) {
+		h := glinktest.New(windowStart)
+		input, s := h.Input()
+		out := glinktest.Collect(s.KeyBy(byUser).Window(stream.Session(5 * time.Second)).Aggregate(aggregate.TopN(1, "ms")))
+		return h, input, out
+	}
+	h, input, _ := build()
+	for _, e := range clicks("a", 1, 3) {
+		e := e
+		input.PushEvent(&e)
+	}
+	snapshot, err := h.Checkpoint()
+	assert.NoError(t, err)
+
+	restored, restoredInput, out := build()
+	assert.NoError(t, restored.Context().Restore(snapshot))
+	e := clicks("a", 6)[0]
+	restoredInput.PushEvent(&e)
+	restored.AdvanceWatermark(windowStart.Add(time.Minute))
+	assert.Len(t, out.Values(), 1)
+	r := out.Values()[0].(stream.WindowResult)
+	assert.Equal(t, "a", r.Key)
+	assert.Equal(t, windowStart.Add(11*time.Second), r.Window.End)
+	assert.Equal(t, 60.0, r.Value.([]aggregate.Ranked)[0].Score)
+}
+
+func TestWindowHoldsQuantum(t *testing.T) {
+	h := glinktest.New(windowStart)
+	quanta := quantum.NewSet()
+	qa, _ := quantum.Of("a", 2)
+	assert.NoError(t, quanta.Assign(qa))
+	h.Context().SetQuanta(quanta)
+	var released []quantum.Quantum
+	quanta.OnRelease(func(q quantum.Quantum) {
+		released = append(released, q)
+	})
+
+	// keys of other quanta are processed by other workers
+	other := "b"
+	for i := 0; ; i++ {
+		if q, _ := quantum.Of(other, 2); q != qa {
+			break
+		}
+		other = fmt.Sprintf("b%d", i)
+	}
+	s := h.FromEvents(append(clicks("a", 1), clicks(other, 2)...)...)
+	out := glinktest.Collect(s.KeyBy(byUser).Window(stream.Tumbling(10 * time.Second)).Aggregate(aggregate.Count()))
+	h.Run()
+
+	quanta.Release(qa)
+	assert.Empty(t, released, "window of a is open")
+	h.AdvanceWatermark(windowStart.Add(10 * time.Second))
+	assert.Equal(t, []string{"a 0s-10s 1"}, windowResults(out))
+	assert.Equal(t, []quantum.Quantum{qa}, released)
+}
+
+func TestWindowFailedAdd(t *testing.T) {
+	h := glinktest.New(windowStart)
+	quanta := quantum.NewSet()
+	qa, _ := quantum.Of("a", 2)
+	assert.NoError(t, quanta.Assign(qa))
+	h.Context().SetQuanta(quanta)
+	var released []quantum.Quantum
+	quanta.OnRelease(func(q quantum.Quantum) {
+		released = append(released, q)
+	})
+
+	invalid := func(seconds int) stream.Event {
+		return stream.Event{
+			Timestamp: windowStart.Add(time.Duration(seconds) * time.Second),
+			Payload:   map[string]interface{}{"user": "a"},
+		}
+	}
+	input, s := h.Input()
+	windowed := s.KeyBy(byUser).Window(stream.Tumbling(10 * time.Second)).Aggregate(aggregate.Sum("ms"))
+	out := glinktest.Collect(windowed)
+	faults := glinktest.CollectFaults(windowed)
+	e := invalid(1)
+	input.PushEvent(&e)
+	assert.Len(t, faults.Events(), 1)
+	assert.Equal(t, map[uint32]uint32{qa.Index: 0}, quanta.Status(), "failed events don't hold the quantum")
+
+	// a failed event of an existing key doesn't open an empty window
+	for _, e := range append(clicks("a", 2), invalid(12)) {
+		e := e
+		input.PushEvent(&e)
+	}
+	h.AdvanceWatermark(windowStart.Add(20 * time.Second))
+	assert.Equal(t, []string{"a 0s-10s 20"}, windowResults(out))
+	assert.Len(t, faults.Events(), 2)
+
+	quanta.Release(qa)
+	assert.Equal(t, []quantum.Quantum{qa}, released)
+}
@@ -0,0 +1,34 @@
+package stream_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/discretemind/glink/glinktest"
+	"github.com/discretemind/glink/utils/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnnamedOperatorsHaveOwnMetrics(t *testing.T) {
+	h := glinktest.New(time.Now())
+	input, s := h.Input()
+	identity := func(value interface{}) (interface{}, error) {
+		return value, nil
+	}
+	first := s.Map(identity)
+	second := first.Map(identity)
+	assert.NotSame(t, first.Metrics(), second.Metrics())
+
+	input.Push(1)
+	assert.Equal(t, uint64(1), first.Metrics().RecordsOut())
+	assert.Equal(t, uint64(1), second.Metrics().RecordsOut())
+
+	// metrics of the default id are replaced by ones of the name
+	id := first.Metrics().ID()
+	first.Name("named-test")
+	buf := bytes.Buffer{}
+	assert.NoError(t, metrics.Get().Write(&buf))
+	assert.NotContains(t, buf.String(), `{operator="",id="`+id+`"}`)
+	assert.Contains(t, buf.String(), `{operator="named-test",id="`+id+`"}`)
+}
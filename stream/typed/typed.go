@@ -0,0 +1,121 @@
+// Package typed is a type safe layer over the stream runtime. Operators are checked at compile time and
+// compile down to the untyped stream operators, so both APIs can be mixed in one job.
+package typed
+
+import (
+	"fmt"
+
+	"github.com/discretemind/glink/stream"
+)
+
+type DataStream[T any] struct {
+	raw *stream.DataStream
+}
+
+type KeyedStream[T any, K comparable] struct {
+	raw *stream.KeyedStream
+}
+
+type IInputStream[T any] interface {
+	Push(value T)
+}
+
+type typedInput[T any] struct {
+	input stream.IInputStream
+}
+
+func (i typedInput[T]) Push(value T) {
+	i.input.Push(value)
+}
+
+// Input adapts a typed input function to the job task input
+func Input[T any](f func(input IInputStream[T])) func(input stream.IInputStream) {
+	return func(input stream.IInputStream) {
+		f(typedInput[T]{input: input})
+	}
+}
+
+// From wraps an untyped stream. Events with payloads of another type are sent to the stream faults
+func From[T any](s *stream.DataStream) *DataStream[T] {
+	return &DataStream[T]{
+		raw: stream.Stream(s, func(event *stream.Event) (*stream.Event, error) {
+			if _, ok := event.Payload.(T); !ok {
+				return nil, typeError[T](event.Payload)
+			}
+			return event, nil
+		}),
+	}
+}
+
+func typeError[T any](value interface{}) error {
+	var expected T
+	return fmt.Errorf("unexpected payload type %T, expected %T", value, expected)
+}
+
+func Map[T, R any](s *DataStream[T], f func(value T) (R, error)) *DataStream[R] {
+	return &DataStream[R]{
+		raw: s.raw.Map(func(value interface{}) (interface{}, error) {
+			return f(value.(T))
+		}),
+	}
+}
+
+func Filter[T any](s *DataStream[T], f func(value T) bool) *DataStream[T] {
+	return &DataStream[T]{
+		raw: s.raw.Filter(func(value interface{}) bool {
+			return f(value.(T))
+		}),
+	}
+}
+
+func KeyBy[T any, K comparable](s *DataStream[T], f func(value T) K) *KeyedStream[T, K] {
+	return &KeyedStream[T, K]{
+		raw: s.raw.KeyBy(func(value interface{}) interface{} {
+			return f(value.(T))
+		}),
+	}
+}
+
+// Raw returns the untyped stream
+func (s *DataStream[T]) Raw() *stream.DataStream {
+	return s.raw
+}
+
+func (s *DataStream[T]) Name(name string) *DataStream[T] {
+	s.raw.Name(name)
+	return s
+}
+
+func (s *DataStream[T]) ID(id string) *DataStream[T] {
+	s.raw.ID(id)
+	return s
+}
+
+func (s *DataStream[T]) Print() {
+	s.raw.Print()
+}
+
+func (s *DataStream[T]) To(sink stream.ISink) {
+	s.raw.To(sink)
+}
+
+// Sink binds a typed handler to the stream output
+func (s *DataStream[T]) Sink(f func(value T)) {
+	s.raw.BindOut(func(event *stream.Event) {
+		f(event.Payload.(T))
+	})
+}
+
+// Raw returns the untyped keyed stream
+func (s *KeyedStream[T, K]) Raw() *stream.KeyedStream {
+	return s.raw
+}
+
+// Stream returns keyed events as a plain stream
+func (s *KeyedStream[T, K]) Stream() *DataStream[T] {
+	return &DataStream[T]{raw: s.raw.DataStream}
+}
+
+func (s *KeyedStream[T, K]) Key(value T) K {
+	return s.raw.Key(&stream.Event{Payload: value}).(K)
+}
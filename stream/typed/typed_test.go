@@ -0,0 +1,49 @@
+package typed
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/discretemind/glink/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+type order struct {
+	Customer string
+	Amount   int
+}
+
+func TestTypedPipeline(t *testing.T) {
+	input := stream.InputStream()
+	orders := From[order](input.DataStream)
+
+	var faults []interface{}
+	orders.Raw().BindFault(func(event *stream.Event) {
+		faults = append(faults, event.Payload)
+	})
+
+	var result []string
+	large := Filter(orders, func(o order) bool {
+		return o.Amount >= 100
+	})
+	Map(large, func(o order) (string, error) {
+		return o.Customer + ":" + strconv.Itoa(o.Amount), nil
+	}).Sink(func(value string) {
+		result = append(result, value)
+	})
+
+	keyed := KeyBy(orders, func(o order) string {
+		return o.Customer
+	})
+	assert.Equal(t, "bob", keyed.Key(order{Customer: "bob"}))
+
+	push := Input(func(in IInputStream[order]) {
+		in.Push(order{"alice", 150})
+		in.Push(order{"bob", 50})
+	})
+	push(input)
+	input.Push("not an order")
+
+	assert.Equal(t, []string{"alice:150"}, result)
+	assert.Equal(t, []interface{}{"not an order"}, faults)
+}
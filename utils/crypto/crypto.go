@@ -65,8 +65,14 @@ func (pk PrivateKey) Public() (res PublicKey) {
 	return
 }
 
+// Messages are encrypted by chunks. Every encrypted chunk carries its GCM nonce and tag
+const (
+	chunkSize     = 300
+	chunkOverhead = 12 + 16
+)
+
 func (pk PrivateKey) Encrypt(peerKey [32]byte, message []byte) (result []byte, err error) {
-	lim := 300
+	lim := chunkSize
 	buf := message[:]
 	shared, err := sharedKey(pk[:32], peerKey[:])
 	if err != nil {
@@ -86,7 +92,7 @@ func (pk PrivateKey) Encrypt(peerKey [32]byte, message []byte) (result []byte, e
 }
 
 func (pk PrivateKey) Decrypt(peerKey [32]byte, message []byte) (result []byte, ok bool) {
-	lim := 340
+	lim := chunkSize + chunkOverhead
 	buf := message[:]
 	shared, err := sharedKey(pk[:32], peerKey[:])
 	if err != nil {
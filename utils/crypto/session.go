@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// SessionKey encrypts messages of one direction of a session. Nonces are built from message counters,
+// so a counter must never be sealed twice with the same key
+type SessionKey [32]byte
+
+// SessionKeys derives keys of both directions from the ephemeral key and the ephemeral key of the peer.
+// Salt binds keys to the handshake. The initiator sends with the first key and the responder with the second
+func SessionKeys(ephemeral PrivateKey, peer PublicKey, salt, info []byte) (first, second SessionKey, err error) {
+	shared, err := sharedKey(ephemeral[:32], peer[:])
+	if err != nil {
+		return
+	}
+	r := hkdf.New(sha256.New, shared, salt, info)
+	if _, err = io.ReadFull(r, first[:]); err != nil {
+		return
+	}
+	_, err = io.ReadFull(r, second[:])
+	return
+}
+
+// Derive returns an independent key for the label
+func (k SessionKey) Derive(label string) (res SessionKey) {
+	h := hmac.New(sha256.New, k[:])
+	h.Write([]byte(label))
+	copy(res[:], h.Sum(nil))
+	return
+}
+
+// Next is the key of the next epoch. Previous keys can't be derived from it
+func (k SessionKey) Next() SessionKey {
+	return k.Derive("next")
+}
+
+func (k SessionKey) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func counterNonce(counter uint64, size int) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-8:], counter)
+	return nonce
+}
+
+// Seal encrypts the message and authenticates it with data
+func (k SessionKey) Seal(counter uint64, message, data []byte) ([]byte, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, counterNonce(counter, gcm.NonceSize()), message, data), nil
+}
+
+// Open decrypts the message sealed with the counter and data
+func (k SessionKey) Open(counter uint64, ciphertext, data []byte) ([]byte, bool) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, false
+	}
+	res, err := gcm.Open(nil, counterNonce(counter, gcm.NonceSize()), ciphertext, data)
+	return res, err == nil
+}
@@ -12,6 +12,18 @@ func Init(isProductionMode bool, fields ...zap.Field) *zap.Logger {
 	return instance
 }
 
+// InitLevel initializes the logger with the level name: debug, info, warn or error
+func InitLevel(isProductionMode bool, level string, fields ...zap.Field) (*zap.Logger, error) {
+	l := zapcore.InfoLevel
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, err
+		}
+	}
+	instance = newLogger(isProductionMode, l).With(fields...)
+	return instance, nil
+}
+
 func Get() (res *zap.Logger) {
 	if instance != nil {
 		return instance
@@ -36,7 +48,7 @@ func Error(msg string, fields ...zap.Field) {
 }
 
 // Info lo
-func newLogger(isProductionMode bool) (res *zap.Logger) {
+func newLogger(isProductionMode bool, level ...zapcore.Level) (res *zap.Logger) {
 	cfg := zap.Config{}
 	if isProductionMode {
 		cfg = zap.NewProductionConfig()
@@ -44,6 +56,9 @@ func newLogger(isProductionMode bool) (res *zap.Logger) {
 		cfg = zap.NewDevelopmentConfig()
 		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
+	if len(level) > 0 {
+		cfg.Level = zap.NewAtomicLevelAt(level[0])
+	}
 
 	res, _ = cfg.Build()
 	return res
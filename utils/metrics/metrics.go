@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var instance = NewRegistry()
+
+// Get returns the process wide registry
+func Get() *Registry {
+	return instance
+}
+
+// Operator returns metrics of the operator from the process wide registry
+func Operator(name, id string) *OperatorMetrics {
+	return instance.Operator(name, id)
+}
+
+// RemoveOperator drops metrics of the operator from the process wide registry
+func RemoveOperator(name, id string) {
+	instance.RemoveOperator(name, id)
+}
+
+// Host updates host metrics of the node in the process wide registry
+func Host(node string, m HostMetrics) {
+	instance.Host(node, m)
+}
+
+type operatorKey struct {
+	name string
+	id   string
+}
+
+type Registry struct {
+	sync.RWMutex
+	operators map[operatorKey]*OperatorMetrics
+	hosts     map[string]HostMetrics
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		operators: make(map[operatorKey]*OperatorMetrics),
+		hosts:     make(map[string]HostMetrics),
+	}
+}
+
+// OperatorMetrics are counters of a single operator. All methods are safe for concurrent use
+type OperatorMetrics struct {
+	name, id     string
+	in, out      uint64
+	errors       uint64
+	backpressure uint64
+	dropped      uint64
+	latencyNanos uint64
+	latencyCount uint64
+	stateEntries int64
+	stateBytes   int64
+}
+
+type HostMetrics struct {
+	// CPU usage in hundredths of percent
+	CpuUsage                   uint32
+	MemTotal, MemUsed, MemFree uint32
+	Updated                    time.Time
+}
+
+func (r *Registry) Operator(name, id string) (res *OperatorMetrics) {
+	key := operatorKey{name: name, id: id}
+	r.RLock()
+	res, ok := r.operators[key]
+	r.RUnlock()
+	if ok {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if res, ok = r.operators[key]; !ok {
+		res = &OperatorMetrics{name: name, id: id}
+		r.operators[key] = res
+	}
+	return
+}
+
+func (r *Registry) RemoveOperator(name, id string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.operators, operatorKey{name: name, id: id})
+}
+
+func (r *Registry) Host(node string, m HostMetrics) {
+	if m.Updated.IsZero() {
+		m.Updated = time.Now()
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.hosts[node] = m
+}
+
+// RemoveHost drops metrics of a node, which left the cluster
+func (r *Registry) RemoveHost(node string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.hosts, node)
+}
+
+func (r *Registry) operatorList() (res []*OperatorMetrics) {
+	r.RLock()
+	for _, m := range r.operators {
+		res = append(res, m)
+	}
+	r.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].name != res[j].name {
+			return res[i].name < res[j].name
+		}
+		return res[i].id < res[j].id
+	})
+	return
+}
+
+func (r *Registry) hostList() (nodes []string, res []HostMetrics) {
+	r.RLock()
+	for node := range r.hosts {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		res = append(res, r.hosts[node])
+	}
+	r.RUnlock()
+	return
+}
+
+func (m *OperatorMetrics) Name() string {
+	return m.name
+}
+
+func (m *OperatorMetrics) ID() string {
+	return m.id
+}
+
+func (m *OperatorMetrics) In() {
+	atomic.AddUint64(&m.in, 1)
+}
+
+func (m *OperatorMetrics) Out() {
+	atomic.AddUint64(&m.out, 1)
+}
+
+func (m *OperatorMetrics) Error() {
+	atomic.AddUint64(&m.errors, 1)
+}
+
+// Backpressure counts records, which were rejected or delayed because downstream was busy
+func (m *OperatorMetrics) Backpressure() {
+	atomic.AddUint64(&m.backpressure, 1)
+}
+
+// Drop counts records filtered out on purpose, e.g. duplicates
+func (m *OperatorMetrics) Drop() {
+	atomic.AddUint64(&m.dropped, 1)
+}
+
+// State sets the size of the operator state. Bytes is an estimation of the memory used by it
+func (m *OperatorMetrics) State(entries, bytes int64) {
+	atomic.StoreInt64(&m.stateEntries, entries)
+	atomic.StoreInt64(&m.stateBytes, bytes)
+}
+
+func (m *OperatorMetrics) Latency(d time.Duration) {
+	atomic.AddUint64(&m.latencyNanos, uint64(d))
+	atomic.AddUint64(&m.latencyCount, 1)
+}
+
+func (m *OperatorMetrics) RecordsIn() uint64 {
+	return atomic.LoadUint64(&m.in)
+}
+
+func (m *OperatorMetrics) RecordsOut() uint64 {
+	return atomic.LoadUint64(&m.out)
+}
+
+func (m *OperatorMetrics) Errors() uint64 {
+	return atomic.LoadUint64(&m.errors)
+}
+
+func (m *OperatorMetrics) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+func (m *OperatorMetrics) StateEntries() int64 {
+	return atomic.LoadInt64(&m.stateEntries)
+}
+
+func (m *OperatorMetrics) StateBytes() int64 {
+	return atomic.LoadInt64(&m.stateBytes)
+}
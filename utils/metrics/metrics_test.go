@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusFormat(t *testing.T) {
+	r := NewRegistry()
+	m := r.Operator("Filtered \"X\"", "filter-1")
+	m.In()
+	m.In()
+	m.Out()
+	m.Error()
+	m.Latency(1500 * time.Millisecond)
+	m.Drop()
+	m.State(3, 96)
+	assert.Equal(t, m, r.Operator("Filtered \"X\"", "filter-1"))
+
+	r.Host("node-1", HostMetrics{CpuUsage: 1250, MemTotal: 1024, Updated: time.Unix(100, 0)})
+
+	buf := bytes.Buffer{}
+	assert.NoError(t, r.Write(&buf))
+	out := buf.String()
+
+	labels := `{operator="Filtered \"X\"",id="filter-1"}`
+	for _, line := range []string{
+		"# TYPE glink_operator_records_in_total counter",
+		"glink_operator_records_in_total" + labels + " 2",
+		"glink_operator_records_out_total" + labels + " 1",
+		"glink_operator_errors_total" + labels + " 1",
+		"glink_operator_backpressure_total" + labels + " 0",
+		"glink_operator_records_dropped_total" + labels + " 1",
+		"# TYPE glink_operator_state_entries gauge",
+		"glink_operator_state_entries" + labels + " 3",
+		"glink_operator_state_bytes" + labels + " 96",
+		"glink_operator_latency_seconds_sum" + labels + " 1.5",
+		"glink_operator_latency_seconds_count" + labels + " 1",
+		`glink_host_cpu_usage_percent{node="node-1"} 12.5`,
+		`glink_host_memory_total_megabytes{node="node-1"} 1024`,
+		`glink_host_last_update_timestamp_seconds{node="node-1"} 100`,
+	} {
+		assert.True(t, strings.Contains(out, line+"\n"), line)
+	}
+}
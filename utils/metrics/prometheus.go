@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/discretemind/glink/utils/log"
+	"go.uber.org/zap"
+)
+
+// ServeHTTP writes metrics in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = r.Write(w)
+}
+
+// Serve starts an HTTP server exposing the process wide registry on /metrics. Errors of the server are logged
+func Serve(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", instance)
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("metrics server failed", zap.String("addr", addr), zap.Error(err))
+		}
+	}()
+	return server
+}
+
+type metricWriter struct {
+	*bufio.Writer
+}
+
+func (w metricWriter) header(name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w metricWriter) value(name string, labels string, value interface{}) {
+	fmt.Fprintf(w, "%s{%s} %v\n", name, labels, value)
+}
+
+func (r *Registry) Write(out io.Writer) error {
+	w := metricWriter{bufio.NewWriter(out)}
+
+	operators := r.operatorList()
+	counters := []struct {
+		name, help string
+		value      func(m *OperatorMetrics) uint64
+	}{
+		{"glink_operator_records_in_total", "Records received by the operator", func(m *OperatorMetrics) uint64 { return m.RecordsIn() }},
+		{"glink_operator_records_out_total", "Records emitted by the operator", func(m *OperatorMetrics) uint64 { return m.RecordsOut() }},
+		{"glink_operator_errors_total", "Records failed by the operator", func(m *OperatorMetrics) uint64 { return m.Errors() }},
+		{"glink_operator_backpressure_total", "Records rejected or delayed because of backpressure", func(m *OperatorMetrics) uint64 { return atomic.LoadUint64(&m.backpressure) }},
+		{"glink_operator_records_dropped_total", "Records dropped by the operator, e.g. duplicates", func(m *OperatorMetrics) uint64 { return m.Dropped() }},
+	}
+	for _, c := range counters {
+		w.header(c.name, "counter", c.help)
+		for _, m := range operators {
+			w.value(c.name, operatorLabels(m), c.value(m))
+		}
+	}
+
+	w.header("glink_operator_latency_seconds", "summary", "Record processing time of the operator")
+	for _, m := range operators {
+		labels := operatorLabels(m)
+		nanos := atomic.LoadUint64(&m.latencyNanos)
+		w.value("glink_operator_latency_seconds_sum", labels, time.Duration(nanos).Seconds())
+		w.value("glink_operator_latency_seconds_count", labels, atomic.LoadUint64(&m.latencyCount))
+	}
+
+	operatorGauges := []struct {
+		name, help string
+		value      func(m *OperatorMetrics) int64
+	}{
+		{"glink_operator_state_entries", "Entries in the operator state", func(m *OperatorMetrics) int64 { return m.StateEntries() }},
+		{"glink_operator_state_bytes", "Estimated memory used by the operator state", func(m *OperatorMetrics) int64 { return m.StateBytes() }},
+	}
+	for _, g := range operatorGauges {
+		w.header(g.name, "gauge", g.help)
+		for _, m := range operators {
+			w.value(g.name, operatorLabels(m), g.value(m))
+		}
+	}
+
+	nodes, hosts := r.hostList()
+	gauges := []struct {
+		name, help string
+		value      func(m HostMetrics) float64
+	}{
+		{"glink_host_cpu_usage_percent", "Host CPU usage", func(m HostMetrics) float64 { return float64(m.CpuUsage) / 100 }},
+		{"glink_host_memory_total_megabytes", "Host total memory", func(m HostMetrics) float64 { return float64(m.MemTotal) }},
+		{"glink_host_memory_used_megabytes", "Host used memory", func(m HostMetrics) float64 { return float64(m.MemUsed) }},
+		{"glink_host_memory_free_megabytes", "Host free memory", func(m HostMetrics) float64 { return float64(m.MemFree) }},
+		{"glink_host_last_update_timestamp_seconds", "Time of the last host metrics update", func(m HostMetrics) float64 { return float64(m.Updated.Unix()) }},
+	}
+	for _, g := range gauges {
+		w.header(g.name, "gauge", g.help)
+		for i, m := range hosts {
+			w.value(g.name, label("node", nodes[i]), g.value(m))
+		}
+	}
+	return w.Flush()
+}
+
+func operatorLabels(m *OperatorMetrics) string {
+	return label("operator", m.name) + "," + label("id", m.id)
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+
+func label(name, value string) string {
+	return name + "=\"" + labelEscaper.Replace(value) + "\""
+}